@@ -0,0 +1,273 @@
+// Package blocks reads Fabric blocks offline, either protobuf block files
+// such as server/artifacts/channel/genesis.block or the JSON the REST
+// gateway answers GET /channels/:channelName/blocks/:blockId with, and
+// explains the validation code and read-write set of every transaction.
+package blocks
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Version is the height of the transaction that last wrote a key.
+type Version struct {
+	BlockNum uint64 `json:"blockNum"`
+	TxNum    uint64 `json:"txNum"`
+}
+
+func (v *Version) String() string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", v.BlockNum, v.TxNum)
+}
+
+// Read is a key read during simulation. A nil Version means the key did
+// not exist.
+type Read struct {
+	Namespace string   `json:"namespace"`
+	Key       string   `json:"key"`
+	Version   *Version `json:"version"`
+}
+
+// RangeQuery is a range read during simulation, such as the partial
+// composite key query of getAmount, with the keys it returned.
+type RangeQuery struct {
+	Namespace string `json:"namespace"`
+	StartKey  string `json:"startKey"`
+	EndKey    string `json:"endKey"`
+	Exhausted bool   `json:"exhausted"`
+	Reads     []Read `json:"reads"`
+}
+
+type Write struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	IsDelete  bool   `json:"isDelete"`
+	Value     string `json:"value"`
+}
+
+type Transaction struct {
+	TxID      string   `json:"txId"`
+	ChannelID string   `json:"channelId"`
+	Type      string   `json:"type"`
+	Timestamp string   `json:"timestamp"`
+	Chaincode string   `json:"chaincode,omitempty"`
+	Function  string   `json:"function,omitempty"`
+	Args      []string `json:"args,omitempty"`
+	// ValidationCode is the pb.TxValidationCode name the committing peer
+	// gave, e.g. VALID or MVCC_READ_CONFLICT.
+	ValidationCode string       `json:"validationCode"`
+	Reads          []Read       `json:"reads,omitempty"`
+	RangeQueries   []RangeQuery `json:"rangeQueries,omitempty"`
+	Writes         []Write      `json:"writes,omitempty"`
+}
+
+func (t *Transaction) Valid() bool {
+	return t.ValidationCode == pb.TxValidationCode_VALID.String()
+}
+
+// Conflict reports whether the transaction was invalidated by a concurrent
+// write to a key or range it read.
+func (t *Transaction) Conflict() bool {
+	return t.ValidationCode == pb.TxValidationCode_MVCC_READ_CONFLICT.String() ||
+		t.ValidationCode == pb.TxValidationCode_PHANTOM_READ_CONFLICT.String()
+}
+
+type Block struct {
+	Number       uint64         `json:"number"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+func validationCode(code int32) string {
+	if name, exists := pb.TxValidationCode_name[code]; exists {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", code)
+}
+
+func headerType(headerType int32) string {
+	if name, exists := common.HeaderType_name[headerType]; exists {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", headerType)
+}
+
+// ParseBlock reads a protobuf block or the JSON of a block.
+func ParseBlock(data []byte) (*Block, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJSONBlock(trimmed)
+	}
+	return parseProtoBlock(data)
+}
+
+func parseProtoBlock(data []byte) (*Block, error) {
+	block := &common.Block{}
+	if err := proto.Unmarshal(data, block); err != nil {
+		return nil, fmt.Errorf("not a block: %s", err.Error())
+	}
+	if block.Header == nil || block.Data == nil {
+		return nil, fmt.Errorf("not a block: missing header or data")
+	}
+
+	var filter []byte
+	if block.Metadata != nil && len(block.Metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	result := &Block{Number: block.Header.Number}
+	for i, envelopeBytes := range block.Data.Data {
+		transaction, err := parseProtoTransaction(envelopeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("block %d transaction %d: %s", block.Header.Number, i, err.Error())
+		}
+		transaction.ValidationCode = pb.TxValidationCode_NOT_VALIDATED.String()
+		if i < len(filter) {
+			transaction.ValidationCode = validationCode(int32(filter[i]))
+		}
+		result.Transactions = append(result.Transactions, transaction)
+	}
+	return result, nil
+}
+
+func parseProtoTransaction(envelopeBytes []byte) (*Transaction, error) {
+	envelope := &common.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, err
+	}
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, err
+	}
+	if payload.Header == nil {
+		return nil, fmt.Errorf("missing payload header")
+	}
+	channelHeader := &common.ChannelHeader{}
+	if err := proto.Unmarshal(payload.Header.ChannelHeader, channelHeader); err != nil {
+		return nil, err
+	}
+	transaction := &Transaction{TxID: channelHeader.TxId, ChannelID: channelHeader.ChannelId, Type: headerType(channelHeader.Type)}
+	if timestamp, err := ptypes.Timestamp(channelHeader.Timestamp); err == nil {
+		transaction.Timestamp = timestamp.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	if channelHeader.Type != int32(common.HeaderType_ENDORSER_TRANSACTION) {
+		return transaction, nil
+	}
+
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(payload.Data, tx); err != nil {
+		return nil, err
+	}
+	for _, action := range tx.Actions {
+		actionPayload := &pb.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, actionPayload); err != nil {
+			return nil, err
+		}
+		proposalPayload := &pb.ChaincodeProposalPayload{}
+		if err := proto.Unmarshal(actionPayload.ChaincodeProposalPayload, proposalPayload); err != nil {
+			return nil, err
+		}
+		invocation := &pb.ChaincodeInvocationSpec{}
+		if err := proto.Unmarshal(proposalPayload.Input, invocation); err != nil {
+			return nil, err
+		}
+		if spec := invocation.ChaincodeSpec; spec != nil && transaction.Chaincode == "" {
+			if spec.ChaincodeId != nil {
+				transaction.Chaincode = spec.ChaincodeId.Name
+			}
+			if spec.Input != nil {
+				setInput(transaction, spec.Input.Args)
+			}
+		}
+
+		if actionPayload.Action == nil {
+			continue
+		}
+		responsePayload := &pb.ProposalResponsePayload{}
+		if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+			return nil, err
+		}
+		chaincodeAction := &pb.ChaincodeAction{}
+		if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+			return nil, err
+		}
+		readWriteSet := &rwset.TxReadWriteSet{}
+		if err := proto.Unmarshal(chaincodeAction.Results, readWriteSet); err != nil {
+			return nil, err
+		}
+		for _, namespaceSet := range readWriteSet.NsRwset {
+			kvSet := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(namespaceSet.Rwset, kvSet); err != nil {
+				return nil, err
+			}
+			addKVRWSet(transaction, namespaceSet.Namespace, kvSet)
+		}
+	}
+	return transaction, nil
+}
+
+func setInput(transaction *Transaction, args [][]byte) {
+	for i, arg := range args {
+		if i == 0 {
+			transaction.Function = string(arg)
+		} else {
+			transaction.Args = append(transaction.Args, string(arg))
+		}
+	}
+}
+
+func protoVersion(version *kvrwset.Version) *Version {
+	if version == nil {
+		return nil
+	}
+	return &Version{version.BlockNum, version.TxNum}
+}
+
+func addKVRWSet(transaction *Transaction, namespace string, kvSet *kvrwset.KVRWSet) {
+	for _, read := range kvSet.Reads {
+		transaction.Reads = append(transaction.Reads, Read{namespace, read.Key, protoVersion(read.Version)})
+	}
+	for _, info := range kvSet.RangeQueriesInfo {
+		rangeQuery := RangeQuery{Namespace: namespace, StartKey: info.StartKey, EndKey: info.EndKey, Exhausted: info.ItrExhausted}
+		if rawReads := info.GetRawReads(); rawReads != nil {
+			for _, read := range rawReads.KvReads {
+				rangeQuery.Reads = append(rangeQuery.Reads, Read{namespace, read.Key, protoVersion(read.Version)})
+			}
+		}
+		transaction.RangeQueries = append(transaction.RangeQueries, rangeQuery)
+	}
+	for _, write := range kvSet.Writes {
+		transaction.Writes = append(transaction.Writes, Write{namespace, write.Key, write.IsDelete, string(write.Value)})
+	}
+}
+
+// FormatKey renders a composite key as objectType("a", "b") so that the
+// 0x00 separators are visible, and quotes other keys that are not
+// printable.
+func FormatKey(key string) string {
+	if !strings.HasPrefix(key, "\x00") {
+		return FormatValue(key)
+	}
+	parts := strings.Split(strings.TrimSuffix(key[1:], "\x00"), "\x00")
+	attributes := make([]string, 0, len(parts)-1)
+	for _, attribute := range parts[1:] {
+		attributes = append(attributes, fmt.Sprintf("%q", attribute))
+	}
+	return parts[0] + "(" + strings.Join(attributes, ", ") + ")"
+}
+
+func FormatValue(value string) string {
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f || r == 0xFFFD {
+			return fmt.Sprintf("%q", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,185 @@
+package blocks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/hyperledger/fabric/protos/ledger/rwset"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const marbleKey = "\x00Transfer/name/sender/receiver/amount/txid\x00redMarbles\x00"
+
+func marshal(t *testing.T, message proto.Message) []byte {
+	messageBytes, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return messageBytes
+}
+
+// endorserEnvelope builds the envelope a peer commits for an invoke of
+// chaincode with the given read-write set.
+func endorserEnvelope(t *testing.T, txID, chaincode string, args []string, kvSet *kvrwset.KVRWSet) []byte {
+	var input [][]byte
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		ChaincodeId: &pb.ChaincodeID{Name: chaincode}, Input: &pb.ChaincodeInput{Args: input}}}
+	results := &rwset.TxReadWriteSet{NsRwset: []*rwset.NsReadWriteSet{{Namespace: chaincode, Rwset: marshal(t, kvSet)}}}
+	responsePayload := &pb.ProposalResponsePayload{Extension: marshal(t, &pb.ChaincodeAction{Results: marshal(t, results)})}
+	actionPayload := &pb.ChaincodeActionPayload{
+		ChaincodeProposalPayload: marshal(t, &pb.ChaincodeProposalPayload{Input: marshal(t, invocation)}),
+		Action:                   &pb.ChaincodeEndorsedAction{ProposalResponsePayload: marshal(t, responsePayload)},
+	}
+	transaction := &pb.Transaction{Actions: []*pb.TransactionAction{{Payload: marshal(t, actionPayload)}}}
+	channelHeader := &common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), ChannelId: "mychannel",
+		TxId: txID, Timestamp: ptypes.TimestampNow()}
+	payload := &common.Payload{Header: &common.Header{ChannelHeader: marshal(t, channelHeader)}, Data: marshal(t, transaction)}
+	return marshal(t, &common.Envelope{Payload: marshal(t, payload)})
+}
+
+func transferRWSet(receiver, txID string) *kvrwset.KVRWSet {
+	return &kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{{Key: "redMarbles", Version: &kvrwset.Version{BlockNum: 3}}},
+		RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: marbleKey, EndKey: marbleKey + "\U0010ffff", ItrExhausted: true,
+			ReadsInfo: &kvrwset.RangeQueryInfo_RawReads{RawReads: &kvrwset.QueryReads{KvReads: []*kvrwset.KVRead{
+				{Key: marbleKey + "\x00alice\x00100000\x00tx0\x00", Version: &kvrwset.Version{BlockNum: 3}}}}}}},
+		Writes: []*kvrwset.KVWrite{{Key: marbleKey + "alice\x00" + receiver + "\x0020000\x00" + txID + "\x00", Value: []byte{0x00}}},
+	}
+}
+
+func Test_BLOCKS_ParseBlock_proto_success(t *testing.T) {
+	block := &common.Block{
+		Header: &common.BlockHeader{Number: 4},
+		Data: &common.BlockData{Data: [][]byte{
+			endorserEnvelope(t, "tx1", "marblehighthroughput", []string{"transferMarbles", "redMarbles", "alice", "bob", "20000"}, transferRWSet("bob", "tx1")),
+			endorserEnvelope(t, "tx2", "marblehighthroughput", []string{"transferMarbles", "redMarbles", "alice", "carol", "20000"}, transferRWSet("carol", "tx2")),
+		}},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, {byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_PHANTOM_READ_CONFLICT)}, {}}},
+	}
+	parsed, err := ParseBlock(marshal(t, block))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if parsed.Number != 4 || len(parsed.Transactions) != 2 {
+		t.Fatalf("block %d with %d transactions", parsed.Number, len(parsed.Transactions))
+	}
+	first, second := parsed.Transactions[0], parsed.Transactions[1]
+	if first.TxID != "tx1" || first.Type != "ENDORSER_TRANSACTION" || first.Chaincode != "marblehighthroughput" ||
+		first.Function != "transferMarbles" || strings.Join(first.Args, ",") != "redMarbles,alice,bob,20000" {
+		t.Errorf("first transaction %+v", first)
+	}
+	if !first.Valid() || second.ValidationCode != "PHANTOM_READ_CONFLICT" || !second.Conflict() {
+		t.Errorf("validation codes %s and %s", first.ValidationCode, second.ValidationCode)
+	}
+	if len(first.Reads) != 1 || first.Reads[0].Key != "redMarbles" || first.Reads[0].Version.String() != "3:0" {
+		t.Errorf("reads %+v", first.Reads)
+	}
+	if len(first.RangeQueries) != 1 || first.RangeQueries[0].StartKey != marbleKey || len(first.RangeQueries[0].Reads) != 1 {
+		t.Errorf("range queries %+v", first.RangeQueries)
+	}
+	if len(first.Writes) != 1 || FormatKey(first.Writes[0].Key) != `Transfer/name/sender/receiver/amount/txid("redMarbles", "alice", "bob", "20000", "tx1")` ||
+		first.Writes[0].Value != "\x00" {
+		t.Errorf("writes %+v", first.Writes)
+	}
+}
+
+func Test_BLOCKS_ParseBlock_json_success(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/block_sdk.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parsed, err := ParseBlock(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if parsed.Number != 4 || len(parsed.Transactions) != 2 {
+		t.Fatalf("block %d with %d transactions", parsed.Number, len(parsed.Transactions))
+	}
+	second := parsed.Transactions[1]
+	if second.TxID != "tx2" || second.Type != "ENDORSER_TRANSACTION" || second.ValidationCode != "MVCC_READ_CONFLICT" ||
+		second.Function != "transferMarbles" || strings.Join(second.Args, ",") != "redMarbles,alice,carol,20000" {
+		t.Errorf("second transaction %+v", second)
+	}
+	// lscc and the chaincode namespace
+	if len(second.Reads) != 2 || second.Reads[1].Namespace != "marblehighthroughput" || second.Reads[1].Version.String() != "3:0" {
+		t.Errorf("reads %+v", second.Reads)
+	}
+	if len(second.RangeQueries) != 1 || len(second.RangeQueries[0].Reads) != 1 || len(second.Writes) != 1 || second.Writes[0].Value != "\x00" {
+		t.Errorf("read-write set %+v %+v", second.RangeQueries, second.Writes)
+	}
+}
+
+func Test_BLOCKS_ParseBlock_genesis_success(t *testing.T) {
+	data, err := ioutil.ReadFile("../../server/artifacts/channel/genesis.block")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	parsed, err := ParseBlock(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if parsed.Number != 0 || len(parsed.Transactions) != 1 || parsed.Transactions[0].Type != "CONFIG" || parsed.Transactions[0].Chaincode != "" {
+		t.Errorf("genesis block %+v", parsed.Transactions[0])
+	}
+}
+
+func Test_BLOCKS_ParseBlock_fail(t *testing.T) {
+	if _, err := ParseBlock([]byte(`{"success":false,"message":"Failed to authenticate token"}`)); err == nil || !strings.Contains(err.Error(), "missing header") {
+		t.Errorf("ParseBlock returned %v for a gateway error", err)
+	}
+	if _, err := ParseBlock([]byte("Error: Entry not found in index")); err == nil {
+		t.Errorf("ParseBlock parsed a text error")
+	}
+}
+
+func Test_BLOCKS_Contention_success(t *testing.T) {
+	data, _ := ioutil.ReadFile("testdata/block_sdk.json")
+	parsed, err := ParseBlock(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contention := Contention([]*Block{parsed})
+	// the keys both transfers read conflict once, the written delta row is last
+	if contention[0].Conflicts != 1 || contention[0].Reads != 2 {
+		t.Errorf("most contended key %+v", contention[0])
+	}
+	last := contention[len(contention)-1]
+	if last.Writes != 1 || last.Conflicts != 0 || !strings.Contains(last.String(), `"bob"`) {
+		t.Errorf("least contended key %+v", last)
+	}
+	for _, key := range contention {
+		if strings.Contains(key.String(), `"carol"`) {
+			t.Errorf("write of the invalid transaction was counted: %s", key)
+		}
+	}
+
+	var printed bytes.Buffer
+	Print(&printed, []*Block{parsed}, 3)
+	for _, expected := range []string{
+		"block 4, 2 transactions",
+		"tx2 MVCC_READ_CONFLICT",
+		"marblehighthroughput transferMarbles(redMarbles, alice, carol, 20000)",
+		`range  marblehighthroughput Transfer/name/sender/receiver/amount/txid("redMarbles")`,
+		"      1  MVCC_READ_CONFLICT",
+		"most contended keys",
+	} {
+		if !strings.Contains(printed.String(), expected) {
+			t.Errorf("output does not contain %q:\n%s", expected, printed.String())
+		}
+	}
+	if lines := strings.Count(printed.String()[strings.Index(printed.String(), "most contended keys"):], "\n"); lines != 5 {
+		t.Errorf("top 3 printed %d lines", lines)
+	}
+}
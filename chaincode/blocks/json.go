@@ -0,0 +1,214 @@
+package blocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// The node SDK renders 64 bit integers as strings, enums as numbers or
+// names depending on the version and byte fields as Buffer objects, i.e.
+// {"type": "Buffer", "data": [104, 105]}.
+
+type jsonNumber uint64
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	*n = jsonNumber(value)
+	return err
+}
+
+type jsonText string
+
+func (t *jsonText) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case string:
+		*t = jsonText(value)
+	case float64:
+		*t = jsonText(strconv.FormatInt(int64(value), 10))
+	}
+	return nil
+}
+
+type jsonBuffer []byte
+
+func (b *jsonBuffer) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*b = jsonBuffer(text)
+		return nil
+	}
+	buffer := struct {
+		Data []int `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &buffer); err != nil {
+		return err
+	}
+	*b = make(jsonBuffer, len(buffer.Data))
+	for i, value := range buffer.Data {
+		(*b)[i] = byte(value)
+	}
+	return nil
+}
+
+type jsonVersion struct {
+	BlockNum jsonNumber `json:"block_num"`
+	TxNum    jsonNumber `json:"tx_num"`
+}
+
+type jsonRead struct {
+	Key     string       `json:"key"`
+	Version *jsonVersion `json:"version"`
+}
+
+func (r jsonRead) read(namespace string) Read {
+	read := Read{Namespace: namespace, Key: r.Key}
+	if r.Version != nil {
+		read.Version = &Version{uint64(r.Version.BlockNum), uint64(r.Version.TxNum)}
+	}
+	return read
+}
+
+type jsonEnvelope struct {
+	Payload struct {
+		Header struct {
+			ChannelHeader struct {
+				Type      jsonText `json:"type"`
+				TxID      string   `json:"tx_id"`
+				Timestamp string   `json:"timestamp"`
+				ChannelID string   `json:"channel_id"`
+			} `json:"channel_header"`
+		} `json:"header"`
+		Data struct {
+			Actions []struct {
+				Payload struct {
+					ChaincodeProposalPayload struct {
+						Input struct {
+							ChaincodeSpec struct {
+								ChaincodeID struct {
+									Name string `json:"name"`
+								} `json:"chaincode_id"`
+								Input struct {
+									Args []jsonBuffer `json:"args"`
+								} `json:"input"`
+							} `json:"chaincode_spec"`
+						} `json:"input"`
+					} `json:"chaincode_proposal_payload"`
+					Action struct {
+						ProposalResponsePayload struct {
+							Extension struct {
+								Results struct {
+									NsRwset []struct {
+										Namespace string `json:"namespace"`
+										Rwset     struct {
+											Reads            []jsonRead `json:"reads"`
+											RangeQueriesInfo []struct {
+												StartKey     string `json:"start_key"`
+												EndKey       string `json:"end_key"`
+												ItrExhausted bool   `json:"itr_exhausted"`
+												RawReads     *struct {
+													KvReads []jsonRead `json:"kv_reads"`
+												} `json:"raw_reads"`
+											} `json:"range_queries_info"`
+											Writes []struct {
+												Key      string     `json:"key"`
+												IsDelete bool       `json:"is_delete"`
+												Value    jsonBuffer `json:"value"`
+											} `json:"writes"`
+										} `json:"rwset"`
+									} `json:"ns_rwset"`
+								} `json:"results"`
+							} `json:"extension"`
+						} `json:"proposal_response_payload"`
+					} `json:"action"`
+				} `json:"payload"`
+			} `json:"actions"`
+		} `json:"data"`
+	} `json:"payload"`
+}
+
+func (e *jsonEnvelope) transaction() *Transaction {
+	header := e.Payload.Header.ChannelHeader
+	transaction := &Transaction{TxID: header.TxID, ChannelID: header.ChannelID, Type: string(header.Type), Timestamp: header.Timestamp}
+	if headerTypeNumber, err := strconv.Atoi(transaction.Type); err == nil {
+		transaction.Type = headerType(int32(headerTypeNumber))
+	}
+	if transaction.Type != common.HeaderType_ENDORSER_TRANSACTION.String() {
+		return transaction
+	}
+
+	for _, action := range e.Payload.Data.Actions {
+		spec := action.Payload.ChaincodeProposalPayload.Input.ChaincodeSpec
+		if transaction.Chaincode == "" {
+			transaction.Chaincode = spec.ChaincodeID.Name
+			var args [][]byte
+			for _, arg := range spec.Input.Args {
+				args = append(args, arg)
+			}
+			setInput(transaction, args)
+		}
+		for _, namespaceSet := range action.Payload.Action.ProposalResponsePayload.Extension.Results.NsRwset {
+			namespace := namespaceSet.Namespace
+			for _, read := range namespaceSet.Rwset.Reads {
+				transaction.Reads = append(transaction.Reads, read.read(namespace))
+			}
+			for _, info := range namespaceSet.Rwset.RangeQueriesInfo {
+				rangeQuery := RangeQuery{Namespace: namespace, StartKey: info.StartKey, EndKey: info.EndKey, Exhausted: info.ItrExhausted}
+				if info.RawReads != nil {
+					for _, read := range info.RawReads.KvReads {
+						rangeQuery.Reads = append(rangeQuery.Reads, read.read(namespace))
+					}
+				}
+				transaction.RangeQueries = append(transaction.RangeQueries, rangeQuery)
+			}
+			for _, write := range namespaceSet.Rwset.Writes {
+				transaction.Writes = append(transaction.Writes, Write{namespace, write.Key, write.IsDelete, string(write.Value)})
+			}
+		}
+	}
+	return transaction
+}
+
+func parseJSONBlock(data []byte) (*Block, error) {
+	decoded := struct {
+		Header *struct {
+			Number jsonNumber `json:"number"`
+		} `json:"header"`
+		Data struct {
+			Data []jsonEnvelope `json:"data"`
+		} `json:"data"`
+		Metadata struct {
+			Metadata []json.RawMessage `json:"metadata"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("not a block: %s", err.Error())
+	}
+	if decoded.Header == nil {
+		return nil, fmt.Errorf("not a block: missing header")
+	}
+
+	var filter []int32
+	if len(decoded.Metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		// not a list of codes if the block was not committed yet
+		json.Unmarshal(decoded.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER], &filter)
+	}
+	block := &Block{Number: uint64(decoded.Header.Number)}
+	for i := range decoded.Data.Data {
+		transaction := decoded.Data.Data[i].transaction()
+		transaction.ValidationCode = pb.TxValidationCode_NOT_VALIDATED.String()
+		if i < len(filter) {
+			transaction.ValidationCode = validationCode(filter[i])
+		}
+		block.Transactions = append(block.Transactions, transaction)
+	}
+	return block, nil
+}
@@ -0,0 +1,164 @@
+package blocks
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// KeyStats is how often a key, or a range for range queries, was read and
+// written and how many transactions that read it failed with a read
+// conflict.
+type KeyStats struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	// EndKey is set for the ranges of range queries.
+	EndKey    string `json:"endKey,omitempty"`
+	Reads     int    `json:"reads"`
+	Writes    int    `json:"writes"`
+	Conflicts int    `json:"conflicts"`
+}
+
+func (k *KeyStats) String() string {
+	if k.EndKey != "" {
+		return k.Namespace + " " + FormatKey(k.Key) + " .. " + FormatKey(k.EndKey)
+	}
+	return k.Namespace + " " + FormatKey(k.Key)
+}
+
+/**
+ * Contention - aggregate the keys and ranges read and written by the
+ * transactions of blocks. A key read by a range query counts as a read of
+ * the key as well as of the range.
+ *
+ * @return the keys by conflicts, then by reads and writes, descending
+ */
+func Contention(blocks []*Block) []*KeyStats {
+	stats := make(map[[3]string]*KeyStats)
+	get := func(namespace, key, endKey string) *KeyStats {
+		id := [3]string{namespace, key, endKey}
+		if stats[id] == nil {
+			stats[id] = &KeyStats{Namespace: namespace, Key: key, EndKey: endKey}
+		}
+		return stats[id]
+	}
+	for _, block := range blocks {
+		for _, transaction := range block.Transactions {
+			// count every key once per transaction
+			read := make(map[*KeyStats]bool)
+			for _, r := range transaction.Reads {
+				read[get(r.Namespace, r.Key, "")] = true
+			}
+			for _, rangeQuery := range transaction.RangeQueries {
+				read[get(rangeQuery.Namespace, rangeQuery.StartKey, rangeQuery.EndKey)] = true
+				for _, r := range rangeQuery.Reads {
+					read[get(r.Namespace, r.Key, "")] = true
+				}
+			}
+			for key := range read {
+				key.Reads++
+				if transaction.Conflict() {
+					key.Conflicts++
+				}
+			}
+			if transaction.Valid() {
+				for _, w := range transaction.Writes {
+					get(w.Namespace, w.Key, "").Writes++
+				}
+			}
+		}
+	}
+
+	var result []*KeyStats
+	for _, key := range stats {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Conflicts != b.Conflicts {
+			return a.Conflicts > b.Conflicts
+		}
+		if a.Reads+a.Writes != b.Reads+b.Writes {
+			return a.Reads+a.Writes > b.Reads+b.Writes
+		}
+		return a.String() < b.String()
+	})
+	return result
+}
+
+// ValidationCodes counts the transactions of blocks per validation code.
+func ValidationCodes(blocks []*Block) map[string]int {
+	codes := make(map[string]int)
+	for _, block := range blocks {
+		for _, transaction := range block.Transactions {
+			codes[transaction.ValidationCode]++
+		}
+	}
+	return codes
+}
+
+func PrintTransaction(w io.Writer, transaction *Transaction) {
+	fmt.Fprintf(w, "  %s %s %s\n", transaction.TxID, transaction.ValidationCode, transaction.Timestamp)
+	if transaction.Chaincode == "" {
+		fmt.Fprintf(w, "    %s\n", transaction.Type)
+		return
+	}
+	var args []string
+	for _, arg := range transaction.Args {
+		args = append(args, FormatValue(arg))
+	}
+	fmt.Fprintf(w, "    %s %s(%s)\n", transaction.Chaincode, transaction.Function, strings.Join(args, ", "))
+	for _, read := range transaction.Reads {
+		fmt.Fprintf(w, "    read   %s %s @%s\n", read.Namespace, FormatKey(read.Key), read.Version)
+	}
+	for _, rangeQuery := range transaction.RangeQueries {
+		fmt.Fprintf(w, "    range  %s %s .. %s, %d keys\n", rangeQuery.Namespace, FormatKey(rangeQuery.StartKey),
+			FormatKey(rangeQuery.EndKey), len(rangeQuery.Reads))
+		for _, read := range rangeQuery.Reads {
+			fmt.Fprintf(w, "             %s @%s\n", FormatKey(read.Key), read.Version)
+		}
+	}
+	for _, write := range transaction.Writes {
+		if write.IsDelete {
+			fmt.Fprintf(w, "    delete %s %s\n", write.Namespace, FormatKey(write.Key))
+		} else {
+			fmt.Fprintf(w, "    write  %s %s = %s\n", write.Namespace, FormatKey(write.Key), FormatValue(write.Value))
+		}
+	}
+}
+
+// Print writes every transaction of blocks, the number of transactions per
+// validation code and the top most contended keys.
+func Print(w io.Writer, blocks []*Block, top int) {
+	for _, block := range blocks {
+		fmt.Fprintf(w, "block %d, %d transactions\n", block.Number, len(block.Transactions))
+		for _, transaction := range block.Transactions {
+			PrintTransaction(w, transaction)
+		}
+		fmt.Fprintln(w)
+	}
+
+	codes := ValidationCodes(blocks)
+	var names []string
+	for name := range codes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "validation codes")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %6d  %s\n", codes[name], name)
+	}
+
+	contention := Contention(blocks)
+	if top > 0 && len(contention) > top {
+		contention = contention[:top]
+	}
+	if len(contention) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nmost contended keys\n  %9s %6s %6s  key\n", "conflicts", "reads", "writes")
+	for _, key := range contention {
+		fmt.Fprintf(w, "  %9d %6d %6d  %s\n", key.Conflicts, key.Reads, key.Writes, key)
+	}
+}
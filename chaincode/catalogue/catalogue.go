@@ -0,0 +1,189 @@
+// Package catalogue keeps the marble records of the chaincodes under the
+// composite key KEY_MARBLE, apart from the balance keys and delta rows, so
+// that they can be listed without scanning the whole namespace.
+//
+// Records written before the catalogue existed are stored under the bare
+// marble name. Get still reads them and Migrate moves them.
+package catalogue
+
+import (
+	"encoding/json"
+	"fmt"
+	"marbles-meetup/paging"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const KEY_MARBLE = "Marble/name"
+
+// header holds the fields every record has, whatever its docType.
+type header struct {
+	ObjectType string `json:"docType"`
+	Name       string `json:"name"`
+}
+
+// Page is a page of records in name order. Bookmark is the name of the last
+// record, or empty for the last page.
+type Page struct {
+	Marbles  []json.RawMessage `json:"marbles"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// Migration lists the records a page of Migrate moved. Bookmark is where
+// the next page starts, or empty if the whole namespace was scanned.
+type Migration struct {
+	Moved    []string `json:"moved"`
+	Bookmark string   `json:"bookmark"`
+}
+
+func Key(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	return stub.CreateCompositeKey(KEY_MARBLE, []string{name})
+}
+
+// isLegacy tells if value is the record of name stored under the bare name.
+// The balance keys older versions of the general chaincode wrote share that
+// namespace, but hold numbers.
+func isLegacy(name string, value []byte) bool {
+	record := header{}
+	return json.Unmarshal(value, &record) == nil && record.ObjectType != "" && record.Name == name
+}
+
+/**
+ * Get - read the record of a marble
+ *
+ * @return the record, or nil if the marble does not exist
+ */
+func Get(stub shim.ChaincodeStubInterface, name string) ([]byte, error) {
+	key, err := Key(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	recordAsBytes, err := stub.GetState(key)
+	if err != nil || recordAsBytes != nil {
+		return recordAsBytes, err
+	}
+
+	legacyAsBytes, err := stub.GetState(name)
+	if err != nil || legacyAsBytes == nil || !isLegacy(name, legacyAsBytes) {
+		return nil, err
+	}
+	return legacyAsBytes, nil
+}
+
+func Put(stub shim.ChaincodeStubInterface, name string, record []byte) error {
+	key, err := Key(stub, name)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, record)
+}
+
+// Names returns the name of every record in the catalogue. Legacy records
+// are not included.
+func Names(stub shim.ChaincodeStubInterface) ([]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(KEY_MARBLE, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	var names []string
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(result.Key)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attributes[0])
+	}
+	return names, nil
+}
+
+/**
+ * List - read a page of the records in the catalogue
+ *
+ * @param docType - only list records of this docType, every record if empty
+ * @param pageSize - the maximum number of records, DEFAULT_PAGE_SIZE if 0
+ * @param bookmark - the Bookmark of the previous page, empty for the first
+ */
+func List(stub shim.ChaincodeStubInterface, docType string, pageSize int, bookmark string) (*Page, error) {
+	if pageSize <= 0 {
+		pageSize = paging.DEFAULT_PAGE_SIZE
+	}
+	after := ""
+	if bookmark != "" {
+		var err error
+		after, err = Key(stub, bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bookmark")
+		}
+	}
+
+	iterator, err := paging.CompositeKeys(stub, KEY_MARBLE, []string{}, after)
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	page := &Page{Marbles: []json.RawMessage{}}
+	last := ""
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if result.Key <= after {
+			continue
+		}
+		record := header{}
+		if err := json.Unmarshal(result.Value, &record); err != nil {
+			return nil, fmt.Errorf("Invalid marble record %s: %s", result.Key, err.Error())
+		}
+		if docType != "" && record.ObjectType != docType {
+			continue
+		}
+		if len(page.Marbles) == pageSize {
+			page.Bookmark = last
+			break
+		}
+		page.Marbles = append(page.Marbles, json.RawMessage(result.Value))
+		last = record.Name
+	}
+	return page, nil
+}
+
+/**
+ * Migrate - move the legacy records among a page of the simple keys into
+ * the catalogue. Callers check that the creator may migrate.
+ *
+ * @param pageSize - the number of keys to scan, DEFAULT_PAGE_SIZE if 0
+ * @param bookmark - the Bookmark of the previous migration, empty for the first
+ */
+func Migrate(stub shim.ChaincodeStubInterface, pageSize int, bookmark string) (*Migration, error) {
+	iterator, err := paging.SimpleKeys(stub, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	page, next, err := paging.Read(iterator, bookmark, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := &Migration{Moved: []string{}, Bookmark: next}
+	for _, result := range page {
+		if !isLegacy(result.Key, result.Value) {
+			continue
+		}
+		if err := Put(stub, result.Key, result.Value); err != nil {
+			return nil, err
+		}
+		if err := stub.DelState(result.Key); err != nil {
+			return nil, err
+		}
+		migration.Moved = append(migration.Moved, result.Key)
+	}
+	return migration, nil
+}
@@ -0,0 +1,96 @@
+package catalogue
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func record(docType, name string) []byte {
+	recordBytes, _ := json.Marshal(header{docType, name})
+	return recordBytes
+}
+
+func newStub(t *testing.T) *shim.MockStub {
+	stub := shim.NewMockStub("catalogue", nil)
+	stub.MockTransactionStart("1")
+	for _, name := range []string{"BlueMarble", "GreenMarble", "RedMarble"} {
+		if err := Put(stub, name, record("marble", name)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	Put(stub, "Bag", record("bag", "Bag"))
+	return stub
+}
+
+func Test_CATALOGUE_Get_legacy_success(t *testing.T) {
+	stub := newStub(t)
+	defer stub.MockTransactionEnd("1")
+	stub.PutState("YellowMarble", record("marble", "YellowMarble"))
+	// a general balance key that is also the name of a marble
+	stub.PutState("aliceRed", []byte("10"))
+
+	for name, expected := range map[string]bool{"RedMarble": true, "YellowMarble": true, "aliceRed": false, "BlackMarble": false} {
+		recordAsBytes, err := Get(stub, name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if (recordAsBytes != nil) != expected {
+			t.Errorf("get %s returned %s", name, recordAsBytes)
+		}
+	}
+}
+
+func Test_CATALOGUE_List_success(t *testing.T) {
+	stub := newStub(t)
+	defer stub.MockTransactionEnd("1")
+
+	page, err := List(stub, "marble", 2, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(page.Marbles) != 2 || page.Bookmark != "GreenMarble" {
+		t.Fatalf("first page %s, bookmark %q", page.Marbles, page.Bookmark)
+	}
+	page, err = List(stub, "marble", 2, page.Bookmark)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(page.Marbles) != 1 || string(page.Marbles[0]) != string(record("marble", "RedMarble")) || page.Bookmark != "" {
+		t.Errorf("last page %s, bookmark %q", page.Marbles, page.Bookmark)
+	}
+
+	page, _ = List(stub, "", 0, "")
+	if len(page.Marbles) != 4 || string(page.Marbles[0]) != string(record("bag", "Bag")) {
+		t.Errorf("unfiltered page %s", page.Marbles)
+	}
+}
+
+func Test_CATALOGUE_Migrate_success(t *testing.T) {
+	stub := newStub(t)
+	defer stub.MockTransactionEnd("1")
+	stub.PutState("YellowMarble", record("marble", "YellowMarble"))
+	stub.PutState("WhiteMarble", record("marble", "WhiteMarble"))
+	stub.PutState("aliceWhiteMarble", []byte("10"))
+
+	var moved []string
+	bookmark := ""
+	for i := 0; i == 0 || bookmark != ""; i++ {
+		migration, err := Migrate(stub, 2, bookmark)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		moved = append(moved, migration.Moved...)
+		bookmark = migration.Bookmark
+	}
+	if len(moved) != 2 || moved[0] != "WhiteMarble" || moved[1] != "YellowMarble" {
+		t.Errorf("moved %v", moved)
+	}
+	if stub.State["WhiteMarble"] != nil || stub.State["aliceWhiteMarble"] == nil {
+		t.Errorf("migration left the legacy record or moved a balance")
+	}
+	if names, _ := Names(stub); len(names) != 6 {
+		t.Errorf("catalogue holds %v", names)
+	}
+}
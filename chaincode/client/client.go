@@ -0,0 +1,213 @@
+// Package client is a Go client of the REST gateway in server/app.js.
+//
+//	c := client.New("http://localhost:4000")
+//	if _, err := c.Enroll(ctx, "Jim", "Org1"); err != nil { ... }
+//	marbles := c.Chaincode("mychannel", "marblehighthroughput", "peer0.org1.example.com", "peer0.org2.example.com")
+//	txID, err := marbles.TransferMarbles(ctx, "redMarbles", "alice", "bob", 20)
+//	balance, err := marbles.ReadMarbles(ctx, "redMarbles", "bob")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOKEN_PASS is accepted by the gateway without enrollment, as user Jim of
+// Org1.
+const TOKEN_PASS = "pass"
+
+// gatewayResponse is the body the gateway answers invokes and errors with.
+// It answers with status 200 even when the request failed.
+type gatewayResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Secret  string `json:"secret"`
+	Token   string `json:"token"`
+}
+
+var codePattern = regexp.MustCompile(`code:\s*([A-Z_]+)`)
+
+// Error is a request the gateway or the chaincode rejected. Code is the
+// validation code of an invalid transaction, e.g. MVCC_READ_CONFLICT.
+type Error struct {
+	Op         string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("%s: HTTP %d: %s", e.Op, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Message)
+}
+
+func newError(op string, statusCode int, message string) *Error {
+	err := &Error{Op: op, StatusCode: statusCode, Message: strings.TrimSpace(message)}
+	if match := codePattern.FindStringSubmatch(message); match != nil {
+		err.Code = match[1]
+	}
+	return err
+}
+
+// IsConflict reports whether err is a transaction invalidated by a read
+// conflict, which can be retried as a new transaction.
+func IsConflict(err error) bool {
+	var clientErr *Error
+	return errors.As(err, &clientErr) && (clientErr.Code == "MVCC_READ_CONFLICT" || clientErr.Code == "PHANTOM_READ_CONFLICT")
+}
+
+func isAuthenticationError(err error) bool {
+	var clientErr *Error
+	return errors.As(err, &clientErr) && strings.Contains(clientErr.Message, "Failed to authenticate token")
+}
+
+// Client talks to one gateway. It is safe for concurrent use.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+
+	lock     sync.RWMutex
+	token    string
+	username string
+	orgName  string
+}
+
+// New returns a client using TOKEN_PASS until Enroll is called, and
+// DefaultRetryPolicy.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 4 * time.Minute},
+		Retry:      DefaultRetryPolicy,
+		token:      TOKEN_PASS,
+	}
+}
+
+func (c *Client) Token() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.token
+}
+
+func (c *Client) SetToken(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.token = token
+}
+
+/**
+ * Enroll - register and enroll username of orgName through /users and use
+ * the returned token for every further request. When the token expires the
+ * client enrolls again once before failing a request.
+ *
+ * @return the enrollment secret
+ */
+func (c *Client) Enroll(ctx context.Context, username, orgName string) (string, error) {
+	body, err := json.Marshal(map[string]string{"username": username, "orgName": orgName})
+	if err != nil {
+		return "", err
+	}
+	responseBytes, err := c.send(ctx, "enroll", http.MethodPost, "/users", body, false)
+	if err != nil {
+		return "", err
+	}
+	response := gatewayResponse{}
+	if err := json.Unmarshal(responseBytes, &response); err != nil {
+		return "", newError("enroll", http.StatusOK, "invalid response "+string(responseBytes))
+	}
+	if !response.Success || response.Token == "" {
+		return "", newError("enroll", http.StatusOK, response.Message)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.token, c.username, c.orgName = response.Token, username, orgName
+	return response.Secret, nil
+}
+
+func (c *Client) reenroll(ctx context.Context) bool {
+	c.lock.RLock()
+	username, orgName := c.username, c.orgName
+	c.lock.RUnlock()
+	if username == "" {
+		return false
+	}
+	_, err := c.Enroll(ctx, username, orgName)
+	return err == nil
+}
+
+// do sends a request with the retry policy of the client. Gateway errors
+// in a JSON body with success false are returned as *Error.
+func (c *Client) do(ctx context.Context, op, method, path string, body []byte) ([]byte, error) {
+	var responseBytes []byte
+	attempt := func() error {
+		var err error
+		responseBytes, err = c.send(ctx, op, method, path, body, true)
+		if err != nil {
+			return err
+		}
+		response := gatewayResponse{}
+		if json.Unmarshal(responseBytes, &response) == nil && !response.Success && response.Message != "" {
+			return newError(op, http.StatusOK, response.Message)
+		}
+		return nil
+	}
+
+	reenrolled := false
+	err := c.Retry.Do(ctx, func() error {
+		err := attempt()
+		if isAuthenticationError(err) && !reenrolled {
+			reenrolled = true
+			if c.reenroll(ctx) {
+				err = attempt()
+			}
+		}
+		return err
+	})
+	return responseBytes, err
+}
+
+func (c *Client) send(ctx context.Context, op, method, path string, body []byte, authorized bool) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	if body != nil {
+		request.Header.Set("content-type", "application/json")
+	}
+	if authorized {
+		request.Header.Set("authorization", "Bearer "+c.Token())
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, newError(op, response.StatusCode, string(responseBytes))
+	}
+	return responseBytes, nil
+}
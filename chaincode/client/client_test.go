@@ -0,0 +1,290 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const sampleBlock = `{
+	"header": {"number": "3", "previous_hash": "aa", "data_hash": "bb"},
+	"data": {"data": [
+		{"payload": {"header": {"channel_header": {"type": "ENDORSER_TRANSACTION", "tx_id": "tx1", "timestamp": "2019-09-20T01:02:03.000Z", "channel_id": "mychannel"}}}},
+		{"payload": {"header": {"channel_header": {"type": 3, "tx_id": "tx2", "timestamp": "2019-09-20T01:02:04.000Z", "channel_id": "mychannel"}}}}
+	]},
+	"metadata": {"metadata": [[], {}, [0, 11], []]}
+}`
+
+// fakeGateway answers like server/app.js. Tokens are "token<n>" for the
+// n-th enrollment, expired tokens are rejected like invalid JWTs.
+type fakeGateway struct {
+	lock        sync.Mutex
+	enrollments int
+	expired     map[string]bool
+	// conflicts is the number of invokes to fail with MVCC_READ_CONFLICT.
+	conflicts int
+	invokes   []string
+	paths     []string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.paths = append(g.paths, r.Method+" "+r.URL.Path)
+	body := struct {
+		Username string   `json:"username"`
+		OrgName  string   `json:"orgName"`
+		Peers    []string `json:"peers"`
+		Fcn      string   `json:"fcn"`
+		Args     []string `json:"args"`
+	}{}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if r.URL.Path == "/users" {
+		if body.OrgName == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "'orgName' field is missing or Invalid in the request"})
+			return
+		}
+		g.enrollments++
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "secret": "secret",
+			"message": body.Username + " enrolled Successfully", "token": "token" + strconv.Itoa(g.enrollments)})
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("authorization"), "Bearer ")
+	if token != "pass" && (!strings.HasPrefix(token, "token") || g.expired[token]) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Failed to authenticate token. Make sure to include the " +
+			"token returned from /users call in the authorization header  as a Bearer token"})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost:
+		g.invokes = append(g.invokes, r.URL.Path+" "+body.Fcn+" "+strings.Join(body.Args, ","))
+		if g.conflicts > 0 {
+			g.conflicts--
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false,
+				"message": "Failed to invoke chaincode. cause:Error: The invoke chaincode transaction was invalid, code:MVCC_READ_CONFLICT"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true,
+			"message": "Successfully invoked the chaincode Org1 to the channel 'mychannel' for transaction ID: tx" + strconv.Itoa(len(g.invokes))})
+	case strings.HasSuffix(r.URL.Path, "/blocks/3"):
+		w.Write([]byte(sampleBlock))
+	case strings.HasSuffix(r.URL.Path, "/blocks/4"):
+		w.Write([]byte("Error: Entry not found in index"))
+	case strings.HasSuffix(r.URL.Path, "/transactions/tx2"):
+		w.Write([]byte(`{"validationCode": 11, "transactionEnvelope": {"payload": {"header": {"channel_header": {"type": 3, "tx_id": "tx2", "channel_id": "mychannel"}}}}}`))
+	default:
+		var args []string
+		json.Unmarshal([]byte(r.URL.Query().Get("args")), &args)
+		if r.URL.Query().Get("fcn") != FUNCTION_READ || r.URL.Query().Get("peer") != "peer0.org1.example.com" {
+			w.Write([]byte("Error: transaction returned with failure: Invalid invoke function name"))
+			return
+		}
+		if len(args) == 1 {
+			w.Write([]byte(args[0] + ` now has {"marble":{"docType":"marble","name":"` + args[0] + `","color":"red","size":30},"owner":"","amount":0} after the move`))
+			return
+		}
+		w.Write([]byte(args[0] + ` now has {"marble":{"docType":"marble","name":"` + args[0] + `","color":"red","size":30},"owner":"` + args[1] + `","amount":20} after the move`))
+	}
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeGateway, *httptest.Server) {
+	gateway := &fakeGateway{expired: make(map[string]bool)}
+	server := httptest.NewServer(gateway)
+	c := New(server.URL)
+	c.HTTPClient = server.Client()
+	c.Retry.InitialBackoff = time.Millisecond
+	return c, gateway, server
+}
+
+func Test_CLIENT_marbles_success(t *testing.T) {
+	c, gateway, server := newTestClient(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	if _, err := c.Enroll(ctx, "Jim", "Org1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.Token() != "token1" {
+		t.Fatalf("token %s was not token1", c.Token())
+	}
+
+	marbles := c.Chaincode("mychannel", "marblehighthroughput", "peer0.org1.example.com", "peer0.org2.example.com")
+	txID, err := marbles.InitMarbles(ctx, Marble{Name: "redMarbles", Color: "red", Size: 30}, 100000, "alice")
+	if err != nil || txID != "tx1" {
+		t.Fatalf("InitMarbles returned %s, %v", txID, err)
+	}
+	marbles.High = true
+	if _, err := marbles.TransferMarbles(ctx, "redMarbles", "alice", "bob", 20); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := marbles.PruneMarbles(ctx, "redMarbles"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{
+		"/channels/mychannel/chaincodes/marblehighthroughput initMarbles redMarbles,red,30,100000,alice",
+		"/high/channels/mychannel/chaincodes/marblehighthroughput transferMarbles redMarbles,alice,bob,20",
+		"/high/channels/mychannel/chaincodes/marblehighthroughput pruneMarbles redMarbles",
+	}
+	if strings.Join(gateway.invokes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("invokes were\n%s", strings.Join(gateway.invokes, "\n"))
+	}
+
+	result, err := marbles.ReadMarbles(ctx, "redMarbles", "bob")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if *result != (MarbleResponse{Marble{"marble", "redMarbles", "red", 30}, "bob", 20}) {
+		t.Errorf("ReadMarbles returned %+v", *result)
+	}
+	if result, err := marbles.ReadMarbles(ctx, "redMarbles", ""); err != nil || result.Owner != "" {
+		t.Errorf("ReadMarbles without owner returned %+v, %v", result, err)
+	}
+	if _, err := marbles.Query(ctx, "describe"); err == nil || !strings.Contains(err.Error(), "Invalid invoke function name") {
+		t.Errorf("Query returned %v, expected the chaincode error", err)
+	}
+}
+
+func Test_CLIENT_enroll_fail(t *testing.T) {
+	c, _, server := newTestClient(t)
+	defer server.Close()
+
+	_, err := c.Enroll(context.Background(), "Jim", "")
+	if err == nil || err.Error() != "enroll: 'orgName' field is missing or Invalid in the request" {
+		t.Fatalf("Enroll returned %v", err)
+	}
+	if c.Token() != TOKEN_PASS {
+		t.Errorf("token %s was not kept", c.Token())
+	}
+}
+
+func Test_CLIENT_reenroll_success(t *testing.T) {
+	c, gateway, server := newTestClient(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	if _, err := c.Enroll(ctx, "Jim", "Org1"); err != nil {
+		t.Fatal(err.Error())
+	}
+	gateway.expired["token1"] = true
+	marbles := c.Chaincode("mychannel", "marbles", "peer0.org1.example.com")
+	if _, err := marbles.TransferMarbles(ctx, "redMarbles", "alice", "bob", 20); err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.Token() != "token2" || len(gateway.invokes) != 1 {
+		t.Errorf("token %s after %d invokes, expected token2 after 1", c.Token(), len(gateway.invokes))
+	}
+
+	// without credentials the error is returned
+	c.SetToken("invalid")
+	c.username = ""
+	_, err := marbles.TransferMarbles(ctx, "redMarbles", "alice", "bob", 20)
+	if err == nil || !strings.Contains(err.Error(), "Failed to authenticate token") {
+		t.Errorf("TransferMarbles returned %v", err)
+	}
+}
+
+func Test_CLIENT_retry_success(t *testing.T) {
+	c, gateway, server := newTestClient(t)
+	defer server.Close()
+	marbles := c.Chaincode("mychannel", "marbles", "peer0.org1.example.com")
+
+	gateway.conflicts = 2
+	txID, err := marbles.TransferMarbles(context.Background(), "redMarbles", "alice", "bob", 20)
+	if err != nil || txID != "tx3" {
+		t.Fatalf("TransferMarbles returned %s, %v after 2 conflicts", txID, err)
+	}
+
+	gateway.conflicts = 10
+	_, err = marbles.TransferMarbles(context.Background(), "redMarbles", "alice", "bob", 20)
+	if !IsConflict(err) || len(gateway.invokes) != 3+DefaultRetryPolicy.MaxAttempts {
+		t.Fatalf("TransferMarbles returned %v after %d invokes", err, len(gateway.invokes))
+	}
+
+	c.Retry = NoRetry
+	gateway.conflicts = 1
+	if _, err := marbles.TransferMarbles(context.Background(), "redMarbles", "alice", "bob", 20); !IsConflict(err) {
+		t.Fatalf("TransferMarbles returned %v without retry", err)
+	}
+}
+
+func Test_CLIENT_retry_cancel(t *testing.T) {
+	c, gateway, server := newTestClient(t)
+	defer server.Close()
+	c.Retry.InitialBackoff = time.Hour
+	marbles := c.Chaincode("mychannel", "marbles", "peer0.org1.example.com")
+
+	gateway.conflicts = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := marbles.TransferMarbles(ctx, "redMarbles", "alice", "bob", 20)
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatalf("TransferMarbles returned %v after %s", err, time.Since(start))
+	}
+}
+
+func Test_CLIENT_retry_unavailable_success(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"success":true,"message":"Successfully invoked the chaincode Org1 to the channel 'mychannel' for transaction ID: abc"}`))
+	}))
+	defer server.Close()
+	c := New(server.URL)
+	c.Retry.InitialBackoff = time.Millisecond
+
+	txID, err := c.Chaincode("mychannel", "marbles").PruneMarbles(context.Background(), "redMarbles")
+	if err != nil || txID != "abc" || attempts != 2 {
+		t.Fatalf("PruneMarbles returned %s, %v after %d attempts", txID, err, attempts)
+	}
+}
+
+func Test_CLIENT_ledger_success(t *testing.T) {
+	c, gateway, server := newTestClient(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	block, err := c.BlockByNumber(ctx, "mychannel", 3, "peer0.org1.example.com")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if block.Number != 3 || block.PreviousHash != "aa" || len(block.Transactions) != 2 {
+		t.Fatalf("block %d %s with %d transactions", block.Number, block.PreviousHash, len(block.Transactions))
+	}
+	first, second := block.Transactions[0], block.Transactions[1]
+	if first.TxID != "tx1" || !first.Valid() || first.Type != "ENDORSER_TRANSACTION" {
+		t.Errorf("first transaction %+v", first)
+	}
+	if second.TxID != "tx2" || second.ValidationCodeName() != "MVCC_READ_CONFLICT" || second.Type != "3" {
+		t.Errorf("second transaction %+v", second)
+	}
+	if gateway.paths[0] != "GET /channels/mychannel/blocks/3" {
+		t.Errorf("block path was %s", gateway.paths[0])
+	}
+
+	if _, err := c.BlockByNumber(ctx, "mychannel", 4, ""); err == nil || !strings.Contains(err.Error(), "Entry not found") {
+		t.Errorf("BlockByNumber returned %v", err)
+	}
+
+	transaction, err := c.TransactionByID(ctx, "mychannel", "tx2", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if transaction.TxID != "tx2" || transaction.ValidationCodeName() != "MVCC_READ_CONFLICT" || transaction.Valid() {
+		t.Errorf("transaction %+v", transaction)
+	}
+}
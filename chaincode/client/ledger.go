@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// number decodes the numbers of the node SDK, which renders 64 bit
+// integers as strings.
+type number uint64
+
+func (n *number) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	*n = number(value)
+	return err
+}
+
+// text decodes a string or a number as text, e.g. the channel header type
+// which is ENDORSER_TRANSACTION or 3 depending on the SDK version.
+type text string
+
+func (t *text) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if s, ok := value.(string); ok {
+		*t = text(s)
+	} else if value != nil {
+		*t = text(strings.Trim(string(data), `"`))
+	}
+	return nil
+}
+
+type envelope struct {
+	Payload struct {
+		Header struct {
+			ChannelHeader struct {
+				Type      text   `json:"type"`
+				TxID      string `json:"tx_id"`
+				Timestamp string `json:"timestamp"`
+				ChannelID string `json:"channel_id"`
+			} `json:"channel_header"`
+		} `json:"header"`
+	} `json:"payload"`
+}
+
+// Transaction is a transaction of a block, or looked up by id.
+type Transaction struct {
+	TxID      string
+	Type      string
+	Timestamp string
+	ChannelID string
+	// ValidationCode is the pb.TxValidationCode the committing peer gave.
+	ValidationCode int32
+	Raw            json.RawMessage
+}
+
+func (t *Transaction) ValidationCodeName() string {
+	if name, exists := pb.TxValidationCode_name[t.ValidationCode]; exists {
+		return name
+	}
+	return strconv.Itoa(int(t.ValidationCode))
+}
+
+func (t *Transaction) Valid() bool {
+	return t.ValidationCode == int32(pb.TxValidationCode_VALID)
+}
+
+func newTransaction(raw json.RawMessage, validationCode int32) (*Transaction, error) {
+	decoded := envelope{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+	header := decoded.Payload.Header.ChannelHeader
+	return &Transaction{header.TxID, string(header.Type), header.Timestamp, header.ChannelID, validationCode, raw}, nil
+}
+
+type Block struct {
+	Number       uint64
+	PreviousHash string
+	DataHash     string
+	Transactions []*Transaction
+	Raw          json.RawMessage
+}
+
+// metadata index of the validation codes of a block
+const transactionsFilter = 2
+
+func decodeBlock(raw []byte) (*Block, error) {
+	decoded := struct {
+		Header struct {
+			Number       number `json:"number"`
+			PreviousHash string `json:"previous_hash"`
+			DataHash     string `json:"data_hash"`
+		} `json:"header"`
+		Data struct {
+			Data []json.RawMessage `json:"data"`
+		} `json:"data"`
+		Metadata struct {
+			Metadata []json.RawMessage `json:"metadata"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	var codes []int32
+	if len(decoded.Metadata.Metadata) > transactionsFilter {
+		// missing or not a list of codes for blocks that are not committed
+		json.Unmarshal(decoded.Metadata.Metadata[transactionsFilter], &codes)
+	}
+	block := &Block{Number: uint64(decoded.Header.Number), PreviousHash: decoded.Header.PreviousHash,
+		DataHash: decoded.Header.DataHash, Raw: raw}
+	for i, data := range decoded.Data.Data {
+		code := int32(pb.TxValidationCode_NOT_VALIDATED)
+		if i < len(codes) {
+			code = codes[i]
+		}
+		transaction, err := newTransaction(data, code)
+		if err != nil {
+			return nil, err
+		}
+		block.Transactions = append(block.Transactions, transaction)
+	}
+	return block, nil
+}
+
+// lookup gets a ledger object, which the gateway answers with as JSON or
+// with the error as text.
+func (c *Client) lookup(ctx context.Context, op, path, peer string) ([]byte, error) {
+	if peer != "" {
+		path += "?peer=" + url.QueryEscape(peer)
+	}
+	responseBytes, err := c.do(ctx, op, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(responseBytes) || !strings.HasPrefix(strings.TrimSpace(string(responseBytes)), "{") {
+		return nil, newError(op, http.StatusOK, string(responseBytes))
+	}
+	return responseBytes, nil
+}
+
+func (c *Client) BlockByNumber(ctx context.Context, channel string, blockNumber uint64, peer string) (*Block, error) {
+	responseBytes, err := c.lookup(ctx, "block", "/channels/"+url.PathEscape(channel)+"/blocks/"+strconv.FormatUint(blockNumber, 10), peer)
+	if err != nil {
+		return nil, err
+	}
+	block, err := decodeBlock(responseBytes)
+	if err != nil {
+		return nil, newError("block", http.StatusOK, "invalid block: "+err.Error())
+	}
+	return block, nil
+}
+
+func (c *Client) TransactionByID(ctx context.Context, channel, txID, peer string) (*Transaction, error) {
+	responseBytes, err := c.lookup(ctx, "transaction", "/channels/"+url.PathEscape(channel)+"/transactions/"+url.PathEscape(txID), peer)
+	if err != nil {
+		return nil, err
+	}
+	decoded := struct {
+		ValidationCode      int32           `json:"validationCode"`
+		TransactionEnvelope json.RawMessage `json:"transactionEnvelope"`
+	}{}
+	if err := json.Unmarshal(responseBytes, &decoded); err != nil {
+		return nil, newError("transaction", http.StatusOK, "invalid transaction: "+err.Error())
+	}
+	transaction, err := newTransaction(decoded.TransactionEnvelope, decoded.ValidationCode)
+	if err != nil {
+		return nil, newError("transaction", http.StatusOK, "invalid transaction: "+err.Error())
+	}
+	transaction.Raw = responseBytes
+	return transaction, nil
+}
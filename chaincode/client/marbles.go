@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	FUNCTION_INIT     = "initMarbles"
+	FUNCTION_TRANSFER = "transferMarbles"
+	FUNCTION_READ     = "readMarbles"
+	FUNCTION_PRUNE    = "pruneMarbles"
+)
+
+type Marble struct {
+	ObjectType string `json:"docType"`
+	Name       string `json:"name"`
+	Color      string `json:"color"`
+	Size       int    `json:"size"`
+}
+
+// MarbleResponse is the readMarbles result. Owner and Amount are empty
+// when no owner was given.
+type MarbleResponse struct {
+	Marble Marble `json:"marble"`
+	Owner  string `json:"owner"`
+	Amount int    `json:"amount"`
+}
+
+var txIDPattern = regexp.MustCompile(`transaction ID: *(\S+)`)
+
+// Chaincode is a marbles chaincode instantiated on a channel.
+type Chaincode struct {
+	client  *Client
+	Channel string
+	Name    string
+	// Peers endorse invokes, the first one answers queries.
+	Peers []string
+	// High sends invokes through the /high route, which checks the sender
+	// balance in the gateway before invoking.
+	High bool
+}
+
+func (c *Client) Chaincode(channel, name string, peers ...string) *Chaincode {
+	return &Chaincode{client: c, Channel: channel, Name: name, Peers: peers}
+}
+
+func (cc *Chaincode) path() string {
+	return "/channels/" + url.PathEscape(cc.Channel) + "/chaincodes/" + url.PathEscape(cc.Name)
+}
+
+/**
+ * Invoke - send a transaction and wait until it was committed
+ *
+ * @return the transaction id
+ */
+func (cc *Chaincode) Invoke(ctx context.Context, function string, args ...string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"peers": cc.Peers, "fcn": function, "args": args})
+	if err != nil {
+		return "", err
+	}
+	path := cc.path()
+	if cc.High {
+		path = "/high" + path
+	}
+	responseBytes, err := cc.client.do(ctx, function, http.MethodPost, path, body)
+	if err != nil {
+		return "", err
+	}
+	response := gatewayResponse{}
+	if err := json.Unmarshal(responseBytes, &response); err != nil {
+		return "", newError(function, http.StatusOK, "invalid response "+string(responseBytes))
+	}
+	match := txIDPattern.FindStringSubmatch(response.Message)
+	if match == nil {
+		return "", newError(function, http.StatusOK, response.Message)
+	}
+	return match[1], nil
+}
+
+/**
+ * Query - evaluate function on the first peer without a transaction. The
+ * gateway answers with "<args[0]> now has <payload> after the move" or the
+ * error as text.
+ *
+ * @return the chaincode payload
+ */
+func (cc *Chaincode) Query(ctx context.Context, function string, args ...string) ([]byte, error) {
+	argsBytes, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	if len(cc.Peers) > 0 {
+		params.Set("peer", cc.Peers[0])
+	}
+	params.Set("fcn", function)
+	params.Set("args", string(argsBytes))
+	responseBytes, err := cc.client.do(ctx, function, http.MethodGet, cc.path()+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	message := string(responseBytes)
+	first := ""
+	if len(args) > 0 {
+		first = args[0]
+	}
+	prefix, suffix := first+" now has ", " after the move"
+	if !strings.HasPrefix(message, prefix) || !strings.HasSuffix(message, suffix) {
+		return nil, newError(function, http.StatusOK, message)
+	}
+	return []byte(message[len(prefix) : len(message)-len(suffix)]), nil
+}
+
+// InitMarbles creates marble with amount owned by owner.
+func (cc *Chaincode) InitMarbles(ctx context.Context, marble Marble, amount int, owner string) (string, error) {
+	return cc.Invoke(ctx, FUNCTION_INIT, marble.Name, marble.Color, strconv.Itoa(marble.Size), strconv.Itoa(amount), owner)
+}
+
+func (cc *Chaincode) TransferMarbles(ctx context.Context, name, sender, receiver string, amount int) (string, error) {
+	return cc.Invoke(ctx, FUNCTION_TRANSFER, name, sender, receiver, strconv.Itoa(amount))
+}
+
+// ReadMarbles reads marble name and the balance of owner, if it is not
+// empty.
+func (cc *Chaincode) ReadMarbles(ctx context.Context, name, owner string) (*MarbleResponse, error) {
+	args := []string{name}
+	if owner != "" {
+		args = append(args, owner)
+	}
+	payload, err := cc.Query(ctx, FUNCTION_READ, args...)
+	if err != nil {
+		return nil, err
+	}
+	response := &MarbleResponse{}
+	if err := json.Unmarshal(payload, response); err != nil {
+		return nil, newError(FUNCTION_READ, http.StatusOK, "invalid payload "+string(payload))
+	}
+	return response, nil
+}
+
+func (cc *Chaincode) PruneMarbles(ctx context.Context, name string) (string, error) {
+	return cc.Invoke(ctx, FUNCTION_PRUNE, name)
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy retries a failed request with exponential backoff. The zero
+// value sends every request once.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Retryable decides whether an error is worth another attempt,
+	// IsRetryable if it is nil.
+	Retryable func(error) bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+// NoRetry sends every request once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+/**
+ * IsRetryable - errors that are safe to retry even for invokes: read
+ * conflicts, since the invalid transaction changed nothing, connections
+ * that could not be made and gateway unavailable responses. Timeouts are
+ * not retried as the transaction may have been submitted.
+ */
+func IsRetryable(err error) bool {
+	if IsConflict(err) {
+		return true
+	}
+	var clientErr *Error
+	if errors.As(err, &clientErr) {
+		switch clientErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable:
+			return true
+		}
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// Do calls f until it succeeds, fails with an error that is not retryable,
+// MaxAttempts is reached or ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, f func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
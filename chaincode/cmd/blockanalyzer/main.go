@@ -0,0 +1,72 @@
+// Command blockanalyzer explains the transactions of Fabric block files
+// offline: chaincode, function, args, read-write set and validation code,
+// and the keys most often involved in read conflicts.
+//
+//	curl -s -H "authorization: Bearer pass" \
+//		"http://localhost:4000/channels/mychannel/blocks/5?peer=peer0.org1.example.com" > block5.json
+//	go run ./cmd/blockanalyzer block5.json ../server/artifacts/channel/genesis.block
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"marbles-meetup/blocks"
+	"os"
+)
+
+func main() {
+	top := flag.Int("top", 10, "number of contended keys to list, 0 for all")
+	chaincode := flag.String("chaincode", "", "only list transactions of this chaincode")
+	asJSON := flag.Bool("json", false, "print the transactions and contended keys as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] block-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var parsed []*blocks.Block
+	for _, path := range flag.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		block, err := blocks.ParseBlock(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			os.Exit(1)
+		}
+		if *chaincode != "" {
+			var transactions []*blocks.Transaction
+			for _, transaction := range block.Transactions {
+				if transaction.Chaincode == *chaincode {
+					transactions = append(transactions, transaction)
+				}
+			}
+			block.Transactions = transactions
+		}
+		parsed = append(parsed, block)
+	}
+
+	if !*asJSON {
+		blocks.Print(os.Stdout, parsed, *top)
+		return
+	}
+	contention := blocks.Contention(parsed)
+	if *top > 0 && len(contention) > *top {
+		contention = contention[:*top]
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(map[string]interface{}{
+		"blocks":          parsed,
+		"validationCodes": blocks.ValidationCodes(parsed),
+		"contendedKeys":   contention,
+	})
+}
@@ -0,0 +1,67 @@
+// Command loadgen drives the REST gateway in server/app.js with the
+// workload of a spec file and reports latency percentiles, the success
+// rate and the failure reasons.
+//
+//	go run ./cmd/loadgen -spec loadgen/examples/high_throughput.yaml
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"marbles-meetup/loadgen"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	specPath := flag.String("spec", "", "path of the workload spec (YAML)")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	timeout := flag.Duration("timeout", 4*time.Minute, "timeout of a single request")
+	flag.Parse()
+	if *specPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	spec, err := loadgen.LoadSpec(*specPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	runner := loadgen.NewRunner(spec, &http.Client{Timeout: *timeout})
+	report, err := runner.Run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		summary := map[string]interface{}{
+			"requests":    report.Total(),
+			"succeeded":   report.Succeeded,
+			"failed":      report.Failed,
+			"successRate": report.SuccessRate(),
+			"elapsed":     report.Elapsed.String(),
+			"reasons":     report.Reasons,
+			"latency":     report.Latencies(""),
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(summary)
+		return
+	}
+	report.Print(os.Stdout)
+}
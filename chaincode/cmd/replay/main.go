@@ -0,0 +1,117 @@
+// Command replay reproduces the state a sequence of invocations led to on a
+// fresh MockStub of one of the marbles chaincodes, prints the outcome of
+// every invocation and the resulting ledgers, and optionally compares the
+// state with a captured dump.
+//
+//	go run ./cmd/replay -chaincode highthroughput -log transactions.jsonl -stop tx42
+//	go run ./cmd/replay -chaincode highthroughput -compare state.json block5.json block6.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"marbles-meetup/blocks"
+	"marbles-meetup/general"
+	"marbles-meetup/high-throughput"
+	"marbles-meetup/high-throughput-phantom"
+	"marbles-meetup/inspect"
+	"marbles-meetup/replay"
+	"os"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var chaincodes = map[string]func() shim.Chaincode{
+	"general":               func() shim.Chaincode { return new(general.SimpleChaincode) },
+	"highthroughput":        func() shim.Chaincode { return new(highthroughput.HighThroughputChaincode) },
+	"highthroughputphantom": func() shim.Chaincode { return new(highthroughputphantom.HighThroughputChaincode) },
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(1)
+}
+
+func main() {
+	chaincode := flag.String("chaincode", "highthroughput", "chaincode to replay on: general, highthroughput or highthroughputphantom")
+	logPath := flag.String("log", "", "JSONL transaction log to replay instead of block files")
+	name := flag.String("name", "marblehighthroughput", "chaincode name of the transactions to replay from block files")
+	stopAt := flag.String("stop", "", "txid of the last transaction to replay")
+	compare := flag.String("compare", "", "state dump to compare the replayed state with")
+	format := flag.String("format", inspect.FORMAT_TEXT, "ledger output format: text, json or csv")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -log file | block-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	newChaincode, exists := chaincodes[*chaincode]
+	if !exists || (*logPath == "") == (flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var transactions []replay.Transaction
+	if *logPath != "" {
+		file, err := os.Open(*logPath)
+		if err != nil {
+			fail(err)
+		}
+		transactions, err = replay.LoadLog(file)
+		file.Close()
+		if err != nil {
+			fail(fmt.Errorf("%s: %s", *logPath, err.Error()))
+		}
+	} else {
+		var parsed []*blocks.Block
+		for _, path := range flag.Args() {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				fail(err)
+			}
+			block, err := blocks.ParseBlock(data)
+			if err != nil {
+				fail(fmt.Errorf("%s: %s", path, err.Error()))
+			}
+			parsed = append(parsed, block)
+		}
+		transactions = replay.FromBlocks(parsed, *name)
+	}
+
+	stub, outcomes, err := replay.Replay(newChaincode(), transactions, *stopAt)
+	if err != nil {
+		fail(err)
+	}
+	for _, outcome := range outcomes {
+		result := outcome.Payload
+		if !outcome.Success() {
+			result = outcome.Message
+		}
+		fmt.Fprintf(os.Stderr, "%s %s(%s) %d %s\n", outcome.TxID, outcome.Function, strings.Join(outcome.Args, ", "), outcome.Status, result)
+	}
+	if err := inspect.Write(os.Stdout, inspect.Decode(stub.State).Ledgers(""), *format); err != nil {
+		fail(err)
+	}
+
+	if *compare == "" {
+		return
+	}
+	file, err := os.Open(*compare)
+	if err != nil {
+		fail(err)
+	}
+	defer file.Close()
+	expected, err := inspect.LoadDump(file)
+	if err != nil {
+		fail(fmt.Errorf("%s: %s", *compare, err.Error()))
+	}
+	differences := replay.Compare(stub.State, expected)
+	for _, difference := range differences {
+		fmt.Fprintln(os.Stderr, difference.String())
+	}
+	if len(differences) > 0 {
+		fmt.Fprintf(os.Stderr, "%d keys differ from %s\n", len(differences), *compare)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+// Command stateinspector prints the per-owner ledgers of a marbles world
+// state dump, a JSON object of keys to values or a list of {key, value}.
+//
+//	go run ./cmd/stateinspector -marble redMarbles state.json
+//	go run ./cmd/stateinspector -format csv state.json > ledgers.csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"marbles-meetup/blocks"
+	"marbles-meetup/inspect"
+	"os"
+)
+
+func main() {
+	format := flag.String("format", inspect.FORMAT_TEXT, "output format: text, json or csv")
+	marble := flag.String("marble", "", "only print the ledgers of this marble")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [state-file]\n\nReads the state from stdin without state-file.\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var input io.Reader = os.Stdin
+	if flag.NArg() == 1 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+	dump, err := inspect.LoadDump(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	state := inspect.Decode(dump)
+	if err := inspect.Write(os.Stdout, state.Ledgers(*marble), *format); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	for _, unknown := range state.Unknown {
+		fmt.Fprintf(os.Stderr, "unknown key %s = %s\n", blocks.FormatKey(unknown.Key), blocks.FormatValue(unknown.Value))
+	}
+}
@@ -0,0 +1,78 @@
+package differential
+
+import (
+	"marbles-meetup/general"
+	"marbles-meetup/high-throughput"
+	"marbles-meetup/high-throughput-phantom"
+	"marbles-meetup/util"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var generalTarget = util.Target{
+	Name:        "general",
+	Chaincode:   func() shim.Chaincode { return new(general.SimpleChaincode) },
+	Functions:   util.Functions{Init: general.FUNCTION_INIT, Transfer: general.FUNCTION_TRANSFER, Read: general.FUNCTION_READ},
+	SenderCheck: true,
+}
+
+var highThroughputTarget = util.Target{
+	Name:      "highThroughput",
+	Chaincode: func() shim.Chaincode { return new(highthroughput.HighThroughputChaincode) },
+	Functions: util.Functions{Init: highthroughput.FUNCTION_INIT, Transfer: highthroughput.FUNCTION_TRANSFER,
+		Read: highthroughput.FUNCTION_READ, Prune: highthroughput.FUNCTION_PRUNE},
+	SenderCheck: true,
+}
+
+var highThroughputPhantomTarget = util.Target{
+	Name:      "highThroughputPhantom",
+	Chaincode: func() shim.Chaincode { return new(highthroughputphantom.HighThroughputChaincode) },
+	Functions: util.Functions{Init: highthroughputphantom.FUNCTION_INIT, Transfer: highthroughputphantom.FUNCTION_TRANSFER,
+		Read: highthroughputphantom.FUNCTION_READ, Prune: highthroughputphantom.FUNCTION_PRUNE},
+}
+
+func Test_DIFFERENTIAL_general_highThroughput_success(t *testing.T) {
+	util.CheckDifferential(t, generalTarget, highThroughputTarget, util.DefaultWorkload,
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16)
+}
+
+func Test_DIFFERENTIAL_highThroughput_phantom_diverge(t *testing.T) {
+	// the phantom chaincode does not check the sender amount
+	ops := []util.Op{
+		{Kind: util.OP_INIT, Marble: "RedMarble", Owner: "alice", Amount: 100},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "alice", Receiver: "bob", Amount: 30},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "bob", Receiver: "carol", Amount: 50},
+	}
+	divergence := util.DiffWorkload(highThroughputTarget, highThroughputPhantomTarget, util.DefaultWorkload, ops)
+	if divergence == nil {
+		t.Fatalf("Transfer over the sender amount should diverge at step 2")
+	}
+	if divergence.Step != 2 {
+		t.Fatalf("Transfer over the sender amount should diverge at step 2: %s", divergence.Report())
+	}
+	if divergence.StatusA == "OK" || divergence.StatusB != "OK" {
+		t.Fatalf("Unexpected status: %s", divergence.Report())
+	}
+}
+
+func Test_DIFFERENTIAL_receiver_self_transfer_success(t *testing.T) {
+	// the receiver already holds marbles, then owners transfer to themselves:
+	// general once took the receiver balance from the sender and applied
+	// self transfers twice, the delta rows debited self transfers without
+	// crediting them back
+	ops := []util.Op{
+		{Kind: util.OP_INIT, Marble: "RedMarble", Owner: "alice", Amount: 100},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "alice", Receiver: "bob", Amount: 30},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "alice", Receiver: "bob", Amount: 20},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "bob", Receiver: "bob", Amount: 40},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "alice", Receiver: "alice", Amount: 50},
+		{Kind: util.OP_PRUNE, Marble: "RedMarble"},
+		{Kind: util.OP_TRANSFER, Marble: "RedMarble", Owner: "bob", Receiver: "alice", Amount: 50},
+	}
+	for _, target := range []util.Target{highThroughputTarget, highThroughputPhantomTarget} {
+		if divergence := util.DiffWorkload(generalTarget, target, util.DefaultWorkload, ops); divergence != nil {
+			t.Fatalf("Receiver and self transfers diverge: %s", divergence.Report())
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package differential replays the same workloads against the marble
+// chaincodes and checks that they report the same balances.
+package differential
@@ -2,9 +2,15 @@ package general
 
 import (
 	"encoding/json"
-	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/holders"
+	"marbles-meetup/identity"
+	"marbles-meetup/logging"
+	"marbles-meetup/paging"
+	"marbles-meetup/router"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -23,41 +29,144 @@ type marbleResponse struct {
 	Amount int         `json:"amount"`
 }
 
+type migratedBalance struct {
+	Owner  string `json:"owner"`
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type balanceMigration struct {
+	Moved     []migratedBalance `json:"moved"`
+	Unmatched []string          `json:"unmatched"`
+	Bookmark  string            `json:"bookmark"`
+}
+
+type portfolioEntry struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type portfolioResponse struct {
+	Owner   string           `json:"owner"`
+	Marbles []portfolioEntry `json:"marbles"`
+}
+
 const (
+	KEY_BALANCE = "Balance/owner/name"
+	KEY_PORTFOLIO = "Portfolio/owner/name"
+
+	MAX_NAME_LENGTH = 64
+	MAX_OWNER_LENGTH = 64
+
 	FUNCTION_INIT = "initMarbles"
 	FUNCTION_TRANSFER = "transferMarbles"
 	FUNCTION_READ = "readMarbles"
+	FUNCTION_LIST_HOLDERS = "listHolders"
+	FUNCTION_READ_PORTFOLIO = "readPortfolio"
+	FUNCTION_LIST_MARBLES = "listMarbles"
+	FUNCTION_MIGRATE_MARBLES = "migrateMarbles"
+	FUNCTION_MIGRATE_BALANCES = "migrateBalances"
 )
 
+var (
+	initMarblesArgs = []router.Arg{
+		router.Key("name", true, MAX_NAME_LENGTH),
+		router.LowerString("color", true),
+		router.Int("size", true, router.Bound(0), nil),
+		router.Int("amount", true, router.Bound(0), nil),
+		router.LowerKey("owner", true, MAX_OWNER_LENGTH),
+	}
+	transferMarblesArgs = []router.Arg{
+		router.Key("name", true, MAX_NAME_LENGTH),
+		router.LowerKey("sender", true, MAX_OWNER_LENGTH),
+		router.LowerKey("receiver", true, MAX_OWNER_LENGTH),
+		router.Int("amount", true, router.Bound(0), nil),
+	}
+	readMarblesArgs = []router.Arg{
+		router.Key("name", true, MAX_NAME_LENGTH),
+		router.LowerKey("owner", false, MAX_OWNER_LENGTH),
+	}
+	readPortfolioArgs = []router.Arg{
+		router.LowerKey("owner", true, MAX_OWNER_LENGTH),
+	}
+	listMarblesArgs = []router.Arg{
+		router.String("docType", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	migrateMarblesArgs = []router.Arg{
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	migrateBalancesArgs = []router.Arg{
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	listHoldersArgs = []router.Arg{
+		router.Key("name", true, MAX_NAME_LENGTH),
+		router.Bool("nonZero", false),
+		router.LowerString("order", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+)
+
+var logger = logging.NewLogger("marbles_general")
+
 type SimpleChaincode struct {
+	// the routes are built by the first Invoke, so new(SimpleChaincode) is ready to use
+	once   sync.Once
+	router *router.Router
 }
 
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		logger.Errorf("Error starting Simple chaincode: %s", err)
 	}
 }
 
+/**
+ * Init - configure logging of the chaincode
+ * to give in the args array are as follows:
+ *	- args[0] -> level; logging level, overrides CORE_CHAINCODE_LOGGING_LEVEL (not required)
+ *	- args[1] -> redact; "false" to log amounts and balances (not required)
+ *
+ * Like any Init, it runs in the chaincode process of the endorsing peers
+ * only, so the settings are local to that process; see logging.Configure.
+ *
+ * @param stub The chaincode shim
+ *
+ * @return A response structure indicating success or failure with a message
+ */
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	err := logging.Configure(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == FUNCTION_INIT {
-		return t.initMarbles(stub, args)
-	} else if function == FUNCTION_TRANSFER {
-		return t.transferMarbles(stub, args)
-	} else if function == FUNCTION_READ {
-		return t.readMarbles(stub, args)
-	}
+	t.once.Do(func() {
+		t.router = t.routes()
+	})
+	return t.router.Handle(stub)
+}
 
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+// routes binds the functions of the chaincode to their handlers.
+func (t *SimpleChaincode) routes() *router.Router {
+	return router.New(
+		router.Function{Name: FUNCTION_INIT, Args: initMarblesArgs, Handler: t.initMarbles},
+		router.Function{Name: FUNCTION_TRANSFER, Args: transferMarblesArgs, Handler: t.transferMarbles},
+		router.Function{Name: FUNCTION_READ, Args: readMarblesArgs, Handler: t.readMarbles},
+		router.Function{Name: FUNCTION_LIST_HOLDERS, Args: listHoldersArgs, Handler: t.listHolders},
+		router.Function{Name: FUNCTION_READ_PORTFOLIO, Args: readPortfolioArgs, Handler: t.readPortfolio},
+		router.Function{Name: FUNCTION_LIST_MARBLES, Args: listMarblesArgs, Handler: t.listMarbles},
+		router.Function{Name: FUNCTION_MIGRATE_MARBLES, Args: migrateMarblesArgs, Handler: t.migrateMarbles},
+		router.Function{Name: FUNCTION_MIGRATE_BALANCES, Args: migrateBalancesArgs, Handler: t.migrateBalances},
+	)
 }
 
 /**
@@ -70,38 +179,27 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
  *	- args[4] -> owner; owner id for this marble
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the initMarbles invocation
+ * @param args The parsed arguments for the initMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *SimpleChaincode) initMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call initMarbles")
-
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 6")
-	}
-
+func (t *SimpleChaincode) initMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
 	// Input sanitation
-	fmt.Println("- start init marble")
-	marbleName := args[0]
-	color := strings.ToLower(args[1])
-	size, err := strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
-	}
-	amount := args[3]
-	_, err = strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	}
-	owner := strings.ToLower(args[4])
+	marbleName := args.String("name")
+	color := args.String("color")
+	size := args.Int("size")
+	amount := strconv.Itoa(args.Int("amount"))
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_INIT).With("marble", marbleName)
+	log.Debug("start init marble")
 
 	// Check if marble already exists
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble: " + err.Error())
 	} else if marbleAsBytes != nil {
-		fmt.Println("This marble already exists: " + marbleName)
+		log.Warning("This marble already exists")
 		return shim.Error("This marble already exists: " + marbleName)
 	}
 
@@ -114,17 +212,24 @@ func (t *SimpleChaincode) initMarbles(stub shim.ChaincodeStubInterface, args []s
 	}
 
 	// Save marble to state
-	err = stub.PutState(marbleName, marbleJSONasBytes)
+	err = catalogue.Put(stub, marbleName, marbleJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	// Save marble amount to owner
-	key := owner + marbleName
+	key, err := stub.CreateCompositeKey(KEY_BALANCE, []string{owner, marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	err = stub.PutState(key, []byte(amount))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = putPortfolio(stub, owner, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	return shim.Success(nil)
 }
@@ -138,27 +243,21 @@ func (t *SimpleChaincode) initMarbles(stub shim.ChaincodeStubInterface, args []s
  *	- args[3] -> amount; amount to transfer
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the transferMarbles invocation
+ * @param args The parsed arguments for the transferMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *SimpleChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call transferMarbles")
-
-	if len(args) < 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
+func (t *SimpleChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+	sender := args.String("sender")
+	receiver := args.String("receiver")
+	amount := args.Int("amount")
 
-	marbleName := args[0]
-	sender := strings.ToLower(args[1])
-	receiver := strings.ToLower(args[2])
-	amount, err := strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	}
+	log := logger.ForTx(stub, FUNCTION_TRANSFER).With("marble", marbleName)
+	log.Debug("start transfer marble")
 
 	// check marble is existed
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble:" + err.Error())
 	} else if marbleAsBytes == nil {
@@ -166,7 +265,11 @@ func (t *SimpleChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args
 	}
 
 	// check sender amount
-	senderAmountAsBytes, err := stub.GetState(sender + marbleName)
+	senderKey, err := stub.CreateCompositeKey(KEY_BALANCE, []string{sender, marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	senderAmountAsBytes, err := stub.GetState(senderKey)
 	if err != nil {
 		return shim.Error("Failed to get sender amount of marbles:" + err.Error())
 	} else if senderAmountAsBytes == nil {
@@ -179,32 +282,57 @@ func (t *SimpleChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args
 
 	// check sender can transfer amount
 	if senderAmount < amount {
+		log.WithSensitive("senderAmount", senderAmount).WithSensitive("amount", amount).Warning("sender cannot transfer amount")
 		return shim.Error("Cannot transfer amount:")
 	}
 
+	// transfer to the sender itself leaves the amount unchanged. GetState does
+	// not see writes of the same transaction, so it must not be applied twice.
+	if sender == receiver {
+		return shim.Success(nil)
+	}
+
 	// receiver amount
-	receiverAmountAsBytes, err := stub.GetState(receiver + marbleName)
+	receiverKey, err := stub.CreateCompositeKey(KEY_BALANCE, []string{receiver, marbleName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	receiverAmountAsBytes, err := stub.GetState(receiverKey)
 	receiverAmount := 0
 	if err != nil {
 		return shim.Error("Failed to get amount of marbles:" + err.Error())
 	} else if receiverAmountAsBytes != nil {
-		receiverAmount, err = strconv.Atoi(string(senderAmountAsBytes))
+		receiverAmount, err = strconv.Atoi(string(receiverAmountAsBytes))
 		if err != nil {
 			return shim.Error("Failed to get receiver amount of marbles:" + err.Error())
 		}
 	}
 
 	// Save amount
-	err = stub.PutState(sender+marbleName, []byte(strconv.Itoa(senderAmount-amount)))
+	err = stub.PutState(senderKey, []byte(strconv.Itoa(senderAmount-amount)))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	fmt.Println(strconv.Itoa(receiverAmount + amount))
-	err = stub.PutState(receiver+marbleName, []byte(strconv.Itoa(receiverAmount+amount)))
+	log.WithSensitive("receiverAmount", receiverAmount+amount).Debug("update receiver amount")
+	err = stub.PutState(receiverKey, []byte(strconv.Itoa(receiverAmount+amount)))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// keep the portfolio index to the owners with marbles
+	if amount > 0 {
+		err = putPortfolio(stub, receiver, marbleName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if senderAmount == amount {
+		err = delPortfolio(stub, sender, marbleName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	return shim.Success(nil)
 }
 
@@ -215,19 +343,16 @@ func (t *SimpleChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args
  *	- args[1] -> owner; owner of marble (not required)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the readMarbles query
+ * @param args The parsed arguments for the readMarbles query
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *SimpleChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[query] Call readMarbles")
-
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
+func (t *SimpleChaincode) readMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
 
-	name := args[0]
-	marbleAsbytes, err := stub.GetState(name) //get the marble from chaincode state
+	log := logger.ForTx(stub, FUNCTION_READ).With("marble", name)
+	log.Debug("start read marble")
+	marbleAsbytes, err := catalogue.Get(stub, name) //get the marble from chaincode state
 	if err != nil {
 		return shim.Error("{\"Error\":\"Failed to get state for " + name + "\"}")
 	} else if marbleAsbytes == nil {
@@ -241,10 +366,14 @@ func (t *SimpleChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []s
 	result := &marbleResponse{marble, "", 0}
 
 	// if parameter includes owner, return with amount info
-	if len(args) > 1 {
-		owner := args[1]
+	if args.Has("owner") {
+		owner := args.String("owner")
 		result.Owner = owner
-		ownerAmountAsBytes, err := stub.GetState(owner + name)
+		ownerKey, err := stub.CreateCompositeKey(KEY_BALANCE, []string{owner, name})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		ownerAmountAsBytes, err := stub.GetState(ownerKey)
 		if err != nil {
 			return shim.Error("{\"Error\":\"Failed to get amount state for name:" + name + ", owner: " + owner + "\"}")
 		}
@@ -263,3 +392,305 @@ func (t *SimpleChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []s
 	}
 	return shim.Success(resultBytes)
 }
+
+/**
+ * readPortfolio - read every marble an owner holds
+ * to give in the args array are as follows:
+ *	- args[0] -> owner; owner id (required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readPortfolio query
+ *
+ * @return A response structure with the marbles of the owner and their non-zero amounts
+ */
+func (t *SimpleChaincode) readPortfolio(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_READ_PORTFOLIO)
+	log.Debug("start read portfolio")
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey(KEY_PORTFOLIO, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer indexIterator.Close()
+
+	result := &portfolioResponse{owner, []portfolioEntry{}}
+	for indexIterator.HasNext() {
+		responseRange, err := indexIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		marbleName := keyParts[1]
+		amount := 0
+		balanceKey, err := stub.CreateCompositeKey(KEY_BALANCE, []string{owner, marbleName})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		amountAsBytes, err := stub.GetState(balanceKey)
+		if err != nil {
+			return shim.Error("Failed to get owner amount of marbles:" + err.Error())
+		} else if amountAsBytes != nil {
+			amount, err = strconv.Atoi(string(amountAsBytes))
+			if err != nil {
+				return shim.Error("Failed to get owner amount of marbles:" + err.Error())
+			}
+		}
+		if amount != 0 {
+			result.Marbles = append(result.Marbles, portfolioEntry{marbleName, amount})
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
+
+// putPortfolio adds a marble to the portfolio index of owner. The write is
+// blind, so concurrent transfers to the same owner do not conflict.
+func putPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func delPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+/**
+ * listMarbles - list the marble records in the catalogue
+ * to give in the args array are as follows:
+ *	- args[0] -> docType; only list records of this docType (not required)
+ *	- args[1] -> pageSize; number of records per page, default 100 (not required)
+ *	- args[2] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listMarbles query
+ *
+ * @return A response structure with a page of marble records in name order
+ */
+func (t *SimpleChaincode) listMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_LIST_MARBLES)
+	log.Debug("start list marbles")
+
+	page, err := catalogue.List(stub, args.String("docType"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+/**
+ * migrateMarbles - move the marble records stored under their bare name into the catalogue, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> pageSize; number of keys to scan, default 100 (not required)
+ *	- args[1] -> bookmark; bookmark of the previous migration (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the migrateMarbles invocation
+ *
+ * @return A response structure with the moved marbles and the bookmark to continue from, empty when done
+ */
+func (t *SimpleChaincode) migrateMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_MIGRATE_MARBLES)
+
+	err := identity.RequireAdmin(stub, FUNCTION_MIGRATE_MARBLES)
+	if err != nil {
+		log.With("error", err.Error()).Warning("migration refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start migrate marbles")
+
+	migration, err := catalogue.Migrate(stub, args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	migrationBytes, err := json.Marshal(migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(migrationBytes)
+}
+
+/**
+ * migrateBalances - move the balances stored at owner+name by older versions to KEY_BALANCE keys, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> pageSize; number of keys to scan, default 100 (not required)
+ *	- args[1] -> bookmark; bookmark of the previous migration (not required)
+ *
+ * The key is split at the longest name of a marble in the catalogue, so
+ * migrateMarbles must run first. Balances received since the upgrade are
+ * added to the migrated ones.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the migrateBalances invocation
+ *
+ * @return A response structure with the moved balances, the keys of no marble and the bookmark to continue from, empty when done
+ */
+func (t *SimpleChaincode) migrateBalances(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_MIGRATE_BALANCES)
+
+	err := identity.RequireAdmin(stub, FUNCTION_MIGRATE_BALANCES)
+	if err != nil {
+		log.With("error", err.Error()).Warning("migration refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start migrate balances")
+
+	names, err := catalogue.Names(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	iterator, err := paging.SimpleKeys(stub, args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	page, next, err := paging.Read(iterator, args.String("bookmark"), args.Int("pageSize"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	migration := &balanceMigration{[]migratedBalance{}, []string{}, next}
+	for _, responseRange := range page {
+		amount, err := strconv.Atoi(string(responseRange.Value))
+		if err != nil {
+			// not a balance, e.g. a marble record
+			continue
+		}
+		marbleName := ""
+		for _, name := range names {
+			if strings.HasSuffix(responseRange.Key, name) && len(responseRange.Key) > len(name) && len(name) > len(marbleName) {
+				marbleName = name
+			}
+		}
+		owner := strings.TrimSuffix(responseRange.Key, marbleName)
+		balanceKey, err := stub.CreateCompositeKey(KEY_BALANCE, []string{owner, marbleName})
+		if marbleName == "" || err != nil {
+			migration.Unmatched = append(migration.Unmatched, responseRange.Key)
+			continue
+		}
+
+		receivedAsBytes, err := stub.GetState(balanceKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		} else if receivedAsBytes != nil {
+			received, err := strconv.Atoi(string(receivedAsBytes))
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			amount += received
+		}
+		err = stub.PutState(balanceKey, []byte(strconv.Itoa(amount)))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.DelState(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if amount > 0 {
+			err = putPortfolio(stub, owner, marbleName)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		migration.Moved = append(migration.Moved, migratedBalance{owner, marbleName, amount})
+	}
+
+	migrationBytes, err := json.Marshal(migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(migrationBytes)
+}
+
+/**
+ * listHolders - list the balance of every owner of a marble
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (required)
+ *	- args[1] -> nonZero; "true" to leave out owners with a balance of 0 (not required)
+ *	- args[2] -> order; "owner" (default) or "amount" for the largest balances first (not required)
+ *	- args[3] -> pageSize; number of holders per page, default 100 (not required)
+ *	- args[4] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listHolders query
+ *
+ * @return A response structure with a page of holders, the holder count and the sum of their balances
+ */
+func (t *SimpleChaincode) listHolders(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_LIST_HOLDERS).With("marble", name)
+	log.Debug("start list holders")
+
+	// check marble is existed
+	marbleAsBytes, err := catalogue.Get(stub, name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	balances, err := getBalances(stub, name)
+	if err != nil {
+		return shim.Error("Cannot get balances, err: " + err.Error())
+	}
+	page, err := holders.List(name, balances, args.Bool("nonZero"), args.String("order"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+// getBalances reads the balance of every owner of a marble. The balance
+// keys start with the owner, so the whole KEY_BALANCE namespace is scanned.
+func getBalances(stub shim.ChaincodeStubInterface, marbleName string) (map[string]int, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(KEY_BALANCE, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	balances := make(map[string]int)
+	for iterator.HasNext() {
+		responseRange, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		if keyParts[1] != marbleName {
+			continue
+		}
+		amount, err := strconv.Atoi(string(responseRange.Value))
+		if err != nil {
+			return nil, err
+		}
+		balances[keyParts[0]] = amount
+	}
+	return balances, nil
+}
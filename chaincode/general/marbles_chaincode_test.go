@@ -2,9 +2,12 @@ package general
 
 import (
 	"encoding/json"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
 	"marbles-meetup/util"
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -19,13 +22,22 @@ const transferAmount = 30
 func initMarble(t *testing.T) *shim.MockStub {
 	var scc = new(SimpleChaincode)
 	var stub = shim.NewMockStub("marbles", scc)
+	initMarbleOn(t, stub)
+
+	return stub
+}
+
+func initMarbleOn(t *testing.T, stub *shim.MockStub) {
 	stub.MockInit("1", [][]byte{[]byte("init")})
 	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
 		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
 		[]byte(strconv.Itoa(totalAmount)), []byte(sender)}
 	util.CheckInvoke(t, stub, arguments, "1")
+}
 
-	return stub
+func balanceKey(stub *shim.MockStub, owner string) string {
+	key, _ := stub.CreateCompositeKey(KEY_BALANCE, []string{owner, sampleMarble.Name})
+	return key
 }
 
 func Test_MARBLES_initMarble_success(t *testing.T) {
@@ -36,10 +48,11 @@ func Test_MARBLES_initMarble_success(t *testing.T) {
 
 	// check marble state
 	sampleMarbleBytes, _ := json.Marshal(sampleMarble)
-	util.CheckState(t, stub, sampleMarble.Name, string(sampleMarbleBytes))
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	util.CheckState(t, stub, marbleKey, string(sampleMarbleBytes))
 
 	// check owner amount
-	util.CheckState(t, stub, sender + sampleMarble.Name, strconv.Itoa(totalAmount))
+	util.CheckState(t, stub, balanceKey(stub, sender), strconv.Itoa(totalAmount))
 }
 
 func Test_MARBLES_transferMarbles_readMarbles_success(t *testing.T) {
@@ -54,10 +67,33 @@ func Test_MARBLES_transferMarbles_readMarbles_success(t *testing.T) {
 	util.CheckInvoke(t, stub, arguments, "1")
 
 	// check sender amount state
-	util.CheckState(t, stub, sender + sampleMarble.Name, strconv.Itoa(totalAmount - transferAmount))
+	util.CheckState(t, stub, balanceKey(stub, sender), strconv.Itoa(totalAmount - transferAmount))
 
 	// check receiver amount state
-	util.CheckState(t, stub, receiver + sampleMarble.Name, strconv.Itoa(transferAmount))
+	util.CheckState(t, stub, balanceKey(stub, receiver), strconv.Itoa(transferAmount))
+}
+
+func Test_MARBLES_transferMarbles_receiver_self_success(t *testing.T) {
+	// invoke initMarbles
+	stub := initMarble(t)
+
+	// a receiver who already holds marbles keeps them
+	for _, txid := range []string{"2", "3"} {
+		arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+			[]byte(sender), []byte(receiver), []byte(strconv.Itoa(transferAmount))}
+		util.CheckInvoke(t, stub, arguments, txid)
+	}
+
+	// a transfer to the sender itself leaves the balance unchanged
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(receiver), []byte(receiver), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub, arguments, "4")
+
+	for owner, amount := range map[string]int{sender: totalAmount - 2*transferAmount, receiver: 2*transferAmount} {
+		result, _ := json.Marshal(&marbleResponse{sampleMarble, owner, amount})
+		arguments = [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(owner)}
+		util.CheckQuery(t, stub, arguments, string(result), "5")
+	}
 }
 
 func Test_MARBLES_readMarbles_success(t *testing.T) {
@@ -88,4 +124,181 @@ func Test_MARBLES_readMarbles_success(t *testing.T) {
 	receiverResultBytes, _ = json.Marshal(receiverResult)
 	arguments = [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(receiver)}
 	util.CheckQuery(t, stub, arguments, string(receiverResultBytes), "1")
-}
\ No newline at end of file
+}
+func Test_MARBLES_transferMarbles_access(t *testing.T) {
+	// invoke initMarbles
+	stub := util.NewRecordingStub("marbles", new(SimpleChaincode))
+	initMarbleOn(t, stub.MockStub)
+
+	// invoke transfer
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(sender), []byte(receiver), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub.MockStub, arguments, "2")
+
+	// check sender and receiver balance keys are both read and written
+	access := stub.LastAccess()
+	indexKey, _ := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{receiver, sampleMarble.Name})
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	access.AssertReads(t, marbleKey, balanceKey(stub.MockStub, sender), balanceKey(stub.MockStub, receiver))
+	access.AssertNoRangeReads(t)
+	access.AssertWritesOnly(t, balanceKey(stub.MockStub, sender), balanceKey(stub.MockStub, receiver), indexKey)
+
+	// the portfolio index is written blind
+	access.AssertNoReadOf(t, indexKey)
+}
+
+func Test_MARBLES_initMarble_fail(t *testing.T) {
+	var scc = new(SimpleChaincode)
+	var stub = shim.NewMockStub("marbles", scc)
+	stub.MockInit("1", [][]byte{[]byte("init")})
+
+	// missing owner
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte(strconv.Itoa(totalAmount))}
+	util.CheckInvokeFails(t, stub, arguments, "Incorrect number of arguments. Expecting 5", "1")
+
+	// negative amount
+	arguments = [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte("-1"), []byte(sender)}
+	util.CheckInvokeFails(t, stub, arguments, "amount cannot be less than 0", "1")
+	marbleKey, _ := catalogue.Key(stub, sampleMarble.Name)
+	util.CheckStateNotExisted(t, stub, marbleKey)
+}
+
+var marblesTarget = util.Target{
+	Name:        "general",
+	Chaincode:   func() shim.Chaincode { return new(SimpleChaincode) },
+	Functions:   util.Functions{Init: FUNCTION_INIT, Transfer: FUNCTION_TRANSFER, Read: FUNCTION_READ},
+	SenderCheck: true,
+}
+
+func Test_MARBLES_workload_conservation(t *testing.T) {
+	util.CheckWorkloads(t, marblesTarget, util.DefaultWorkload, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func Benchmark_MARBLES_initMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_INIT)
+}
+
+func Benchmark_MARBLES_transferMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_TRANSFER)
+}
+
+func Benchmark_MARBLES_readMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_READ)
+}
+
+func Test_MARBLES_listHolders_success(t *testing.T) {
+	stub := initMarble(t)
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(sender), []byte(receiver), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub, arguments, "2")
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(receiver), []byte("carol"), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub, arguments, "3")
+
+	// aliceRedMarble also ends with the name of Marble
+	arguments = [][]byte{[]byte(FUNCTION_INIT), []byte("Marble"), []byte("blue"), []byte("10"), []byte("5"), []byte("dave")}
+	util.CheckInvoke(t, stub, arguments, "4")
+
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name)}
+	util.CheckQuery(t, stub, arguments, `{"marble":"RedMarble","holders":[{"owner":"alice","amount":99970},{"owner":"bob","amount":0},
+		{"owner":"carol","amount":30}],"count":3,"sum":100000,"bookmark":""}`, "5")
+
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(`{"name":"RedMarble","nonZero":true,"order":"amount","pageSize":1}`)}
+	util.CheckQuery(t, stub, arguments, `{"marble":"RedMarble","holders":[{"owner":"alice","amount":99970}],"count":2,"sum":100000,
+		"bookmark":"99970:alice"}`, "5")
+
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte("Marble")}
+	util.CheckQuery(t, stub, arguments, `{"marble":"Marble","holders":[{"owner":"dave","amount":5}],"count":1,"sum":5,"bookmark":""}`, "5")
+}
+
+func Test_MARBLES_listHolders_fail(t *testing.T) {
+	stub := initMarble(t)
+
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte("BlueMarble")}, "Marble does not exist", "2")
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name), []byte("yes")},
+		"nonZero must be true or false", "2")
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name), []byte("true"), []byte("size")},
+		"order must be owner or amount", "2")
+}
+
+func Test_MARBLES_readPortfolio_success(t *testing.T) {
+	stub := initMarble(t)
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(sender), []byte(receiver), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub, arguments, "2")
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(receiver)},
+		`{"owner":"bob","marbles":[{"name":"RedMarble","amount":30}]}`, "3")
+
+	// the sender drops out of the index with its last marble
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(receiver), []byte(sender), []byte(strconv.Itoa(transferAmount))}
+	util.CheckInvoke(t, stub, arguments, "4")
+	indexKey, _ := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{receiver, sampleMarble.Name})
+	util.CheckStateNotExisted(t, stub, indexKey)
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(receiver)}, `{"owner":"bob","marbles":[]}`, "5")
+}
+
+func Test_MARBLES_migrate_success(t *testing.T) {
+	stub := util.NewIdentityStub("marbles", new(SimpleChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	initMarbleOn(t, stub.MockStub)
+
+	// a marble and balances written by an older version
+	legacyMarble := &marble{"marble", "BlueMarble", "blue", 10}
+	legacyMarbleBytes, _ := json.Marshal(legacyMarble)
+	stub.MockTransactionStart("legacy")
+	stub.PutState(legacyMarble.Name, legacyMarbleBytes)
+	stub.PutState(receiver+legacyMarble.Name, []byte("5"))
+	stub.PutState("carol"+sampleMarble.Name, []byte("7"))
+	stub.PutState("zed", []byte("1"))
+	stub.MockTransactionEnd("legacy")
+
+	// the record is read from its old key, but not listed
+	legacyResult, _ := json.Marshal(&marbleResponse{legacyMarble, "", 0})
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ), []byte(legacyMarble.Name)}, string(legacyResult), "2")
+	sampleMarbleBytes, _ := json.Marshal(sampleMarble)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_LIST_MARBLES)}, `{"marbles":[`+string(sampleMarbleBytes)+`],"bookmark":""}`, "3")
+
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_MIGRATE_MARBLES)}, `{"moved":["BlueMarble"],"bookmark":""}`, "4")
+	util.CheckStateNotExisted(t, stub.MockStub, legacyMarble.Name)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_LIST_MARBLES), []byte(`{"docType":"marble","pageSize":1}`)},
+		`{"marbles":[`+string(legacyMarbleBytes)+`],"bookmark":"BlueMarble"}`, "5")
+
+	// carol receives before her old balance is migrated
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name), []byte(sender), []byte("carol"), []byte("3")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "6")
+
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_MIGRATE_BALANCES)}, `{"moved":[{"owner":"bob","name":"BlueMarble","amount":5},
+		{"owner":"carol","name":"RedMarble","amount":10}],"unmatched":["zed"],"bookmark":""}`, "7")
+	util.CheckStateNotExisted(t, stub.MockStub, "carol"+sampleMarble.Name)
+	util.CheckState(t, stub.MockStub, balanceKey(stub.MockStub, "carol"), "10")
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(legacyMarble.Name)},
+		`{"marble":"BlueMarble","holders":[{"owner":"bob","amount":5}],"count":1,"sum":5,"bookmark":""}`, "8")
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(receiver)},
+		`{"owner":"bob","marbles":[{"name":"BlueMarble","amount":5}]}`, "8")
+}
+
+func Test_MARBLES_migrate_fail(t *testing.T) {
+	stub := util.NewIdentityStub("marbles", new(SimpleChaincode)).As("Org1MSP", "alice", nil)
+	initMarbleOn(t, stub.MockStub)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_MIGRATE_MARBLES)}, "Only admins may call migrateMarbles", "2")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_MIGRATE_BALANCES)}, "Only admins may call migrateBalances", "2")
+}
+
+func Test_MARBLES_keys_fail(t *testing.T) {
+	stub := initMarble(t)
+
+	// concatenated, alice+redMarble and alicered+Marble were the same key
+	util.CheckInvoke(t, stub, [][]byte{[]byte(FUNCTION_INIT), []byte("redMarble"), []byte("red"), []byte("1"), []byte("10"), []byte(sender)}, "2")
+	util.CheckInvoke(t, stub, [][]byte{[]byte(FUNCTION_INIT), []byte("Marble"), []byte("blue"), []byte("1"), []byte("5"), []byte(receiver)}, "3")
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ), []byte("Marble"), []byte("alicered")},
+		`{"marble":{"docType":"marble","name":"Marble","color":"blue","size":1},"owner":"alicered","amount":0}`, "4")
+
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_READ), []byte("")}, "name cannot be empty", "3")
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte("bo\x00b")}, "owner cannot contain 0x00", "3")
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_INIT), []byte(strings.Repeat("x", MAX_NAME_LENGTH+1)), []byte("red"), []byte("1"), []byte("1"), []byte(sender)},
+		"name cannot be longer than 64 bytes", "3")
+}
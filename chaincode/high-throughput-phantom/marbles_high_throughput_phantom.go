@@ -2,9 +2,15 @@ package highthroughputphantom
 
 import (
 	"encoding/json"
-	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/holders"
+	"marbles-meetup/identity"
+	"marbles-meetup/logging"
+	"marbles-meetup/paging"
+	"marbles-meetup/router"
+	"marbles-meetup/snapshot"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -23,46 +29,140 @@ type marbleResponse struct {
 	Amount int         `json:"amount"`
 }
 
+type portfolioEntry struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type portfolioResponse struct {
+	Owner   string           `json:"owner"`
+	Marbles []portfolioEntry `json:"marbles"`
+}
+
 const (
 	KEY_TRANSFER = "Transfer/name/sender/receiver/amount/txid"
+	KEY_PORTFOLIO = "Portfolio/owner/name"
 
 	FUNCTION_INIT = "initMarbles"
 	FUNCTION_TRANSFER = "transferMarbles"
 	FUNCTION_READ = "readMarbles"
 	FUNCTION_PRUNE = "pruneMarbles"
+	FUNCTION_EXPORT = "exportMarbleState"
+	FUNCTION_IMPORT = "importMarbleState"
+	FUNCTION_LIST_HOLDERS = "listHolders"
+	FUNCTION_READ_PORTFOLIO = "readPortfolio"
+	FUNCTION_LIST_MARBLES = "listMarbles"
+	FUNCTION_MIGRATE_MARBLES = "migrateMarbles"
 )
 
+var (
+	initMarblesArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("color", true),
+		router.Int("size", true, router.Bound(0), nil),
+		router.Int("amount", true, router.Bound(0), nil),
+		router.LowerString("owner", true),
+	}
+	transferMarblesArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("sender", true),
+		router.LowerString("receiver", true),
+		router.Int("amount", true, router.Bound(0), nil),
+	}
+	readMarblesArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("owner", false),
+	}
+	pruneMarblesArgs = []router.Arg{
+		router.String("name", true),
+	}
+	exportMarbleStateArgs = []router.Arg{
+		router.String("name", true),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	importMarbleStateArgs = []router.Arg{
+		router.JSON("snapshot", true),
+	}
+	readPortfolioArgs = []router.Arg{
+		router.LowerString("owner", true),
+	}
+	listMarblesArgs = []router.Arg{
+		router.String("docType", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	migrateMarblesArgs = []router.Arg{
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	listHoldersArgs = []router.Arg{
+		router.String("name", true),
+		router.Bool("nonZero", false),
+		router.LowerString("order", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+)
+
+var logger = logging.NewLogger("marbles_high_throughput_phantom")
+
 type HighThroughputChaincode struct {
+	// the routes are built by the first Invoke, so new(HighThroughputChaincode) is ready to use
+	once   sync.Once
+	router *router.Router
 }
 
 func main() {
 	err := shim.Start(new(HighThroughputChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		logger.Errorf("Error starting Simple chaincode: %s", err)
 	}
 }
 
+/**
+ * Init - configure logging of the chaincode
+ * to give in the args array are as follows:
+ *	- args[0] -> level; logging level, overrides CORE_CHAINCODE_LOGGING_LEVEL (not required)
+ *	- args[1] -> redact; "false" to log amounts and balances (not required)
+ *
+ * Like any Init, it runs in the chaincode process of the endorsing peers
+ * only, so the settings are local to that process; see logging.Configure.
+ *
+ * @param stub The chaincode shim
+ *
+ * @return A response structure indicating success or failure with a message
+ */
 func (t *HighThroughputChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	err := logging.Configure(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
 func (t *HighThroughputChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == FUNCTION_INIT {
-		return t.initMarbles(stub, args)
-	} else if function == FUNCTION_TRANSFER {
-		return t.transferMarbles(stub, args)
-	} else if function == FUNCTION_READ {
-		return t.readMarbles(stub, args)
-	} else if function == FUNCTION_PRUNE {
-		return t.pruneMarbles(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	t.once.Do(func() {
+		t.router = t.routes()
+	})
+	return t.router.Handle(stub)
+}
+
+// routes binds the functions of the chaincode to their handlers.
+func (t *HighThroughputChaincode) routes() *router.Router {
+	return router.New(
+		router.Function{Name: FUNCTION_INIT, Args: initMarblesArgs, Handler: t.initMarbles},
+		router.Function{Name: FUNCTION_TRANSFER, Args: transferMarblesArgs, Handler: t.transferMarbles},
+		router.Function{Name: FUNCTION_READ, Args: readMarblesArgs, Handler: t.readMarbles},
+		router.Function{Name: FUNCTION_PRUNE, Args: pruneMarblesArgs, Handler: t.pruneMarbles},
+		router.Function{Name: FUNCTION_EXPORT, Args: exportMarbleStateArgs, Handler: t.exportMarbleState},
+		router.Function{Name: FUNCTION_IMPORT, Args: importMarbleStateArgs, Handler: t.importMarbleState},
+		router.Function{Name: FUNCTION_LIST_HOLDERS, Args: listHoldersArgs, Handler: t.listHolders},
+		router.Function{Name: FUNCTION_READ_PORTFOLIO, Args: readPortfolioArgs, Handler: t.readPortfolio},
+		router.Function{Name: FUNCTION_LIST_MARBLES, Args: listMarblesArgs, Handler: t.listMarbles},
+		router.Function{Name: FUNCTION_MIGRATE_MARBLES, Args: migrateMarblesArgs, Handler: t.migrateMarbles},
+	)
 }
 
 /**
@@ -75,38 +175,27 @@ func (t *HighThroughputChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Re
  *	- args[4] -> owner; owner id for this marble
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the initMarbles invocation
+ * @param args The parsed arguments for the initMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call initMarbles")
-
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 6")
-	}
-
+func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
 	// Input sanitation
-	fmt.Println("- start init marble")
-	marbleName := args[0]
-	color := strings.ToLower(args[1])
-	size, err := strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
-	}
-	amount := args[3]
-	_, err = strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	}
-	owner := strings.ToLower(args[4])
+	marbleName := args.String("name")
+	color := args.String("color")
+	size := args.Int("size")
+	amount := strconv.Itoa(args.Int("amount"))
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_INIT).With("marble", marbleName)
+	log.Debug("start init marble")
 
 	// Check if marble already exists
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble: " + err.Error())
 	} else if marbleAsBytes != nil {
-		fmt.Println("This marble already exists: " + marbleName)
+		log.Warning("This marble already exists")
 		return shim.Error("This marble already exists: " + marbleName)
 	}
 
@@ -119,7 +208,7 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
 	}
 
 	// Save marble to state
-	err = stub.PutState(marbleName, marbleJSONasBytes)
+	err = catalogue.Put(stub, marbleName, marbleJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -131,6 +220,13 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
 		return shim.Error(err.Error())
 	}
 	err = stub.PutState(compositeKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putPortfolio(stub, owner, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	return shim.Success(nil)
 }
@@ -144,29 +240,21 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
  *	- args[3] -> amount; amount to transfer
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the transferMarbles invocation
+ * @param args The parsed arguments for the transferMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call transferMarbles")
+func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+	sender := args.String("sender")
+	receiver := args.String("receiver")
+	amount := args.Int("amount")
 
-	if len(args) < 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
-
-	marbleName := args[0]
-	sender := strings.ToLower(args[1])
-	receiver := strings.ToLower(args[2])
-	amount, err := strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	} else if amount < 0 {
-		return shim.Error("amount cannot be negative")
-	}
+	log := logger.ForTx(stub, FUNCTION_TRANSFER).With("marble", marbleName)
+	log.Debug("start transfer marble")
 
 	// check marble is existed
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble:" + err.Error())
 	} else if marbleAsBytes == nil {
@@ -196,6 +284,12 @@ func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterfa
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if amount > 0 {
+		err = putPortfolio(stub, receiver, marbleName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	return shim.Success(nil)
 }
@@ -207,19 +301,16 @@ func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterfa
  *	- args[1] -> owner; owner of marble (not required)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the readMarbles query
+ * @param args The parsed arguments for the readMarbles query
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[query] Call readMarbles")
-
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
+func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
 
-	name := args[0]
-	marbleAsbytes, err := stub.GetState(name) //get the marble from chaincode state
+	log := logger.ForTx(stub, FUNCTION_READ).With("marble", name)
+	log.Debug("start read marble")
+	marbleAsbytes, err := catalogue.Get(stub, name) //get the marble from chaincode state
 	if err != nil {
 		return shim.Error("{\"Error\":\"Failed to get state for " + name + "\"}")
 	} else if marbleAsbytes == nil {
@@ -233,8 +324,8 @@ func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface,
 	result := &marbleResponse{marble, "", 0}
 
 	// if parameter includes owner, return with amount info
-	if len(args) > 1 {
-		owner := args[1]
+	if args.Has("owner") {
+		owner := args.String("owner")
 		result.Owner = owner
 		ownerAmount, err := getAmount(stub, name, owner)
 		if err != nil {
@@ -256,20 +347,18 @@ func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface,
  *	- args[0] -> name; name of marble (key)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the pruneMarbles invocation
+ * @param args The parsed arguments for the pruneMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call pruneMarbles")
+func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to prune")
-	}
-	name := args[0]
+	log := logger.ForTx(stub, FUNCTION_PRUNE).With("marble", name)
+	log.Debug("start prune marble")
 
 	// check marble is existed
-	marbleAsBytes, err := stub.GetState(name)
+	marbleAsBytes, err := catalogue.Get(stub, name)
 	if err != nil {
 		return shim.Error("Failed to get marble:" + err.Error())
 	} else if marbleAsBytes == nil {
@@ -337,12 +426,289 @@ func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface,
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+
+		// owners left with nothing drop out of the portfolio index
+		if value == 0 {
+			err = delPortfolio(stub, owner, name)
+		} else {
+			err = putPortfolio(stub, owner, name)
+		}
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 
 	return shim.Success(nil)
 }
 
+/**
+ * exportMarbleState - read a page of the marble record and delta rows of a marble
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> pageSize; number of rows per page, default 100 (not required)
+ *	- args[2] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the exportMarbleState query
+ *
+ * @return A response structure with the snapshot page, its bookmark is empty on the last page
+ */
+func (t *HighThroughputChaincode) exportMarbleState(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_EXPORT).With("marble", name)
+	log.Debug("start export marble")
+
+	page, err := snapshot.Export(stub, KEY_TRANSFER, name, args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+/**
+ * importMarbleState - write a page of an exported snapshot, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> snapshot; page returned by exportMarbleState, pages in order
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the importMarbleState invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) importMarbleState(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_IMPORT)
+
+	err := identity.RequireAdmin(stub, FUNCTION_IMPORT)
+	if err != nil {
+		log.With("error", err.Error()).Warning("import refused")
+		return shim.Error(err.Error())
+	}
+
+	page := &snapshot.Snapshot{}
+	err = json.Unmarshal([]byte(args.String("snapshot")), page)
+	if err != nil {
+		return shim.Error("Invalid snapshot: " + err.Error())
+	}
+	log = log.With("marble", page.Name)
+	log.Debug("start import marble")
+
+	// index the holders with the last page. Range reads do not see the
+	// writes of the import, so the rows of the page are added by hand.
+	var balances map[string]int
+	if page.Bookmark == "" {
+		balances, err = getBalances(stub, page.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = snapshot.Import(stub, KEY_TRANSFER, page)
+	if err != nil {
+		log.With("error", err.Error()).Warning("import failed")
+		return shim.Error(err.Error())
+	}
+
+	if balances != nil {
+		for _, row := range page.Rows[:len(page.Rows)-1] {
+			_, keyParts, err := stub.SplitCompositeKey(row.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			amount, _ := strconv.Atoi(keyParts[3])
+			if len(keyParts[1]) != 0 {
+				balances[keyParts[1]] -= amount
+			}
+			if len(keyParts[2]) != 0 {
+				balances[keyParts[2]] += amount
+			}
+		}
+		for owner, amount := range balances {
+			if amount != 0 {
+				err = putPortfolio(stub, owner, page.Name)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+		}
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * readPortfolio - read every marble an owner holds
+ * to give in the args array are as follows:
+ *	- args[0] -> owner; owner id (required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readPortfolio query
+ *
+ * @return A response structure with the marbles of the owner and their non-zero amounts
+ */
+func (t *HighThroughputChaincode) readPortfolio(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_READ_PORTFOLIO)
+	log.Debug("start read portfolio")
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey(KEY_PORTFOLIO, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer indexIterator.Close()
+
+	result := &portfolioResponse{owner, []portfolioEntry{}}
+	for indexIterator.HasNext() {
+		responseRange, err := indexIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		marbleName := keyParts[1]
+		amount, err := getAmount(stub, marbleName, owner)
+		if err != nil {
+			return shim.Error("Cannot get owner Amount, err: " + err.Error())
+		}
+		if amount != 0 {
+			result.Marbles = append(result.Marbles, portfolioEntry{marbleName, amount})
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
+
+// putPortfolio adds a marble to the portfolio index of owner. The write is
+// blind, so concurrent transfers to the same owner do not conflict.
+func putPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func delPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+/**
+ * listMarbles - list the marble records in the catalogue
+ * to give in the args array are as follows:
+ *	- args[0] -> docType; only list records of this docType (not required)
+ *	- args[1] -> pageSize; number of records per page, default 100 (not required)
+ *	- args[2] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listMarbles query
+ *
+ * @return A response structure with a page of marble records in name order
+ */
+func (t *HighThroughputChaincode) listMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_LIST_MARBLES)
+	log.Debug("start list marbles")
+
+	page, err := catalogue.List(stub, args.String("docType"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+/**
+ * migrateMarbles - move the marble records stored under their bare name into the catalogue, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> pageSize; number of keys to scan, default 100 (not required)
+ *	- args[1] -> bookmark; bookmark of the previous migration (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the migrateMarbles invocation
+ *
+ * @return A response structure with the moved marbles and the bookmark to continue from, empty when done
+ */
+func (t *HighThroughputChaincode) migrateMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_MIGRATE_MARBLES)
+
+	err := identity.RequireAdmin(stub, FUNCTION_MIGRATE_MARBLES)
+	if err != nil {
+		log.With("error", err.Error()).Warning("migration refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start migrate marbles")
+
+	migration, err := catalogue.Migrate(stub, args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	migrationBytes, err := json.Marshal(migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(migrationBytes)
+}
+
+/**
+ * listHolders - list the balance of every owner of a marble
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (required)
+ *	- args[1] -> nonZero; "true" to leave out owners with a balance of 0 (not required)
+ *	- args[2] -> order; "owner" (default) or "amount" for the largest balances first (not required)
+ *	- args[3] -> pageSize; number of holders per page, default 100 (not required)
+ *	- args[4] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listHolders query
+ *
+ * @return A response structure with a page of holders, the holder count and the sum of their balances
+ */
+func (t *HighThroughputChaincode) listHolders(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_LIST_HOLDERS).With("marble", name)
+	log.Debug("start list holders")
+
+	// check marble is existed
+	marbleAsBytes, err := catalogue.Get(stub, name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	balances, err := getBalances(stub, name)
+	if err != nil {
+		return shim.Error("Cannot get balances, err: " + err.Error())
+	}
+	page, err := holders.List(name, balances, args.Bool("nonZero"), args.String("order"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
 func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int, error) {
 	amountResult := 0
 
@@ -371,7 +737,8 @@ func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int,
 					return 0, err
 				}
 				amountResult -= amountInt
-			} else if receiver == owner {
+			}
+			if receiver == owner {
 				amount := keyParts[3]
 				amountInt, err := strconv.Atoi(amount)
 				if err != nil {
@@ -383,3 +750,38 @@ func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int,
 	}
 	return amountResult, nil
 }
+
+// getBalances sums the delta rows of a marble per owner, like getAmount
+// does for a single owner.
+func getBalances(stub shim.ChaincodeStubInterface, marbleName string) (map[string]int, error) {
+	balances := make(map[string]int)
+
+	amountIterator, err := stub.GetStateByPartialCompositeKey(KEY_TRANSFER, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	defer amountIterator.Close()
+
+	for amountIterator.HasNext() {
+		responseRange, err := amountIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		sender, receiver := keyParts[1], keyParts[2]
+		amount, err := strconv.Atoi(keyParts[3])
+		if err != nil {
+			return nil, err
+		}
+		if len(sender) != 0 {
+			balances[sender] -= amount
+		}
+		if len(receiver) != 0 {
+			balances[receiver] += amount
+		}
+	}
+	return balances, nil
+}
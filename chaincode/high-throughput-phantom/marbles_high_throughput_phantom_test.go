@@ -3,6 +3,9 @@ package highthroughputphantom
 import (
 	"encoding/json"
 	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/snapshot"
 	"marbles-meetup/util"
 	"strconv"
 	"testing"
@@ -31,13 +34,17 @@ func checkAmount(t *testing.T, stub *shim.MockStub, marbleName, owner string, ex
 func initMarble(t *testing.T) *shim.MockStub {
 	var scc = new(HighThroughputChaincode)
 	var stub = shim.NewMockStub("marbles", scc)
+	initMarbleOn(t, stub)
+
+	return stub
+}
+
+func initMarbleOn(t *testing.T, stub *shim.MockStub) {
 	stub.MockInit("1", [][]byte{[]byte("init")})
 	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
 		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
 		[]byte(strconv.Itoa(totalAmount)), []byte(alice)}
 	util.CheckInvoke(t, stub, arguments, txInit)
-
-	return stub
 }
 
 func Test_MARBLES_initMarble_success(t *testing.T) {
@@ -48,7 +55,8 @@ func Test_MARBLES_initMarble_success(t *testing.T) {
 
 	// check marble state
 	sampleMarbleBytes, _ := json.Marshal(sampleMarble)
-	util.CheckState(t, stub, sampleMarble.Name, string(sampleMarbleBytes))
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	util.CheckState(t, stub, marbleKey, string(sampleMarbleBytes))
 
 	// check state
 	key, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", alice, strconv.Itoa(totalAmount), txInit})
@@ -80,6 +88,17 @@ func Test_MARBLES_transferMarbles_success(t *testing.T) {
 	checkAmount(t, stub, sampleMarble.Name, bob, transferAmount1)
 }
 
+func Test_MARBLES_self_transferMarbles_success(t *testing.T) {
+	// invoke initMarbles
+	stub := initMarble(t)
+
+	// a transfer to the sender itself leaves the balance unchanged
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(alice), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub, arguments, txTransfer1)
+	checkAmount(t, stub, sampleMarble.Name, alice, totalAmount)
+}
+
 func Test_MARBLES_readMarbles_success(t *testing.T) {
 	fmt.Println("[TEST] readMarbles")
 
@@ -214,4 +233,109 @@ func Test_MARBLES_pruneMarbles_success(t *testing.T) {
 	checkAmount(t, stub, sampleMarble.Name, alice, aliceAmount)
 	checkAmount(t, stub, sampleMarble.Name, bob,bobAmount)
 	checkAmount(t, stub, sampleMarble.Name, carol, carolAmount)
-}
\ No newline at end of file
+}
+func Test_MARBLES_transferMarbles_access(t *testing.T) {
+	// invoke initMarbles
+	stub := util.NewRecordingStub("marbles", new(HighThroughputChaincode))
+	initMarbleOn(t, stub.MockStub)
+
+	// invoke transfer
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(bob), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub.MockStub, arguments, txTransfer1)
+
+	// check only the marble record is read
+	access := stub.LastAccess()
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	access.AssertReads(t, marbleKey)
+	access.AssertNoRangeReads(t)
+
+	// check the only writes are a new delta row and the blind portfolio index of the receiver
+	key, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, bob, strconv.Itoa(transferAmount1), txTransfer1})
+	indexKey, _ := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{bob, sampleMarble.Name})
+	access.AssertWritesOnly(t, key, indexKey)
+	access.AssertNoReadOf(t, indexKey)
+}
+
+func Test_MARBLES_initMarble_fail(t *testing.T) {
+	var scc = new(HighThroughputChaincode)
+	var stub = shim.NewMockStub("marbles", scc)
+	stub.MockInit("1", [][]byte{[]byte("init")})
+
+	// missing owner
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte(strconv.Itoa(totalAmount))}
+	util.CheckInvokeFails(t, stub, arguments, "Incorrect number of arguments. Expecting 5", "1")
+
+	// negative amount
+	arguments = [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte("-1"), []byte(alice)}
+	util.CheckInvokeFails(t, stub, arguments, "amount cannot be less than 0", "1")
+	marbleKey, _ := catalogue.Key(stub, sampleMarble.Name)
+	util.CheckStateNotExisted(t, stub, marbleKey)
+}
+
+var marblesTarget = util.Target{
+	Name:      "highThroughputPhantom",
+	Chaincode: func() shim.Chaincode { return new(HighThroughputChaincode) },
+	Functions: util.Functions{Init: FUNCTION_INIT, Transfer: FUNCTION_TRANSFER,
+		Read: FUNCTION_READ, Prune: FUNCTION_PRUNE},
+	SenderCheck: false,
+}
+
+func Test_MARBLES_workload_conservation(t *testing.T) {
+	util.CheckWorkloads(t, marblesTarget, util.DefaultWorkload, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func Benchmark_MARBLES_initMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_INIT)
+}
+
+func Benchmark_MARBLES_transferMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_TRANSFER)
+}
+
+func Benchmark_MARBLES_readMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_READ)
+}
+
+func Benchmark_MARBLES_pruneMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_PRUNE)
+}
+
+func Test_MARBLES_exportMarbleState_success(t *testing.T) {
+	stub := initMarble(t)
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(bob), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub, arguments, txTransfer1)
+	util.CheckInvoke(t, stub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+
+	// the checkpoint rows of alice and bob and the marble record
+	res := stub.MockInvoke("export", [][]byte{[]byte(FUNCTION_EXPORT), []byte(sampleMarble.Name)})
+	page := &snapshot.Snapshot{}
+	json.Unmarshal(res.Payload, page)
+	if res.Status != shim.OK || len(page.Rows) != 3 || page.Bookmark != "" {
+		t.Fatalf("export returned %d %s", res.Status, res.Payload)
+	}
+
+	target := util.NewIdentityStub("copy", new(HighThroughputChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	target.MockInit("1", [][]byte{[]byte("init")})
+	util.CheckInvoke(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), res.Payload}, "import")
+	checkAmount(t, target.MockStub, sampleMarble.Name, alice, totalAmount-transferAmount1)
+	checkAmount(t, target.MockStub, sampleMarble.Name, bob, transferAmount1)
+}
+
+func Test_MARBLES_listHolders_success(t *testing.T) {
+	stub := initMarble(t)
+
+	// without the sender check balances can become negative
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(bob), []byte(carol), []byte(strconv.Itoa(transferAmount2))}
+	util.CheckInvoke(t, stub, arguments, txTransfer2)
+
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name), []byte("false"), []byte("amount")}
+	util.CheckQuery(t, stub, arguments, `{"marble":"RedMarble","holders":[{"owner":"alice","amount":100000},{"owner":"carol","amount":20},
+		{"owner":"bob","amount":-20}],"count":3,"sum":100000,"bookmark":""}`, "1")
+}
@@ -0,0 +1,566 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/router"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	KEY_PENDING_TRANSFER = "PendingTransfer/id"
+	KEY_APPROVAL         = "Approval/id/approver"
+	KEY_APPROVAL_RULE    = "ApprovalRule/name"
+
+	FUNCTION_PROPOSE_TRANSFER      = "proposeTransfer"
+	FUNCTION_APPROVE_TRANSFER      = "approveTransfer"
+	FUNCTION_EXECUTE_TRANSFER      = "executeTransfer"
+	FUNCTION_CANCEL_TRANSFER       = "cancelTransfer"
+	FUNCTION_READ_PENDING_TRANSFER = "readPendingTransfer"
+	FUNCTION_SET_APPROVAL_RULE     = "setApprovalRule"
+
+	// seconds a pending transfer can be approved and executed for
+	DEFAULT_APPROVAL_TTL = 7 * 24 * 60 * 60
+	MAX_APPROVAL_TTL     = MAX_SWAP_TTL
+)
+
+// pendingTransfer is a transfer that is executed once Threshold of
+// Approvers approved it. Approvers and Proposer are identities as
+// identity.Name gives them, e.g. Org1MSP/alice. Its ID is the txid of the
+// proposal.
+type pendingTransfer struct {
+	ObjectType string   `json:"docType"`
+	ID         string   `json:"id"`
+	Proposer   string   `json:"proposer"`
+	Name       string   `json:"name"`
+	Sender     string   `json:"sender"`
+	Receiver   string   `json:"receiver"`
+	Amount     int      `json:"amount"`
+	Approvers  []string `json:"approvers"`
+	Threshold  int      `json:"threshold"`
+	Expires    string   `json:"expires"`
+}
+
+// approvalRule makes transfers of more than Amount of a marble go through
+// proposeTransfer, where MinApprovals of Approvers must approve them.
+type approvalRule struct {
+	ObjectType   string   `json:"docType"`
+	Name         string   `json:"name"`
+	Amount       int      `json:"amount"`
+	Approvers    []string `json:"approvers"`
+	MinApprovals int      `json:"minApprovals"`
+}
+
+type pendingTransferResponse struct {
+	Transfer  *pendingTransfer `json:"transfer"`
+	Approvals []string         `json:"approvals"`
+}
+
+var (
+	proposeTransferArgs = withMoveArgs(
+		router.JSON("approvers", true),
+		router.Int("threshold", true, router.Bound(1), nil),
+		router.Int("ttl", false, router.Bound(1), router.Bound(MAX_APPROVAL_TTL)),
+	)
+	pendingTransferArgs = []router.Arg{
+		router.String("id", true),
+	}
+	setApprovalRuleArgs = []router.Arg{
+		router.String("name", true),
+		router.Int("amount", true, router.Bound(0), nil),
+		router.JSON("approvers", true),
+		router.Int("minApprovals", false, router.Bound(1), nil),
+	}
+)
+
+// parseApprovers reads a JSON array of distinct identities.
+func parseApprovers(approversJSON string) ([]string, error) {
+	var approvers []string
+	err := json.Unmarshal([]byte(approversJSON), &approvers)
+	if err != nil {
+		return nil, fmt.Errorf("approvers must be a JSON array of identities")
+	}
+	for i, approver := range approvers {
+		if approver == "" {
+			return nil, fmt.Errorf("approvers cannot be empty")
+		}
+		for _, other := range approvers[:i] {
+			if approver == other {
+				return nil, fmt.Errorf("approvers cannot repeat %s", approver)
+			}
+		}
+	}
+	return approvers, nil
+}
+
+// getApprovalRule reads the approval rule of a marble, nil if it has none.
+func getApprovalRule(stub shim.ChaincodeStubInterface, marbleName string) (*approvalRule, error) {
+	ruleKey, err := stub.CreateCompositeKey(KEY_APPROVAL_RULE, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	ruleAsBytes, err := stub.GetState(ruleKey)
+	if err != nil || ruleAsBytes == nil {
+		return nil, err
+	}
+	rule := &approvalRule{}
+	err = json.Unmarshal(ruleAsBytes, rule)
+	return rule, err
+}
+
+// requires tells if a transfer of amount needs approval.
+func (r *approvalRule) requires(amount int) bool {
+	return r != nil && amount > r.Amount
+}
+
+func (r *approvalRule) approver(name string) bool {
+	for _, approver := range r.Approvers {
+		if approver == name {
+			return true
+		}
+	}
+	return false
+}
+
+func getPendingTransfer(stub shim.ChaincodeStubInterface, id string) (string, *pendingTransfer, error) {
+	pendingKey, err := stub.CreateCompositeKey(KEY_PENDING_TRANSFER, []string{id})
+	if err != nil {
+		return "", nil, err
+	}
+	pendingAsBytes, err := stub.GetState(pendingKey)
+	if err != nil || pendingAsBytes == nil {
+		return pendingKey, nil, err
+	}
+	record := &pendingTransfer{}
+	err = json.Unmarshal(pendingAsBytes, record)
+	return pendingKey, record, err
+}
+
+// getApprovals reads the approval rows of a pending transfer, each
+// approver writes a row of their own so approvals never conflict. It
+// returns the approvers that are still listed, and the keys of all rows.
+func getApprovals(stub shim.ChaincodeStubInterface, pending *pendingTransfer) ([]string, []string, error) {
+	approvalIterator, err := stub.GetStateByPartialCompositeKey(KEY_APPROVAL, []string{pending.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer approvalIterator.Close()
+
+	approvals := []string{}
+	var keys []string
+	for approvalIterator.HasNext() {
+		responseRange, err := approvalIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, responseRange.Key)
+		if pending.approver(keyParts[1]) {
+			approvals = append(approvals, keyParts[1])
+		}
+	}
+	return approvals, keys, nil
+}
+
+func (p *pendingTransfer) approver(name string) bool {
+	for _, approver := range p.Approvers {
+		if approver == name {
+			return true
+		}
+	}
+	return false
+}
+
+// delete removes a pending transfer and its approvals.
+func (p *pendingTransfer) delete(stub shim.ChaincodeStubInterface, pendingKey string, approvalKeys []string) error {
+	for _, key := range append(approvalKeys, pendingKey) {
+		err := stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * proposeTransfer - propose a transfer that needs the approval of several parties
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> sender;
+ *	- args[2] -> receiver;
+ *	- args[3] -> amount; amount to transfer
+ *	- args[4] -> approvers; JSON array of identities, e.g. ["Org1MSP/alice","Org2MSP/bob"]
+ *	- args[5] -> threshold; number of approvals needed
+ *	- args[6] -> ttl; seconds the transfer can be approved and executed for, default a week (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the proposeTransfer invocation
+ *
+ * @return A response structure with the pending transfer, its id is needed to approve, execute or cancel it
+ */
+func (t *HighThroughputChaincode) proposeTransfer(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	pending := &pendingTransfer{
+		ObjectType: "pendingTransfer",
+		ID:         stub.GetTxID(),
+		Name:       args.String("name"),
+		Sender:     args.String("sender"),
+		Receiver:   args.String("receiver"),
+		Amount:     args.Int("amount"),
+		Threshold:  args.Int("threshold"),
+	}
+
+	log := logger.ForTx(stub, FUNCTION_PROPOSE_TRANSFER).With("marble", pending.Name).With("transfer", pending.ID)
+	log.Debug("start propose transfer")
+
+	proposer, err := identity.Name(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pending.Proposer = proposer
+
+	pending.Approvers, err = parseApprovers(args.String("approvers"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pending.Threshold > len(pending.Approvers) {
+		return shim.Error("threshold cannot be greater than the number of approvers")
+	}
+
+	marbleAsBytes, err := catalogue.Get(stub, pending.Name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	// the approval rule of the marble decides who approves, executeTransfer
+	// checks it again
+	rule, err := getApprovalRule(stub, pending.Name)
+	if err != nil {
+		return shim.Error("Failed to get approval rule:" + err.Error())
+	}
+	if rule.requires(pending.Amount) {
+		for _, approver := range pending.Approvers {
+			if !rule.approver(approver) {
+				return shim.Error("approvers must be approvers of the approval rule, not " + approver)
+			}
+		}
+		if pending.Threshold < rule.MinApprovals {
+			return shim.Error(fmt.Sprintf("threshold cannot be less than the %d approvals of the approval rule", rule.MinApprovals))
+		}
+	}
+
+	// fail early, executeTransfer checks the amount again
+	senderAmount, err := getAmount(stub, pending.Name, pending.Sender)
+	if err != nil {
+		return shim.Error("Cannot get sender Amount, err: " + err.Error())
+	}
+	if senderAmount < pending.Amount {
+		log.WithSensitive("senderAmount", senderAmount).WithSensitive("amount", pending.Amount).Warning("sender cannot transfer amount")
+		return shim.Error("Cannot transfer amount:")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ttl := DEFAULT_APPROVAL_TTL
+	if args.Has("ttl") {
+		ttl = args.Int("ttl")
+	}
+	pending.Expires = now.Add(time.Duration(ttl) * time.Second).UTC().Format(time.RFC3339Nano)
+
+	pendingBytes, err := json.Marshal(pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pendingKey, err := stub.CreateCompositeKey(KEY_PENDING_TRANSFER, []string{pending.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(pendingKey, pendingBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pendingBytes)
+}
+
+/**
+ * approveTransfer - approve a pending transfer as the creator of the transaction
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the pending transfer
+ *
+ * Approving again changes nothing.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the approveTransfer invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) approveTransfer(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_APPROVE_TRANSFER).With("transfer", id)
+	log.Debug("start approve transfer")
+
+	approver, err := identity.Name(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, pending, err := getPendingTransfer(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get pending transfer:" + err.Error())
+	} else if pending == nil {
+		return shim.Error("Pending transfer does not exist: " + id)
+	}
+	if !pending.approver(approver) {
+		log.With("approver", approver).Warning("approval refused")
+		return shim.Error("Only the approvers may approve transfer " + id)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiredAt(pending.Expires, now) {
+		return shim.Error("Pending transfer has expired: " + id)
+	}
+
+	approvalKey, err := stub.CreateCompositeKey(KEY_APPROVAL, []string{id, approver})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(approvalKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * executeTransfer - transfer the marbles of a pending transfer once enough approvers approved it
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the pending transfer
+ *
+ * The transfer is checked like transferMarbles checks it, including fees
+ * and daily limits. If the marble has an approval rule, enough of its
+ * approvers must have approved too.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the executeTransfer invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) executeTransfer(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_EXECUTE_TRANSFER).With("transfer", id)
+	log.Debug("start execute transfer")
+
+	pendingKey, pending, err := getPendingTransfer(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get pending transfer:" + err.Error())
+	} else if pending == nil {
+		return shim.Error("Pending transfer does not exist: " + id)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if expiredAt(pending.Expires, now) {
+		log.Info("pending transfer has expired")
+		return shim.Error("Pending transfer has expired: " + id)
+	}
+	approvals, approvalKeys, err := getApprovals(stub, pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(approvals) < pending.Threshold {
+		return shim.Error(fmt.Sprintf("Transfer %s has %d of %d approvals", id, len(approvals), pending.Threshold))
+	}
+
+	// the rule may have changed since the proposal
+	rule, err := getApprovalRule(stub, pending.Name)
+	if err != nil {
+		return shim.Error("Failed to get approval rule:" + err.Error())
+	}
+	if rule.requires(pending.Amount) {
+		ruleApprovals := 0
+		for _, approval := range approvals {
+			if rule.approver(approval) {
+				ruleApprovals++
+			}
+		}
+		if ruleApprovals < rule.MinApprovals {
+			return shim.Error(fmt.Sprintf("Transfer %s has %d of the %d approvals of the approval rule", id, ruleApprovals, rule.MinApprovals))
+		}
+	}
+
+	response := transfer(stub, log.With("marble", pending.Name), pending.Name, pending.Sender, pending.Receiver, pending.Amount, true)
+	if response.Status != shim.OK {
+		return response
+	}
+	err = pending.delete(stub, pendingKey, approvalKeys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return response
+}
+
+/**
+ * cancelTransfer - withdraw or reject a pending transfer
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the pending transfer
+ *
+ * The proposer and the approvers may cancel, anyone once it expired.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the cancelTransfer invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) cancelTransfer(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_CANCEL_TRANSFER).With("transfer", id)
+	log.Debug("start cancel transfer")
+
+	caller, err := identity.Name(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pendingKey, pending, err := getPendingTransfer(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get pending transfer:" + err.Error())
+	} else if pending == nil {
+		return shim.Error("Pending transfer does not exist: " + id)
+	}
+	if caller != pending.Proposer && !pending.approver(caller) {
+		now, err := txTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !expiredAt(pending.Expires, now) {
+			return shim.Error("Only the proposer or the approvers may cancel transfer " + id)
+		}
+	}
+
+	_, approvalKeys, err := getApprovals(stub, pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = pending.delete(stub, pendingKey, approvalKeys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * readPendingTransfer - read a pending transfer and who approved it
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the pending transfer
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readPendingTransfer query
+ *
+ * @return A response structure with the pending transfer and its approvals
+ */
+func (t *HighThroughputChaincode) readPendingTransfer(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_READ_PENDING_TRANSFER).With("transfer", id)
+	log.Debug("start read pending transfer")
+
+	_, pending, err := getPendingTransfer(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get pending transfer:" + err.Error())
+	} else if pending == nil {
+		return shim.Error("Pending transfer does not exist: " + id)
+	}
+	approvals, _, err := getApprovals(stub, pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultBytes, err := json.Marshal(&pendingTransferResponse{pending, approvals})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
+
+/**
+ * setApprovalRule - make large transfers of a marble need approval, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> amount; transfers of more than amount need approval
+ *	- args[2] -> approvers; JSON array of identities, [] removes the rule
+ *	- args[3] -> minApprovals; number of approvers that must approve (not required if approvers is empty)
+ *
+ * transferMarbles, transferWithChaincode and acceptSwap refuse transfers
+ * above the amount, they go through proposeTransfer with approvers of the
+ * rule instead.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the setApprovalRule invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) setApprovalRule(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	rule := &approvalRule{ObjectType: "approvalRule", Name: args.String("name"), Amount: args.Int("amount"), MinApprovals: args.Int("minApprovals")}
+
+	log := logger.ForTx(stub, FUNCTION_SET_APPROVAL_RULE).With("marble", rule.Name)
+
+	err := identity.RequireAdmin(stub, FUNCTION_SET_APPROVAL_RULE)
+	if err != nil {
+		log.With("error", err.Error()).Warning("approval rule refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start set approval rule")
+
+	rule.Approvers, err = parseApprovers(args.String("approvers"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(rule.Approvers) > 0 && rule.MinApprovals == 0 {
+		return shim.Error("minApprovals is required")
+	}
+	if rule.MinApprovals > len(rule.Approvers) {
+		return shim.Error("minApprovals cannot be greater than the number of approvers")
+	}
+
+	marbleAsBytes, err := catalogue.Get(stub, rule.Name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	ruleKey, err := stub.CreateCompositeKey(KEY_APPROVAL_RULE, []string{rule.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(rule.Approvers) == 0 {
+		err = stub.DelState(ruleKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	ruleBytes, err := json.Marshal(rule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ruleKey, ruleBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
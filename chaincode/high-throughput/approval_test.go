@@ -0,0 +1,222 @@
+package highthroughput
+
+import (
+	"marbles-meetup/identity"
+	"marbles-meetup/util"
+	"testing"
+	"time"
+)
+
+var approvalTime = time.Date(2019, 10, 1, 9, 0, 0, 0, time.UTC)
+
+// approvalStub holds the sample marble and a transfer of 5000 from alice to
+// bob proposed by Org1MSP/ops, which needs two of three approvers.
+func approvalStub(t *testing.T, ttl ...string) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).As("Org1MSP", "ops", nil).At(approvalTime)
+	initMarbleOn(t, stub.MockStub)
+	arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob), []byte("5000"),
+		[]byte(`["Org1MSP/alice","Org2MSP/risk","Org3MSP/audit"]`), []byte("2")}
+	for _, arg := range ttl {
+		arguments = append(arguments, []byte(arg))
+	}
+	util.CheckInvoke(t, stub.MockStub, arguments, "pending")
+	return stub
+}
+
+func pendingArguments(function string) [][]byte {
+	return [][]byte{[]byte(function), []byte("pending")}
+}
+
+func Test_MARBLES_executeTransfer_success(t *testing.T) {
+	stub := approvalStub(t)
+
+	stub.As("Org2MSP", "risk", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_risk")
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_risk_again")
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "Transfer pending has 1 of 2 approvals", "early")
+
+	stub.As("Org1MSP", "alice", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_alice")
+	util.CheckQuery(t, stub.MockStub, pendingArguments(FUNCTION_READ_PENDING_TRANSFER),
+		`{"transfer":{"docType":"pendingTransfer","id":"pending","proposer":"Org1MSP/ops","name":"RedMarble","sender":"alice",
+		"receiver":"bob","amount":5000,"approvers":["Org1MSP/alice","Org2MSP/risk","Org3MSP/audit"],"threshold":2,
+		"expires":"2019-10-08T09:00:00Z"},"approvals":["Org1MSP/alice","Org2MSP/risk"]}`, "read")
+
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "execute")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount-5000)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 5000)
+
+	// a transfer is executed once, and its approvals are gone with it
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "Pending transfer does not exist: pending", "again")
+	approvalKey, _ := stub.CreateCompositeKey(KEY_APPROVAL, []string{"pending", "Org1MSP/alice"})
+	util.CheckStateNotExisted(t, stub.MockStub, approvalKey)
+}
+
+func Test_MARBLES_executeTransfer_fail(t *testing.T) {
+	stub := approvalStub(t, "60")
+
+	// approvers are the identities of an MSP
+	stub.As("Org1MSP", "risk", nil)
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "Only the approvers may approve transfer pending", "risk")
+
+	stub.As("Org1MSP", "alice", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_alice")
+	stub.As("Org3MSP", "audit", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_audit")
+
+	// alice spends most of her marbles first, the balance is checked again
+	arguments := transferArguments(alice, carol, totalAmount-1000)
+	util.CheckInvoke(t, stub.MockStub, arguments, "spend")
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "Cannot transfer amount:", "short")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 0)
+
+	util.CheckInvoke(t, stub.MockStub, transferArguments(carol, alice, totalAmount-1000), "refund")
+	stub.At(approvalTime.Add(time.Minute))
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "Pending transfer has expired: pending", "late")
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "Pending transfer has expired: pending", "late")
+}
+
+func Test_MARBLES_proposeTransfer_fail(t *testing.T) {
+	stub := approvalStub(t)
+
+	for expected, args := range map[string][]string{
+		"approvers must be a JSON array of identities":             {`{"alice":true}`, "1"},
+		"approvers cannot repeat Org1MSP/alice":                    {`["Org1MSP/alice","Org1MSP/alice"]`, "1"},
+		"approvers cannot be empty":                                {`["Org1MSP/alice",""]`, "1"},
+		"threshold cannot be greater than the number of approvers": {`["Org1MSP/alice"]`, "2"},
+		"threshold cannot be less than 1":                          {`["Org1MSP/alice"]`, "0"},
+	} {
+		arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob), []byte("1"),
+			[]byte(args[0]), []byte(args[1])}
+		util.CheckInvokeFails(t, stub.MockStub, arguments, expected, "propose")
+	}
+
+	arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob),
+		[]byte("100001"), []byte(`["Org1MSP/alice"]`), []byte("1")}
+	util.CheckInvokeFails(t, stub.MockStub, arguments, "Cannot transfer amount:", "propose")
+
+	// approvers are identified by the certificate of the creator
+	stub.Creator = nil
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "Cannot identify the creator", "anonymous")
+}
+
+func Test_MARBLES_cancelTransfer_success(t *testing.T) {
+	stub := approvalStub(t, "60")
+	stub.As("Org2MSP", "risk", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_risk")
+
+	// others only remove expired transfers
+	stub.As("Org1MSP", "mallory", nil)
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_CANCEL_TRANSFER),
+		"Only the proposer or the approvers may cancel transfer pending", "early")
+
+	// an approver rejects the transfer
+	stub.As("Org3MSP", "audit", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_CANCEL_TRANSFER), "reject")
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_READ_PENDING_TRANSFER), "Pending transfer does not exist: pending", "read")
+	approvalKey, _ := stub.CreateCompositeKey(KEY_APPROVAL, []string{"pending", "Org2MSP/risk"})
+	util.CheckStateNotExisted(t, stub.MockStub, approvalKey)
+
+	stub = approvalStub(t, "60")
+	stub.As("Org1MSP", "mallory", nil).At(approvalTime.Add(time.Hour))
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_CANCEL_TRANSFER), "cleanup")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount)
+}
+
+// ruleStub holds the sample marble, whose transfers of more than 1000 need
+// two of three approvers, and 500 BlueMarble of bob.
+func ruleStub(t *testing.T) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).
+		As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"}).At(approvalTime)
+	initMarbleOn(t, stub.MockStub)
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("500"), []byte(bob)}
+	util.CheckInvoke(t, stub.MockStub, arguments, "init_blue")
+	arguments = [][]byte{[]byte(FUNCTION_SET_APPROVAL_RULE), []byte(sampleMarble.Name), []byte("1000"),
+		[]byte(`["Org1MSP/alice","Org2MSP/risk","Org3MSP/audit"]`), []byte("2")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "rule")
+	return stub
+}
+
+func Test_MARBLES_approvalRule_success(t *testing.T) {
+	stub := ruleStub(t)
+
+	// up to the amount transfers go directly
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 1000), txTransfer1)
+
+	arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob), []byte("5000"),
+		[]byte(`["Org2MSP/risk","Org3MSP/audit"]`), []byte("2")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "pending")
+	stub.As("Org2MSP", "risk", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_risk")
+	stub.As("Org3MSP", "audit", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_audit")
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER), "execute")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 6000)
+
+	// removing the rule allows direct transfers again
+	stub.As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_APPROVAL_RULE), []byte(sampleMarble.Name), []byte("0"), []byte(`[]`)}, "remove")
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 5000), txTransfer2)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 11000)
+}
+
+func Test_MARBLES_approvalRule_fail(t *testing.T) {
+	stub := ruleStub(t)
+
+	// every direct transfer above the amount is refused
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, bob, 1001),
+		"Transfers of more than 1000 of RedMarble need approval through proposeTransfer", txTransfer1)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_TRANSFER_WITH_CHAINCODE), []byte(sampleMarble.Name), []byte(alice), []byte(bob),
+		[]byte("1001"), []byte("example_cc"), []byte("a"), []byte("b"), []byte("10")},
+		"Transfers of more than 1000 of RedMarble need approval through proposeTransfer", txTransfer2)
+	proposeSwap(t, stub, "offer", alice, sampleMarble.Name, "1001", bob, "BlueMarble", "200")
+	creator := stub.Creator
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)},
+		"Transfers of more than 1000 of RedMarble need approval through proposeTransfer", "accept")
+	stub.Creator = creator
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 0)
+	checkAmount(t, stub.MockStub, "BlueMarble", alice, 0)
+
+	// the proposer cannot pick other approvers or fewer approvals
+	for expected, args := range map[string][]string{
+		"approvers must be approvers of the approval rule, not Org1MSP/ops":  {`["Org1MSP/alice","Org1MSP/ops"]`, "1"},
+		"threshold cannot be less than the 2 approvals of the approval rule": {`["Org1MSP/alice","Org2MSP/risk"]`, "1"},
+	} {
+		arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob), []byte("5000"),
+			[]byte(args[0]), []byte(args[1])}
+		util.CheckInvokeFails(t, stub.MockStub, arguments, expected, "propose")
+	}
+
+	// a rule set after the proposal applies when it is executed
+	arguments := [][]byte{[]byte(FUNCTION_PROPOSE_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(bob), []byte("5000"),
+		[]byte(`["Org1MSP/alice","Org2MSP/risk"]`), []byte("2")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "pending")
+	arguments = [][]byte{[]byte(FUNCTION_SET_APPROVAL_RULE), []byte(sampleMarble.Name), []byte("1000"),
+		[]byte(`["Org2MSP/risk","Org3MSP/audit"]`), []byte("2")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "rule_again")
+	stub.As("Org1MSP", "alice", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_alice")
+	stub.As("Org2MSP", "risk", nil)
+	util.CheckInvoke(t, stub.MockStub, pendingArguments(FUNCTION_APPROVE_TRANSFER), "approve_risk")
+	util.CheckInvokeFails(t, stub.MockStub, pendingArguments(FUNCTION_EXECUTE_TRANSFER),
+		"Transfer pending has 1 of the 2 approvals of the approval rule", "execute")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 0)
+
+	stub.As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	for expected, args := range map[string][]string{
+		"minApprovals is required":                                    {`["Org1MSP/alice"]`},
+		"minApprovals cannot be greater than the number of approvers": {`["Org1MSP/alice"]`, "2"},
+		"approvers cannot repeat Org1MSP/alice":                       {`["Org1MSP/alice","Org1MSP/alice"]`, "1"},
+	} {
+		arguments := [][]byte{[]byte(FUNCTION_SET_APPROVAL_RULE), []byte(sampleMarble.Name), []byte("1000")}
+		for _, arg := range args {
+			arguments = append(arguments, []byte(arg))
+		}
+		util.CheckInvokeFails(t, stub.MockStub, arguments, expected, "rule")
+	}
+	// only admins set rules
+	stub.As("Org2MSP", "risk", nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_APPROVAL_RULE), []byte(sampleMarble.Name), []byte("0"), []byte(`[]`)},
+		"Only admins may call setApprovalRule", "remove")
+}
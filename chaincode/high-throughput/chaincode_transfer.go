@@ -0,0 +1,85 @@
+package highthroughput
+
+import (
+	"marbles-meetup/catalogue"
+	"marbles-meetup/router"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	FUNCTION_TRANSFER_WITH_CHAINCODE = "transferWithChaincode"
+
+	// the function of the other chaincode, as example_cc names it
+	FUNCTION_MOVE = "move"
+)
+
+var transferWithChaincodeArgs = withMoveArgs(
+	router.String("chaincode", true),
+	router.String("from", true),
+	router.String("to", true),
+	router.Int("value", true, router.Bound(0), nil),
+)
+
+/**
+ * transferWithChaincode - transfer a marble and move an asset of another chaincode in the same transaction
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> sender;
+ *	- args[2] -> receiver;
+ *	- args[3] -> amount; amount to transfer
+ *	- args[4] -> chaincode; name of the other chaincode, on the same channel
+ *	- args[5] -> from; entity the other chaincode moves from
+ *	- args[6] -> to; entity the other chaincode moves to
+ *	- args[7] -> value; value the other chaincode moves
+ *
+ * The other chaincode is called with ["move", from, to, value], like
+ * example_cc. Its writes are part of this transaction, so if either side
+ * fails the transaction fails and neither is committed. Chaincodes on other
+ * channels can only be queried, so they are not supported.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the transferWithChaincode invocation
+ *
+ * @return A response structure with the payload of the other chaincode
+ */
+func (t *HighThroughputChaincode) transferWithChaincode(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+	sender := args.String("sender")
+	receiver := args.String("receiver")
+	amount := args.Int("amount")
+	chaincode := args.String("chaincode")
+
+	log := logger.ForTx(stub, FUNCTION_TRANSFER_WITH_CHAINCODE).With("marble", marbleName).With("chaincode", chaincode)
+	log.Debug("start transfer with chaincode")
+
+	// check marble is existed
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	// check sender can transfer amount and its fee
+	m, err := checkMove(stub, log, marbleName, sender, receiver, amount, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the other side, before any write of this side
+	moveArgs := [][]byte{[]byte(FUNCTION_MOVE), []byte(args.String("from")), []byte(args.String("to")), []byte(strconv.Itoa(args.Int("value")))}
+	response := stub.InvokeChaincode(chaincode, moveArgs, "")
+	if response.Status != shim.OK {
+		log.With("error", response.Message).Warning("other chaincode failed")
+		return shim.Error("Chaincode " + chaincode + " failed to move: " + response.Message)
+	}
+
+	err = putMoves(stub, m)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(response.Payload)
+}
@@ -0,0 +1,88 @@
+package highthroughput
+
+import (
+	"marbles-meetup/util"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// exampleChaincode moves values between entities like the move function of
+// example_cc in server/artifacts, which is a main package.
+type exampleChaincode struct {
+}
+
+func (t *exampleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	for i := 0; i+1 < len(args); i += 2 {
+		stub.PutState(args[i], []byte(args[i+1]))
+	}
+	return shim.Success(nil)
+}
+
+func (t *exampleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function != FUNCTION_MOVE || len(args) != 3 {
+		return shim.Error("Unknown action")
+	}
+	values := make([]int, 2)
+	for i, entity := range args[:2] {
+		valueBytes, _ := stub.GetState(entity)
+		if valueBytes == nil {
+			return shim.Error("Entity not found")
+		}
+		values[i], _ = strconv.Atoi(string(valueBytes))
+	}
+	x, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Invalid transaction amount, expecting a integer value")
+	}
+	stub.PutState(args[0], []byte(strconv.Itoa(values[0]-x)))
+	stub.PutState(args[1], []byte(strconv.Itoa(values[1]+x)))
+	return shim.Success(nil)
+}
+
+// linkedStubs registers example_cc as a peer of the marbles chaincode.
+func linkedStubs(t *testing.T) (*shim.MockStub, *shim.MockStub) {
+	example := shim.NewMockStub("example_cc", new(exampleChaincode))
+	example.MockInit("1", [][]byte{[]byte("init"), []byte("a"), []byte("100"), []byte("b"), []byte("200")})
+	stub := initMarble(t)
+	stub.MockPeerChaincode("example_cc", example)
+	return stub, example
+}
+
+func transferWithChaincodeArguments(amount int, from string) [][]byte {
+	return [][]byte{[]byte(FUNCTION_TRANSFER_WITH_CHAINCODE), []byte(sampleMarble.Name), []byte(alice), []byte(bob),
+		[]byte(strconv.Itoa(amount)), []byte("example_cc"), []byte(from), []byte("b"), []byte("10")}
+}
+
+func Test_MARBLES_transferWithChaincode_success(t *testing.T) {
+	stub, example := linkedStubs(t)
+
+	util.CheckInvoke(t, stub, transferWithChaincodeArguments(transferAmount1, "a"), txTransfer1)
+	checkAmount(t, stub, sampleMarble.Name, alice, totalAmount-transferAmount1)
+	checkAmount(t, stub, sampleMarble.Name, bob, transferAmount1)
+	util.CheckState(t, example, "a", "90")
+	util.CheckState(t, example, "b", "210")
+
+	// the amount is checked like transferMarbles checks it
+	util.CheckInvoke(t, stub, transferWithChaincodeArguments(0, "a"), txTransfer2)
+	checkAmount(t, stub, sampleMarble.Name, bob, transferAmount1)
+	util.CheckState(t, example, "a", "80")
+	util.CheckInvokeFails(t, stub, transferWithChaincodeArguments(-1, "a"), "amount cannot be less than 0", txTransfer3)
+}
+
+func Test_MARBLES_transferWithChaincode_fail(t *testing.T) {
+	stub, example := linkedStubs(t)
+
+	// the other chaincode fails, no marble moves
+	util.CheckInvokeFails(t, stub, transferWithChaincodeArguments(transferAmount1, "c"), "Chaincode example_cc failed to move: Entity not found", txTransfer1)
+	checkAmount(t, stub, sampleMarble.Name, bob, 0)
+
+	// this chaincode fails, the other is not called
+	util.CheckInvokeFails(t, stub, transferWithChaincodeArguments(totalAmount+1, "a"), "Cannot transfer amount:", txTransfer2)
+	util.CheckState(t, example, "a", "100")
+	util.CheckState(t, example, "b", "200")
+}
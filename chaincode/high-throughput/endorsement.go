@@ -0,0 +1,160 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/router"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	FUNCTION_SET_ENDORSEMENT = "setMarbleEndorsement"
+
+	// the network in server/artifacts has no node OUs, so its peers
+	// endorse as members of their org
+	ENDORSER_ROLE = statebased.RoleTypeMember
+)
+
+var setMarbleEndorsementArgs = []router.Arg{
+	router.String("name", true),
+	router.JSON("endorsers", true),
+}
+
+// endorsementPolicy is the key-level policy that every org of endorsers
+// endorses, nil for none. endorsers is a JSON array of MSP IDs.
+func endorsementPolicy(endorsers string) ([]byte, error) {
+	var orgs []string
+	err := json.Unmarshal([]byte(endorsers), &orgs)
+	if err != nil {
+		return nil, fmt.Errorf("endorsers must be a JSON array of MSP IDs")
+	}
+	if len(orgs) == 0 {
+		return nil, nil
+	}
+	for _, org := range orgs {
+		if org == "" {
+			return nil, fmt.Errorf("endorsers must be a JSON array of MSP IDs")
+		}
+	}
+	policy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.AddOrgs(ENDORSER_ROLE, orgs...)
+	if err != nil {
+		return nil, err
+	}
+	return policy.Policy()
+}
+
+// marblePolicy is the key-level policy of the marble record, which its
+// delta, checkpoint and fee rows share.
+func marblePolicy(stub shim.ChaincodeStubInterface, marbleName string) ([]byte, error) {
+	marbleKey, err := catalogue.Key(stub, marbleName)
+	if err != nil {
+		return nil, err
+	}
+	return stub.GetStateValidationParameter(marbleKey)
+}
+
+// putRowPolicy applies the policy of the marble to a row written in this
+// transaction.
+func putRowPolicy(stub shim.ChaincodeStubInterface, key string, policy []byte) error {
+	if policy == nil {
+		return nil
+	}
+	return stub.SetStateValidationParameter(key, policy)
+}
+
+/**
+ * setMarbleEndorsement - change which orgs must endorse changes of a marble, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> endorsers; JSON array of MSP IDs, e.g. ["Org1MSP"], [] for the chaincode policy
+ *
+ * The policy applies to the marble record, its delta and checkpoint rows
+ * and the fee rows of its transfers. Only the portfolio index of owners is
+ * left to the chaincode policy. Pruning first keeps the rows to update few.
+ * The change itself must satisfy the current policy.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the setMarbleEndorsement invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) setMarbleEndorsement(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_SET_ENDORSEMENT).With("marble", marbleName)
+
+	err := identity.RequireAdmin(stub, FUNCTION_SET_ENDORSEMENT)
+	if err != nil {
+		log.With("error", err.Error()).Warning("endorsement change refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start set marble endorsement")
+
+	policy, err := endorsementPolicy(args.String("endorsers"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// a policy on the bare name would not move with migrateMarbles
+	marbleKey, err := catalogue.Key(stub, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	marbleAsBytes, err := stub.GetState(marbleKey)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		legacyAsBytes, err := catalogue.Get(stub, marbleName)
+		if err != nil {
+			return shim.Error("Failed to get marble:" + err.Error())
+		} else if legacyAsBytes != nil {
+			return shim.Error("Marble must be migrated first: " + marbleName)
+		}
+		return shim.Error("Marble does not exist")
+	}
+
+	keys := []string{marbleKey}
+	for _, objectType := range []string{KEY_TRANSFER, KEY_FEE_CHARGED} {
+		rowKeys, err := marbleRowKeys(stub, objectType, marbleName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = append(keys, rowKeys...)
+	}
+
+	for _, key := range keys {
+		err = stub.SetStateValidationParameter(key, policy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+// marbleRowKeys lists the keys of the objectType rows of a marble.
+func marbleRowKeys(stub shim.ChaincodeStubInterface, objectType, marbleName string) ([]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	keys := []string{}
+	for iterator.HasNext() {
+		responseRange, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, responseRange.Key)
+	}
+	return keys, nil
+}
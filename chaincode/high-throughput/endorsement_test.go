@@ -0,0 +1,143 @@
+package highthroughput
+
+import (
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/util"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+)
+
+// checkEndorsers checks the orgs of the validation parameter of key, none
+// for the chaincode policy.
+func checkEndorsers(t *testing.T, stub *shim.MockStub, key string, orgs ...string) {
+	t.Helper()
+	policy, _ := stub.GetStateValidationParameter(key)
+	if len(orgs) == 0 {
+		if policy != nil {
+			t.Errorf("key %q has a validation parameter", key)
+		}
+		return
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		t.Fatalf("validation parameter of key %q: %s", key, err.Error())
+	}
+	// ListOrgs walks a map, its order is not fixed
+	listed := ep.ListOrgs()
+	sort.Strings(listed)
+	sort.Strings(orgs)
+	if !reflect.DeepEqual(listed, orgs) {
+		t.Errorf("key %q is endorsed by %v, expected %v", key, listed, orgs)
+	}
+}
+
+// endorsedStub holds the sample marble, which Org1MSP must endorse.
+func endorsedStub(t *testing.T) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name), []byte(sampleMarble.Color),
+		[]byte(strconv.Itoa(sampleMarble.Size)), []byte(strconv.Itoa(totalAmount)), []byte(alice), []byte(`["Org1MSP"]`)}
+	util.CheckInvoke(t, stub.MockStub, arguments, txInit)
+	return stub
+}
+
+func Test_MARBLES_endorsement_success(t *testing.T) {
+	stub := endorsedStub(t)
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	initKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", alice, strconv.Itoa(totalAmount), txInit})
+	checkEndorsers(t, stub.MockStub, marbleKey, "Org1MSP")
+	checkEndorsers(t, stub.MockStub, initKey, "Org1MSP")
+
+	// transfer rows get the policy too
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, transferAmount1), txTransfer1)
+	transferKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, bob, strconv.Itoa(transferAmount1), txTransfer1})
+	checkEndorsers(t, stub.MockStub, transferKey, "Org1MSP")
+
+	// checkpoints keep the policy
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+	bobKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", bob, strconv.Itoa(transferAmount1), txPrune})
+	checkEndorsers(t, stub.MockStub, bobKey, "Org1MSP")
+
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte(sampleMarble.Name), []byte(`["Org2MSP","Org1MSP"]`)}, "both")
+	aliceKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", alice, strconv.Itoa(totalAmount - transferAmount1), txPrune})
+	for _, key := range []string{marbleKey, aliceKey, bobKey} {
+		checkEndorsers(t, stub.MockStub, key, "Org1MSP", "Org2MSP")
+	}
+
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte(sampleMarble.Name), []byte(`[]`)}, "chaincode")
+	for _, key := range []string{marbleKey, aliceKey, bobKey} {
+		checkEndorsers(t, stub.MockStub, key)
+	}
+}
+
+func Test_MARBLES_endorsementOfFees_success(t *testing.T) {
+	stub := endorsedStub(t)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte(sampleMarble.Name), []byte(treasury),
+		[]byte("0"), []byte("0"), []byte("0"), []byte("1")}, "rule")
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, transferAmount1), txTransfer1)
+	<-stub.ChaincodeEventsChannel
+
+	transferKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, bob, strconv.Itoa(transferAmount1), txTransfer1})
+	feeKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, treasury, "1", txTransfer1})
+	chargedKey, _ := stub.CreateCompositeKey(KEY_FEE_CHARGED, []string{sampleMarble.Name, treasury, alice, bob, txTransfer1})
+	for _, key := range []string{transferKey, feeKey, chargedKey} {
+		checkEndorsers(t, stub.MockStub, key, "Org1MSP")
+	}
+
+	// rows not pruned yet follow a change of the policy
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte(sampleMarble.Name), []byte(`["Org2MSP"]`)}, "org2")
+	for _, key := range []string{transferKey, feeKey, chargedKey} {
+		checkEndorsers(t, stub.MockStub, key, "Org2MSP")
+	}
+}
+
+func Test_MARBLES_importEndorsedMarble_success(t *testing.T) {
+	stub := endorsedStub(t)
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, transferAmount1), txTransfer1)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+	pages := exportPages(t, stub.MockStub, sampleMarble.Name, 2)
+
+	target := util.NewIdentityStub("copy", new(HighThroughputChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	target.MockInit("1", [][]byte{[]byte("init")})
+	for i, page := range pages {
+		util.CheckInvoke(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), page}, "import"+strconv.Itoa(i))
+	}
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	aliceKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", alice, strconv.Itoa(totalAmount - transferAmount1), txPrune})
+	bobKey, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", bob, strconv.Itoa(transferAmount1), txPrune})
+	for _, key := range []string{marbleKey, aliceKey, bobKey} {
+		checkEndorsers(t, target.MockStub, key, "Org1MSP")
+	}
+}
+
+func Test_MARBLES_endorsement_fail(t *testing.T) {
+	stub := endorsedStub(t)
+
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("500"), []byte(bob), []byte(`["Org1MSP",""]`)}
+	util.CheckInvokeFails(t, stub.MockStub, arguments, "endorsers must be a JSON array of MSP IDs", "init_blue")
+	blueKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{"BlueMarble"})
+	util.CheckStateNotExisted(t, stub.MockStub, blueKey)
+
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte("BlueMarble"), []byte(`["Org1MSP"]`)},
+		"Marble does not exist", "missing")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte(sampleMarble.Name), []byte(`{"Org1MSP":true}`)},
+		"endorsers must be a JSON array of MSP IDs", "object")
+
+	// a marble still under its bare name is migrated first
+	stub.MockTransactionStart("legacy")
+	stub.PutState("GreenMarble", []byte(`{"docType":"marble","name":"GreenMarble","color":"green","size":5}`))
+	stub.MockTransactionEnd("legacy")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte("GreenMarble"), []byte(`["Org1MSP"]`)},
+		"Marble must be migrated first: GreenMarble", "legacy")
+
+	stub.As("Org1MSP", alice, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_ENDORSEMENT), []byte(sampleMarble.Name), []byte(`[]`)},
+		"Only admins may call setMarbleEndorsement", "alice")
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	checkEndorsers(t, stub.MockStub, marbleKey, "Org1MSP")
+}
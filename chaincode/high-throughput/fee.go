@@ -0,0 +1,204 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/router"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	KEY_FEE_RULE    = "FeeRule/name"
+	KEY_FEE_CHARGED = "FeeCharged/name/treasury/sender/receiver/txid"
+
+	FUNCTION_SET_FEE_RULE = "setFeeRule"
+
+	EVENT_FEE_CHARGED = "feeCharged"
+
+	BASIS_POINTS = 10000
+
+	MAX_OWNER_LENGTH = 64
+)
+
+// feeRule is the fee transferMarbles charges the sender of a marble on top
+// of the amount, credited to Treasury. The fee is either Flat or
+// BasisPoints of the amount, rounded half up and then held between Min and
+// Max, where a Max of 0 is no limit.
+type feeRule struct {
+	ObjectType  string `json:"docType"`
+	Name        string `json:"name"`
+	Treasury    string `json:"treasury"`
+	BasisPoints int    `json:"basisPoints"`
+	Min         int    `json:"min"`
+	Max         int    `json:"max"`
+	Flat        int    `json:"flat"`
+}
+
+// feeEvent is a fee charged on a transfer, kept under KEY_FEE_CHARGED and
+// sent in the EVENT_FEE_CHARGED event.
+type feeEvent struct {
+	Marble   string `json:"marble"`
+	Sender   string `json:"sender"`
+	Receiver string `json:"receiver"`
+	Amount   int    `json:"amount"`
+	Fee      int    `json:"fee"`
+	Treasury string `json:"treasury"`
+}
+
+var setFeeRuleArgs = []router.Arg{
+	router.String("name", true),
+	router.LowerKey("treasury", true, MAX_OWNER_LENGTH),
+	router.Int("basisPoints", false, router.Bound(0), router.Bound(BASIS_POINTS)),
+	router.Int("min", false, router.Bound(0), nil),
+	router.Int("max", false, router.Bound(0), nil),
+	router.Int("flat", false, router.Bound(0), nil),
+}
+
+// fee is what the rule charges for a transfer of amount. Transfers of
+// nothing and transfers of the treasury are free.
+func (r *feeRule) fee(sender string, amount int) int {
+	if r == nil || amount == 0 || sender == r.Treasury {
+		return 0
+	}
+	if r.Flat > 0 {
+		return r.Flat
+	}
+	// split the amount so amount*BasisPoints cannot overflow
+	fee := amount/BASIS_POINTS*r.BasisPoints + (amount%BASIS_POINTS*r.BasisPoints+BASIS_POINTS/2)/BASIS_POINTS
+	if fee < r.Min {
+		fee = r.Min
+	}
+	if r.Max > 0 && fee > r.Max {
+		fee = r.Max
+	}
+	return fee
+}
+
+// putFeeCharged records a fee, so the fees in the balance of a treasury can
+// be told apart from transfers it received once the delta rows are pruned.
+func putFeeCharged(stub shim.ChaincodeStubInterface, charged feeEvent, policy []byte) error {
+	chargedKey, err := stub.CreateCompositeKey(KEY_FEE_CHARGED, []string{charged.Marble, charged.Treasury, charged.Sender, charged.Receiver, stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	chargedBytes, err := json.Marshal(charged)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(chargedKey, chargedBytes)
+	if err != nil {
+		return err
+	}
+	return putRowPolicy(stub, chargedKey, policy)
+}
+
+// getFeesCollected sums the fees credited to treasury for a marble.
+func getFeesCollected(stub shim.ChaincodeStubInterface, marbleName, treasury string) (int, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(KEY_FEE_CHARGED, []string{marbleName, treasury})
+	if err != nil {
+		return 0, err
+	}
+	defer iterator.Close()
+
+	collected := 0
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		charged := feeEvent{}
+		err = json.Unmarshal(result.Value, &charged)
+		if err != nil {
+			return 0, err
+		}
+		collected += charged.Fee
+	}
+	return collected, nil
+}
+
+// getFeeRule reads the fee rule of a marble, nil if it has none.
+func getFeeRule(stub shim.ChaincodeStubInterface, marbleName string) (*feeRule, error) {
+	ruleKey, err := stub.CreateCompositeKey(KEY_FEE_RULE, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	ruleAsBytes, err := stub.GetState(ruleKey)
+	if err != nil || ruleAsBytes == nil {
+		return nil, err
+	}
+	rule := &feeRule{}
+	err = json.Unmarshal(ruleAsBytes, rule)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+/**
+ * setFeeRule - set the fee of transfers of a marble, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> treasury; owner credited with the fees (key)
+ *	- args[2] -> basisPoints; fee in 1/10000 of the amount (not required)
+ *	- args[3] -> min; smallest basis point fee (not required)
+ *	- args[4] -> max; largest basis point fee, 0 for no limit (not required)
+ *	- args[5] -> flat; fee of every transfer instead of basis points (not required)
+ *
+ * A rule that charges nothing removes the fee of the marble.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the setFeeRule invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) setFeeRule(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	rule := &feeRule{"feeRule", args.String("name"), args.String("treasury"),
+		args.Int("basisPoints"), args.Int("min"), args.Int("max"), args.Int("flat")}
+
+	log := logger.ForTx(stub, FUNCTION_SET_FEE_RULE).With("marble", rule.Name)
+
+	err := identity.RequireAdmin(stub, FUNCTION_SET_FEE_RULE)
+	if err != nil {
+		log.With("error", err.Error()).Warning("fee rule refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start set fee rule")
+
+	if rule.Flat > 0 && (rule.BasisPoints > 0 || rule.Min > 0 || rule.Max > 0) {
+		return shim.Error("A fee is either flat or in basis points")
+	}
+	if rule.Max > 0 && rule.Max < rule.Min {
+		return shim.Error("max cannot be less than min")
+	}
+
+	marbleAsBytes, err := catalogue.Get(stub, rule.Name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	ruleKey, err := stub.CreateCompositeKey(KEY_FEE_RULE, []string{rule.Name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rule.Flat == 0 && rule.BasisPoints == 0 && rule.Min == 0 {
+		err = stub.DelState(ruleKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	ruleBytes, err := json.Marshal(rule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ruleKey, ruleBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,192 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"marbles-meetup/identity"
+	"marbles-meetup/util"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const treasury = "treasury"
+
+// feeStub holds the sample marble with a fee rule set by an admin.
+func feeStub(t *testing.T, rule ...string) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	initMarbleOn(t, stub.MockStub)
+	arguments := [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte(sampleMarble.Name), []byte(treasury)}
+	for _, arg := range rule {
+		arguments = append(arguments, []byte(arg))
+	}
+	util.CheckInvoke(t, stub.MockStub, arguments, "rule")
+	return stub
+}
+
+func transferArguments(sender, receiver string, amount int) [][]byte {
+	return [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name), []byte(sender), []byte(receiver), []byte(strconv.Itoa(amount))}
+}
+
+func Test_MARBLES_fee_success(t *testing.T) {
+	for _, test := range []struct {
+		rule   feeRule
+		amount int
+		fee    int
+	}{
+		{feeRule{BasisPoints: 25}, 1000, 3},
+		{feeRule{BasisPoints: 25}, 1199, 3},
+		{feeRule{BasisPoints: 25}, 1200, 3},
+		{feeRule{BasisPoints: 25}, 1201, 3},
+		{feeRule{BasisPoints: 25}, 1400, 4},
+		{feeRule{BasisPoints: 25, Min: 5}, 1000, 5},
+		{feeRule{BasisPoints: 25, Max: 2}, 1000, 2},
+		{feeRule{BasisPoints: 25, Min: 1}, 0, 0},
+		{feeRule{Flat: 7}, 1, 7},
+		{feeRule{Treasury: alice, Flat: 7}, 1, 0},
+		{feeRule{BasisPoints: 25}, math.MaxInt64, 23058430092136940},
+		{feeRule{BasisPoints: BASIS_POINTS}, math.MaxInt64, math.MaxInt64},
+	} {
+		if fee := test.rule.fee(alice, test.amount); fee != test.fee {
+			t.Errorf("fee of %d with %+v was %d, expected %d", test.amount, test.rule, fee, test.fee)
+		}
+	}
+}
+
+// checkFeeEvent checks the next event of stub lists the fees charged.
+func checkFeeEvent(t *testing.T, stub *shim.MockStub, charged ...feeEvent) {
+	t.Helper()
+	event := <-stub.ChaincodeEventsChannel
+	if event.EventName != EVENT_FEE_CHARGED {
+		t.Errorf("event %s was not %s", event.EventName, EVENT_FEE_CHARGED)
+	}
+	expected, _ := json.Marshal(charged)
+	if string(event.Payload) != string(expected) {
+		t.Errorf("event payload %s was not %s", event.Payload, expected)
+	}
+}
+
+func Test_MARBLES_transferWithFee_success(t *testing.T) {
+	stub := feeStub(t, "25", "5", "100")
+
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, transferAmount1), txTransfer1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount-transferAmount1-5)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, transferAmount1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 5)
+
+	checkFeeEvent(t, stub.MockStub, feeEvent{sampleMarble.Name, alice, bob, transferAmount1, 5, treasury})
+
+	// the treasury receives amount and fee in a single row
+	util.CheckInvoke(t, stub.MockStub, transferArguments(bob, treasury, 20), txTransfer2)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, transferAmount1-25)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 30)
+	<-stub.ChaincodeEventsChannel
+
+	// the fees charged stay in state after pruning
+	chargedKey, _ := stub.CreateCompositeKey(KEY_FEE_CHARGED, []string{sampleMarble.Name, treasury, alice, bob, txTransfer1})
+	util.CheckState(t, stub.MockStub, chargedKey, `{"marble":"RedMarble","sender":"alice","receiver":"bob","amount":1000,"fee":5,"treasury":"treasury"}`)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+	arguments := [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(treasury)}
+	util.CheckQuery(t, stub.MockStub, arguments, `{"marble":{"docType":"marble","name":"RedMarble","color":"red","size":30},
+		"owner":"treasury","amount":30,"feeRule":{"docType":"feeRule","name":"RedMarble","treasury":"treasury",
+		"basisPoints":25,"min":5,"max":100,"flat":0},"feesCollected":10}`, "read")
+
+	// and pays none
+	util.CheckInvoke(t, stub.MockStub, transferArguments(treasury, carol, 30), txTransfer3)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 0)
+	if len(stub.ChaincodeEventsChannel) != 0 {
+		t.Errorf("a free transfer sent an event")
+	}
+
+	arguments = [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(carol)}
+	util.CheckQuery(t, stub.MockStub, arguments, `{"marble":{"docType":"marble","name":"RedMarble","color":"red","size":30},
+		"owner":"carol","amount":30,"feeRule":{"docType":"feeRule","name":"RedMarble","treasury":"treasury",
+		"basisPoints":25,"min":5,"max":100,"flat":0}}`, "read")
+
+	// a rule that charges nothing removes the fee
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte(sampleMarble.Name), []byte(treasury)}, "free")
+	util.CheckInvoke(t, stub.MockStub, transferArguments(carol, bob, 30), txTransfer4)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, carol, 0)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 0)
+}
+
+func Test_MARBLES_transferWithFee_fail(t *testing.T) {
+	stub := feeStub(t, "0", "0", "0", "10")
+
+	// the sender must cover the fee too
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, bob, totalAmount), "Cannot transfer amount:", txTransfer1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 0)
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, totalAmount-10), txTransfer2)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, 0)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 10)
+
+	// amount and fee together overflow
+	stub = feeStub(t, "0", "0", "0", strconv.Itoa(math.MaxInt64))
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, bob, 1), "Cannot transfer amount:", txTransfer1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount)
+}
+
+func Test_MARBLES_acceptSwapWithFee_success(t *testing.T) {
+	stub := feeStub(t, "25", "5", "100")
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("500"), []byte(bob)}
+	util.CheckInvoke(t, stub.MockStub, arguments, "init_blue")
+	arguments = [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte("BlueMarble"), []byte(treasury), []byte("0"), []byte("0"), []byte("0"), []byte("1")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "rule_blue")
+
+	// the proposer must cover the fee too
+	proposeSwap(t, stub, "all", alice, sampleMarble.Name, strconv.Itoa(totalAmount), bob, "BlueMarble", "200")
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("all"), []byte(bob)}, "Proposer cannot give amount", "all")
+
+	// both sides pay the fee of the marble they give, in one event
+	proposeSwap(t, stub, "offer", alice, sampleMarble.Name, "1000", bob, "BlueMarble", "200")
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "accept")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount-1000-5)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 5)
+	checkAmount(t, stub.MockStub, "BlueMarble", bob, 500-200-1)
+	checkAmount(t, stub.MockStub, "BlueMarble", treasury, 1)
+	checkFeeEvent(t, stub.MockStub, feeEvent{sampleMarble.Name, alice, bob, 1000, 5, treasury}, feeEvent{"BlueMarble", bob, alice, 200, 1, treasury})
+}
+
+func Test_MARBLES_transferWithChaincodeFee_success(t *testing.T) {
+	stub := feeStub(t, "25", "5", "100")
+	example := shim.NewMockStub("example_cc", new(exampleChaincode))
+	example.MockInit("1", [][]byte{[]byte("init"), []byte("a"), []byte("100"), []byte("b"), []byte("200")})
+	stub.MockPeerChaincode("example_cc", example)
+
+	util.CheckInvokeFails(t, stub.MockStub, transferWithChaincodeArguments(totalAmount, "a"), "Cannot transfer amount:", txTransfer1)
+	util.CheckState(t, example, "a", "100")
+
+	util.CheckInvoke(t, stub.MockStub, transferWithChaincodeArguments(transferAmount1, "a"), txTransfer2)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount-transferAmount1-5)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, transferAmount1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 5)
+	checkFeeEvent(t, stub.MockStub, feeEvent{sampleMarble.Name, alice, bob, transferAmount1, 5, treasury})
+	util.CheckState(t, example, "a", "90")
+}
+
+func Test_MARBLES_setFeeRule_fail(t *testing.T) {
+	stub := feeStub(t, "25")
+
+	for expected, args := range map[string][]string{
+		"A fee is either flat or in basis points":  {sampleMarble.Name, treasury, "25", "0", "0", "10"},
+		"max cannot be less than min":              {sampleMarble.Name, treasury, "25", "10", "5"},
+		"basisPoints cannot be greater than 10000": {sampleMarble.Name, treasury, "10001"},
+		"Marble does not exist":                    {"GreenMarble", treasury, "25"},
+		"treasury cannot be empty":                 {sampleMarble.Name, "", "25"},
+		"treasury cannot contain 0x00":             {sampleMarble.Name, "tr\x00easury", "25"},
+	} {
+		arguments := [][]byte{[]byte(FUNCTION_SET_FEE_RULE)}
+		for _, arg := range args {
+			arguments = append(arguments, []byte(arg))
+		}
+		util.CheckInvokeFails(t, stub.MockStub, arguments, expected, "rule")
+	}
+
+	stub.As("Org1MSP", alice, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte(sampleMarble.Name), []byte(alice)},
+		"Only admins may call setFeeRule", "rule")
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 1000), txTransfer1)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, treasury, 3)
+}
@@ -0,0 +1,264 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/router"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// the owner is empty for the limit of every owner of a marble
+	KEY_TRANSFER_LIMIT = "TransferLimit/name/owner"
+	KEY_SPENT          = "Spent/name/owner/day/time/amount/txid"
+
+	FUNCTION_SET_TRANSFER_LIMIT = "setTransferLimit"
+	FUNCTION_READ_ALLOWANCE     = "readAllowance"
+
+	// limits apply to the last 24 hours of transaction timestamps
+	LIMIT_WINDOW = 24 * time.Hour
+
+	// spent rows are bucketed by the UTC date of the transaction timestamp,
+	// so the window reads the rows of two days
+	DAY_FORMAT = "2006-01-02"
+)
+
+// transferLimit is the most an owner may send of a marble in LIMIT_WINDOW.
+type transferLimit struct {
+	ObjectType string `json:"docType"`
+	Name       string `json:"name"`
+	Owner      string `json:"owner"`
+	Limit      int    `json:"limit"`
+}
+
+// allowance is what an owner sent of a marble after Since, LIMIT_WINDOW
+// before the transaction, and may still send. Spending is only tracked
+// while a limit applies, so Limit, Spent and Remaining are left out when
+// the owner has none.
+type allowance struct {
+	Marble    string `json:"marble"`
+	Owner     string `json:"owner"`
+	Since     string `json:"since"`
+	Limit     *int   `json:"limit,omitempty"`
+	Spent     *int   `json:"spent,omitempty"`
+	Remaining *int   `json:"remaining,omitempty"`
+	now       time.Time
+}
+
+var (
+	setTransferLimitArgs = []router.Arg{
+		router.String("name", true),
+		router.Int("limit", true, router.Bound(0), nil),
+		router.LowerString("owner", false),
+	}
+	readAllowanceArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("owner", true),
+	}
+)
+
+// getTransferLimit reads the limit of owner, or else the limit of every
+// owner of the marble, nil if neither is set.
+func getTransferLimit(stub shim.ChaincodeStubInterface, marbleName, owner string) (*transferLimit, error) {
+	for _, limitOwner := range []string{owner, ""} {
+		limitKey, err := stub.CreateCompositeKey(KEY_TRANSFER_LIMIT, []string{marbleName, limitOwner})
+		if err != nil {
+			return nil, err
+		}
+		limitAsBytes, err := stub.GetState(limitKey)
+		if err != nil {
+			return nil, err
+		}
+		if limitAsBytes != nil {
+			limit := &transferLimit{}
+			err = json.Unmarshal(limitAsBytes, limit)
+			if err != nil {
+				return nil, err
+			}
+			return limit, nil
+		}
+	}
+	return nil, nil
+}
+
+// getAllowance sums the spent rows of a limited owner in the window before
+// the transaction, from the buckets of its day and the day before. The rows
+// of an owner share a prefix, so transfers of different owners do not
+// conflict, and unlimited owners read no rows.
+func getAllowance(stub shim.ChaincodeStubInterface, marbleName, owner string) (*allowance, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	now = now.UTC()
+	since := now.Add(-LIMIT_WINDOW)
+	result := &allowance{Marble: marbleName, Owner: owner, Since: since.Format(time.RFC3339Nano), now: now}
+
+	limit, err := getTransferLimit(stub, marbleName, owner)
+	if err != nil || limit == nil {
+		return result, err
+	}
+
+	spent := 0
+	for _, day := range []string{since.Format(DAY_FORMAT), now.Format(DAY_FORMAT)} {
+		daySpent, err := getSpent(stub, marbleName, owner, day, since)
+		if err != nil {
+			return nil, err
+		}
+		spent += daySpent
+	}
+
+	remaining := limit.Limit - spent
+	if remaining < 0 {
+		remaining = 0
+	}
+	result.Limit = &limit.Limit
+	result.Spent = &spent
+	result.Remaining = &remaining
+	return result, nil
+}
+
+// getSpent sums the spent rows of an owner in the bucket of day that are
+// later than since.
+func getSpent(stub shim.ChaincodeStubInterface, marbleName, owner, day string, since time.Time) (int, error) {
+	spentIterator, err := stub.GetStateByPartialCompositeKey(KEY_SPENT, []string{marbleName, owner, day})
+	if err != nil {
+		return 0, err
+	}
+	defer spentIterator.Close()
+
+	spent := 0
+	for spentIterator.HasNext() {
+		responseRange, err := spentIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return 0, err
+		}
+		spentTime, err := time.Parse(time.RFC3339Nano, keyParts[3])
+		if err != nil {
+			return 0, err
+		}
+		if !spentTime.After(since) {
+			continue
+		}
+		amount, err := strconv.Atoi(keyParts[4])
+		if err != nil {
+			return 0, err
+		}
+		spent += amount
+	}
+	return spent, nil
+}
+
+func (a *allowance) allows(amount int) bool {
+	return a.Remaining == nil || amount <= *a.Remaining
+}
+
+// putSpent writes the spent row of a transfer of a limited owner.
+func putSpent(stub shim.ChaincodeStubInterface, a *allowance, amount int) error {
+	if a.Limit == nil || amount == 0 {
+		return nil
+	}
+	spentKey, err := stub.CreateCompositeKey(KEY_SPENT, []string{a.Marble, a.Owner, a.now.Format(DAY_FORMAT),
+		a.now.Format(time.RFC3339Nano), strconv.Itoa(amount), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(spentKey, []byte{0x00})
+}
+
+/**
+ * setTransferLimit - set the most an owner may send of a marble in any 24 hours, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> limit; amount per 24 hours, 0 removes the limit
+ *	- args[2] -> owner; owner the limit applies to, every owner without a limit of their own if empty (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the setTransferLimit invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) setTransferLimit(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	limit := &transferLimit{"transferLimit", args.String("name"), args.String("owner"), args.Int("limit")}
+
+	log := logger.ForTx(stub, FUNCTION_SET_TRANSFER_LIMIT).With("marble", limit.Name)
+
+	err := identity.RequireAdmin(stub, FUNCTION_SET_TRANSFER_LIMIT)
+	if err != nil {
+		log.With("error", err.Error()).Warning("transfer limit refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start set transfer limit")
+
+	marbleAsBytes, err := catalogue.Get(stub, limit.Name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	limitKey, err := stub.CreateCompositeKey(KEY_TRANSFER_LIMIT, []string{limit.Name, limit.Owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if limit.Limit == 0 {
+		err = stub.DelState(limitKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	}
+	limitBytes, err := json.Marshal(limit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(limitKey, limitBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * readAllowance - read what an owner sent of a marble in the last 24 hours and may still send
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> owner;
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readAllowance query
+ *
+ * @return A response structure with the allowance
+ */
+func (t *HighThroughputChaincode) readAllowance(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_READ_ALLOWANCE).With("marble", marbleName)
+	log.Debug("start read allowance")
+
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	result, err := getAllowance(stub, marbleName, args.String("owner"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
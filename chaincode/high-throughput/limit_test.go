@@ -0,0 +1,77 @@
+package highthroughput
+
+import (
+	"marbles-meetup/identity"
+	"marbles-meetup/util"
+	"testing"
+	"time"
+)
+
+var limitTime = time.Date(2019, 10, 1, 23, 0, 0, 0, time.UTC)
+
+// limitStub holds the sample marble, limited to 1000 in 24 hours and to 100
+// for bob, at limitTime.
+func limitStub(t *testing.T) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).
+		As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"}).At(limitTime)
+	initMarbleOn(t, stub.MockStub)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_TRANSFER_LIMIT), []byte(sampleMarble.Name), []byte("1000")}, "limit")
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_TRANSFER_LIMIT), []byte(sampleMarble.Name), []byte("100"), []byte(bob)}, "limit_bob")
+	return stub
+}
+
+func Test_MARBLES_transferLimit_success(t *testing.T) {
+	stub := limitStub(t)
+
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 600), txTransfer1)
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, carol, 400), txTransfer2)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_ALLOWANCE), []byte(sampleMarble.Name), []byte(alice)},
+		`{"marble":"RedMarble","owner":"alice","since":"2019-09-30T23:00:00Z","limit":1000,"spent":1000,"remaining":0}`, "read")
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_ALLOWANCE), []byte(sampleMarble.Name), []byte(bob)},
+		`{"marble":"RedMarble","owner":"bob","since":"2019-09-30T23:00:00Z","limit":100,"spent":0,"remaining":100}`, "read")
+
+	// the window rolls, a new calendar day does not reset it
+	stub.At(limitTime.Add(2 * time.Hour))
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, bob, 1), "Daily transfer limit exceeded", "next_day")
+	stub.At(limitTime.Add(23 * time.Hour))
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, bob, 1), "Daily transfer limit exceeded", "late")
+
+	// the limit starts over 24 hours after the transfers
+	stub.At(limitTime.Add(24 * time.Hour))
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 1000), txTransfer3)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 1600)
+
+	// without a limit nothing is tracked
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_TRANSFER_LIMIT), []byte(sampleMarble.Name), []byte("0")}, "unlimited")
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, carol, 5000), txTransfer4)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_ALLOWANCE), []byte(sampleMarble.Name), []byte(alice)},
+		`{"marble":"RedMarble","owner":"alice","since":"2019-10-01T23:00:00Z"}`, "read")
+}
+
+func Test_MARBLES_transferLimit_fail(t *testing.T) {
+	stub := limitStub(t)
+
+	util.CheckInvoke(t, stub.MockStub, transferArguments(alice, bob, 900), txTransfer1)
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, carol, 101), "Daily transfer limit exceeded", txTransfer2)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, carol, 0)
+
+	// bob's own limit applies instead of the marble's
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(bob, carol, 101), "Daily transfer limit exceeded", txTransfer3)
+
+	// fees count against the limit
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_FEE_RULE), []byte(sampleMarble.Name), []byte(treasury), []byte("0"),
+		[]byte("0"), []byte("0"), []byte("1")}, "fee")
+	util.CheckInvokeFails(t, stub.MockStub, transferArguments(alice, carol, 100), "Daily transfer limit exceeded", txTransfer4)
+
+	// so do swaps
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("500"), []byte(bob)}
+	util.CheckInvoke(t, stub.MockStub, arguments, "init_blue")
+	proposeSwap(t, stub, "offer", alice, sampleMarble.Name, "200", bob, "BlueMarble", "1")
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)},
+		"Daily transfer limit of the proposer exceeded", "accept")
+
+	stub.As("Org1MSP", alice, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_SET_TRANSFER_LIMIT), []byte(sampleMarble.Name), []byte("0")},
+		"Only admins may call setTransferLimit", "limit")
+}
@@ -2,9 +2,17 @@ package highthroughput
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/holders"
+	"marbles-meetup/identity"
+	"marbles-meetup/logging"
+	"marbles-meetup/paging"
+	"marbles-meetup/router"
+	"marbles-meetup/snapshot"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -18,51 +26,170 @@ type marble struct {
 }
 
 type marbleResponse struct {
-	Marble interface{} `json:"marble"`
-	Owner  string      `json:"owner"`
-	Amount int         `json:"amount"`
+	Marble        interface{} `json:"marble"`
+	Owner         string      `json:"owner"`
+	Amount        int         `json:"amount"`
+	FeeRule       *feeRule    `json:"feeRule,omitempty"`
+	FeesCollected int         `json:"feesCollected,omitempty"`
+}
+
+type portfolioEntry struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type portfolioResponse struct {
+	Owner   string           `json:"owner"`
+	Marbles []portfolioEntry `json:"marbles"`
 }
 
 const (
 	KEY_TRANSFER = "Transfer/name/sender/receiver/amount/txid"
+	KEY_PORTFOLIO = "Portfolio/owner/name"
 
 	FUNCTION_INIT = "initMarbles"
 	FUNCTION_TRANSFER = "transferMarbles"
 	FUNCTION_READ = "readMarbles"
 	FUNCTION_PRUNE = "pruneMarbles"
+	FUNCTION_EXPORT = "exportMarbleState"
+	FUNCTION_IMPORT = "importMarbleState"
+	FUNCTION_LIST_HOLDERS = "listHolders"
+	FUNCTION_READ_PORTFOLIO = "readPortfolio"
+	FUNCTION_LIST_MARBLES = "listMarbles"
+	FUNCTION_MIGRATE_MARBLES = "migrateMarbles"
+)
+
+var (
+	initMarblesArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("color", true),
+		router.Int("size", true, router.Bound(0), nil),
+		router.Int("amount", true, router.Bound(0), nil),
+		router.LowerString("owner", true),
+		router.JSON("endorsers", false),
+	}
+	// moveArgs are the arguments of every function that moves marbles
+	moveArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("sender", true),
+		router.LowerString("receiver", true),
+		router.Int("amount", true, router.Bound(0), nil),
+	}
+	transferMarblesArgs = moveArgs
+	readMarblesArgs = []router.Arg{
+		router.String("name", true),
+		router.LowerString("owner", false),
+	}
+	pruneMarblesArgs = []router.Arg{
+		router.String("name", true),
+	}
+	exportMarbleStateArgs = []router.Arg{
+		router.String("name", true),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	importMarbleStateArgs = []router.Arg{
+		router.JSON("snapshot", true),
+	}
+	readPortfolioArgs = []router.Arg{
+		router.LowerString("owner", true),
+	}
+	listMarblesArgs = []router.Arg{
+		router.String("docType", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	migrateMarblesArgs = []router.Arg{
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
+	listHoldersArgs = []router.Arg{
+		router.String("name", true),
+		router.Bool("nonZero", false),
+		router.LowerString("order", false),
+		router.Int("pageSize", false, router.Bound(1), router.Bound(paging.MAX_PAGE_SIZE)),
+		router.String("bookmark", false),
+	}
 )
 
+// withMoveArgs is moveArgs followed by args.
+func withMoveArgs(args ...router.Arg) []router.Arg {
+	return append(append([]router.Arg{}, moveArgs...), args...)
+}
+
+var logger = logging.NewLogger("marbles_high_throughput")
+
 type HighThroughputChaincode struct {
+	// the routes are built by the first Invoke, so new(HighThroughputChaincode) is ready to use
+	once   sync.Once
+	router *router.Router
 }
 
 func main() {
 	err := shim.Start(new(HighThroughputChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		logger.Errorf("Error starting Simple chaincode: %s", err)
 	}
 }
 
+/**
+ * Init - configure logging of the chaincode
+ * to give in the args array are as follows:
+ *	- args[0] -> level; logging level, overrides CORE_CHAINCODE_LOGGING_LEVEL (not required)
+ *	- args[1] -> redact; "false" to log amounts and balances (not required)
+ *
+ * Like any Init, it runs in the chaincode process of the endorsing peers
+ * only, so the settings are local to that process; see logging.Configure.
+ *
+ * @param stub The chaincode shim
+ *
+ * @return A response structure indicating success or failure with a message
+ */
 func (t *HighThroughputChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	err := logging.Configure(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
 func (t *HighThroughputChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
-	fmt.Println("invoke is running " + function)
-
-	// Handle different functions
-	if function == FUNCTION_INIT {
-		return t.initMarbles(stub, args)
-	} else if function == FUNCTION_TRANSFER {
-		return t.transferMarbles(stub, args)
-	} else if function == FUNCTION_READ {
-		return t.readMarbles(stub, args)
-	} else if function == FUNCTION_PRUNE {
-		return t.pruneMarbles(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	t.once.Do(func() {
+		t.router = t.routes()
+	})
+	return t.router.Handle(stub)
+}
+
+// routes binds the functions of the chaincode to their handlers.
+func (t *HighThroughputChaincode) routes() *router.Router {
+	return router.New(
+		router.Function{Name: FUNCTION_INIT, Args: initMarblesArgs, Handler: t.initMarbles},
+		router.Function{Name: FUNCTION_TRANSFER, Args: transferMarblesArgs, Handler: t.transferMarbles},
+		router.Function{Name: FUNCTION_READ, Args: readMarblesArgs, Handler: t.readMarbles},
+		router.Function{Name: FUNCTION_PRUNE, Args: pruneMarblesArgs, Handler: t.pruneMarbles},
+		router.Function{Name: FUNCTION_EXPORT, Args: exportMarbleStateArgs, Handler: t.exportMarbleState},
+		router.Function{Name: FUNCTION_IMPORT, Args: importMarbleStateArgs, Handler: t.importMarbleState},
+		router.Function{Name: FUNCTION_LIST_HOLDERS, Args: listHoldersArgs, Handler: t.listHolders},
+		router.Function{Name: FUNCTION_READ_PORTFOLIO, Args: readPortfolioArgs, Handler: t.readPortfolio},
+		router.Function{Name: FUNCTION_LIST_MARBLES, Args: listMarblesArgs, Handler: t.listMarbles},
+		router.Function{Name: FUNCTION_MIGRATE_MARBLES, Args: migrateMarblesArgs, Handler: t.migrateMarbles},
+		router.Function{Name: FUNCTION_PROPOSE_SWAP, Args: proposeSwapArgs, Handler: t.proposeSwap},
+		router.Function{Name: FUNCTION_ACCEPT_SWAP, Args: acceptSwapArgs, Handler: t.acceptSwap},
+		router.Function{Name: FUNCTION_CANCEL_SWAP, Args: cancelSwapArgs, Handler: t.cancelSwap},
+		router.Function{Name: FUNCTION_READ_SWAP, Args: readSwapArgs, Handler: t.readSwap},
+		router.Function{Name: FUNCTION_TRANSFER_WITH_CHAINCODE, Args: transferWithChaincodeArgs, Handler: t.transferWithChaincode},
+		router.Function{Name: FUNCTION_SET_FEE_RULE, Args: setFeeRuleArgs, Handler: t.setFeeRule},
+		router.Function{Name: FUNCTION_SET_TRANSFER_LIMIT, Args: setTransferLimitArgs, Handler: t.setTransferLimit},
+		router.Function{Name: FUNCTION_READ_ALLOWANCE, Args: readAllowanceArgs, Handler: t.readAllowance},
+		router.Function{Name: FUNCTION_PROPOSE_TRANSFER, Args: proposeTransferArgs, Handler: t.proposeTransfer},
+		router.Function{Name: FUNCTION_APPROVE_TRANSFER, Args: pendingTransferArgs, Handler: t.approveTransfer},
+		router.Function{Name: FUNCTION_EXECUTE_TRANSFER, Args: pendingTransferArgs, Handler: t.executeTransfer},
+		router.Function{Name: FUNCTION_CANCEL_TRANSFER, Args: pendingTransferArgs, Handler: t.cancelTransfer},
+		router.Function{Name: FUNCTION_READ_PENDING_TRANSFER, Args: pendingTransferArgs, Handler: t.readPendingTransfer},
+		router.Function{Name: FUNCTION_SET_ENDORSEMENT, Args: setMarbleEndorsementArgs, Handler: t.setMarbleEndorsement},
+		router.Function{Name: FUNCTION_SET_APPROVAL_RULE, Args: setApprovalRuleArgs, Handler: t.setApprovalRule},
+	)
 }
 
 /**
@@ -73,43 +200,42 @@ func (t *HighThroughputChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Re
  *	- args[2] -> size; size of marble
  *	- args[3] -> amount; total amount of marble
  *	- args[4] -> owner; owner id for this marble
+ *	- args[5] -> endorsers; JSON array of MSP IDs that must endorse changes of the marble, e.g. ["Org1MSP"] (not required)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the initMarbles invocation
+ * @param args The parsed arguments for the initMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call initMarbles")
-
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 6")
-	}
-
+func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
 	// Input sanitation
-	fmt.Println("- start init marble")
-	marbleName := args[0]
-	color := strings.ToLower(args[1])
-	size, err := strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
-	}
-	amount := args[3]
-	_, err = strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	}
-	owner := strings.ToLower(args[4])
+	marbleName := args.String("name")
+	color := args.String("color")
+	size := args.Int("size")
+	amount := strconv.Itoa(args.Int("amount"))
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_INIT).With("marble", marbleName)
+	log.Debug("start init marble")
 
 	// Check if marble already exists
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble: " + err.Error())
 	} else if marbleAsBytes != nil {
-		fmt.Println("This marble already exists: " + marbleName)
+		log.Warning("This marble already exists")
 		return shim.Error("This marble already exists: " + marbleName)
 	}
 
+	// the chaincode policy applies without endorsers
+	var policy []byte
+	if args.Has("endorsers") {
+		policy, err = endorsementPolicy(args.String("endorsers"))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	// Create marble object and marshal to JSON
 	objectType := "marble"
 	marble := &marble{objectType, marbleName, color, size}
@@ -119,7 +245,7 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
 	}
 
 	// Save marble to state
-	err = stub.PutState(marbleName, marbleJSONasBytes)
+	err = catalogue.Put(stub, marbleName, marbleJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -131,6 +257,26 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
 		return shim.Error(err.Error())
 	}
 	err = stub.PutState(compositeKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putPortfolio(stub, owner, marbleName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if policy != nil {
+		marbleKey, err := catalogue.Key(stub, marbleName)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, key := range []string{marbleKey, compositeKey} {
+			err = stub.SetStateValidationParameter(key, policy)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
 
 	return shim.Success(nil)
 }
@@ -144,60 +290,182 @@ func (t *HighThroughputChaincode) initMarbles(stub shim.ChaincodeStubInterface,
  *	- args[3] -> amount; amount to transfer
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the transferMarbles invocation
+ * @param args The parsed arguments for the transferMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call transferMarbles")
-
-	if len(args) < 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
+func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	marbleName := args.String("name")
+	sender := args.String("sender")
+	receiver := args.String("receiver")
+	amount := args.Int("amount")
 
-	marbleName := args[0]
-	sender := strings.ToLower(args[1])
-	receiver := strings.ToLower(args[2])
-	amount, err := strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("4rd argument must be a numeric string")
-	} else if amount < 0 {
-		return shim.Error("amount cannot be negative")
-	}
+	log := logger.ForTx(stub, FUNCTION_TRANSFER).With("marble", marbleName)
+	log.Debug("start transfer marble")
 
 	// check marble is existed
-	marbleAsBytes, err := stub.GetState(marbleName)
+	marbleAsBytes, err := catalogue.Get(stub, marbleName)
 	if err != nil {
 		return shim.Error("Failed to get marble:" + err.Error())
 	} else if marbleAsBytes == nil {
 		return shim.Error("Marble does not exist")
 	}
 
+	return transfer(stub, log, marbleName, sender, receiver, amount, false)
+}
+
+// transfer moves amount of an existing marble from sender to receiver,
+// charging the fee of the marble and counting against the daily limit of
+// the sender. Everything is checked before the first write.
+func transfer(stub shim.ChaincodeStubInterface, log *logging.Logger, marbleName, sender, receiver string, amount int, approved bool) pb.Response {
+	m, err := checkMove(stub, log, marbleName, sender, receiver, amount, approved)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putMoves(stub, m)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+var (
+	errCannotTransfer = errors.New("Cannot transfer amount:")
+	errLimitExceeded  = errors.New("Daily transfer limit exceeded")
+)
+
+// move is a transfer checked by checkMove, which putMoves writes.
+type move struct {
+	marble    string
+	sender    string
+	receiver  string
+	amount    int
+	fee       int
+	treasury  string
+	allowance *allowance
+}
+
+// checkMove checks that sender holds amount of a marble plus its fee and
+// that both fit the daily limit of the sender. Unless executeTransfer
+// approved it, the amount must not need approval by the approval rule of
+// the marble. It writes nothing, so every move of a transaction is checked
+// before any is written. The sender lacking the amount is errCannotTransfer,
+// exceeding the limit errLimitExceeded.
+func checkMove(stub shim.ChaincodeStubInterface, log *logging.Logger, marbleName, sender, receiver string, amount int, approved bool) (*move, error) {
+	if !approved {
+		approval, err := getApprovalRule(stub, marbleName)
+		if err != nil {
+			return nil, errors.New("Failed to get approval rule:" + err.Error())
+		}
+		if approval.requires(amount) {
+			log.WithSensitive("amount", amount).Warning("transfer needs approval")
+			return nil, fmt.Errorf("Transfers of more than %d of %s need approval through proposeTransfer", approval.Amount, marbleName)
+		}
+	}
+
+	// the fee is charged on top of the amount
+	rule, err := getFeeRule(stub, marbleName)
+	if err != nil {
+		return nil, errors.New("Failed to get fee rule:" + err.Error())
+	}
+	m := &move{marble: marbleName, sender: sender, receiver: receiver, amount: amount, fee: rule.fee(sender, amount)}
+	if rule != nil {
+		m.treasury = rule.Treasury
+	}
+
 	// check sender amount
 	senderAmount, err := getAmount(stub, marbleName, sender)
 	if err != nil {
-		return shim.Error("Cannot get sender Amount, err: " + err.Error())
+		return nil, errors.New("Cannot get sender Amount, err: " + err.Error())
 	}
 
-	// check sender can transfer amount
-	if senderAmount < amount {
-		fmt.Println(senderAmount)
-		fmt.Println(amount)
-		return shim.Error("Cannot transfer amount:")
+	// check sender can transfer amount, amount+fee may overflow
+	if senderAmount-amount < m.fee {
+		log.WithSensitive("senderAmount", senderAmount).WithSensitive("amount", amount).WithSensitive("fee", m.fee).Warning("sender cannot transfer amount")
+		return nil, errCannotTransfer
 	}
 
-	// Save amount
-	txID := stub.GetTxID()
-	compositeKey, err  := stub.CreateCompositeKey(KEY_TRANSFER, []string{marbleName, sender, receiver, strconv.Itoa(amount), txID})
+	// the fee counts against the daily limit of the sender too
+	m.allowance, err = getAllowance(stub, marbleName, sender)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.New("Cannot get sender allowance, err: " + err.Error())
 	}
-	err = stub.PutState(compositeKey, []byte{0x00})
+	if !m.allowance.allows(amount + m.fee) {
+		log.WithSensitive("spent", *m.allowance.Spent).WithSensitive("amount", amount).Warning("sender exceeds daily limit")
+		return nil, errLimitExceeded
+	}
+	return m, nil
+}
+
+// putMoves writes the delta rows and spent rows of moves, crediting their
+// fees to the treasury. Fabric keeps a single event per transaction, so the
+// fees charged are sent together in one EVENT_FEE_CHARGED event.
+func putMoves(stub shim.ChaincodeStubInterface, moves ...*move) error {
+	charged := []feeEvent{}
+	for _, m := range moves {
+		policy, err := marblePolicy(stub, m.marble)
+		if err != nil {
+			return err
+		}
+
+		// a fee to the receiver shares its row, which would have the same key
+		rowAmount := m.amount
+		if m.fee > 0 && m.receiver == m.treasury {
+			rowAmount += m.fee
+		}
+		err = putDelta(stub, m.marble, m.sender, m.receiver, rowAmount, policy)
+		if err != nil {
+			return err
+		}
+		if m.fee > 0 && m.receiver != m.treasury {
+			err = putDelta(stub, m.marble, m.sender, m.treasury, m.fee, policy)
+			if err != nil {
+				return err
+			}
+		}
+		err = putSpent(stub, m.allowance, m.amount+m.fee)
+		if err != nil {
+			return err
+		}
+		if m.fee > 0 {
+			fee := feeEvent{m.marble, m.sender, m.receiver, m.amount, m.fee, m.treasury}
+			err = putFeeCharged(stub, fee, policy)
+			if err != nil {
+				return err
+			}
+			charged = append(charged, fee)
+		}
+	}
+
+	if len(charged) == 0 {
+		return nil
+	}
+	eventBytes, err := json.Marshal(charged)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
+	return stub.SetEvent(EVENT_FEE_CHARGED, eventBytes)
+}
 
-	return shim.Success(nil)
+// putDelta writes the delta row of a transfer with the policy of the marble
+// and, unless it moves nothing, the portfolio index of the receiver.
+func putDelta(stub shim.ChaincodeStubInterface, marbleName, sender, receiver string, amount int, policy []byte) error {
+	compositeKey, err := stub.CreateCompositeKey(KEY_TRANSFER, []string{marbleName, sender, receiver, strconv.Itoa(amount), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(compositeKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+	err = putRowPolicy(stub, compositeKey, policy)
+	if err != nil {
+		return err
+	}
+	if amount == 0 {
+		return nil
+	}
+	return putPortfolio(stub, receiver, marbleName)
 }
 
 /**
@@ -207,19 +475,16 @@ func (t *HighThroughputChaincode) transferMarbles(stub shim.ChaincodeStubInterfa
  *	- args[1] -> owner; owner of marble (not required)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the readMarbles query
+ * @param args The parsed arguments for the readMarbles query
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[query] Call readMarbles")
+func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
-
-	name := args[0]
-	marbleAsbytes, err := stub.GetState(name) //get the marble from chaincode state
+	log := logger.ForTx(stub, FUNCTION_READ).With("marble", name)
+	log.Debug("start read marble")
+	marbleAsbytes, err := catalogue.Get(stub, name) //get the marble from chaincode state
 	if err != nil {
 		return shim.Error("{\"Error\":\"Failed to get state for " + name + "\"}")
 	} else if marbleAsbytes == nil {
@@ -230,17 +495,25 @@ func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface,
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	result := &marbleResponse{marble, "", 0}
+	result := &marbleResponse{marble, "", 0, nil, 0}
+	result.FeeRule, err = getFeeRule(stub, name)
+	if err != nil {
+		return shim.Error("Failed to get fee rule:" + err.Error())
+	}
 
 	// if parameter includes owner, return with amount info
-	if len(args) > 1 {
-		owner := args[1]
+	if args.Has("owner") {
+		owner := args.String("owner")
 		result.Owner = owner
 		ownerAmount, err := getAmount(stub, name, owner)
 		if err != nil {
 			return shim.Error("Cannot get sender Amount, err: " + err.Error())
 		}
 		result.Amount = ownerAmount
+		result.FeesCollected, err = getFeesCollected(stub, name, owner)
+		if err != nil {
+			return shim.Error("Failed to get fees collected:" + err.Error())
+		}
 	}
 
 	resultBytes, err := json.Marshal(result)
@@ -256,26 +529,30 @@ func (t *HighThroughputChaincode) readMarbles(stub shim.ChaincodeStubInterface,
  *	- args[0] -> name; name of marble (key)
  *
  * @param stub The chaincode shim
- * @param args The arguments array for the pruneMarbles invocation
+ * @param args The parsed arguments for the pruneMarbles invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("[invoke] Call pruneMarbles")
+func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to prune")
-	}
-	name := args[0]
+	log := logger.ForTx(stub, FUNCTION_PRUNE).With("marble", name)
+	log.Debug("start prune marble")
 
 	// check marble is existed
-	marbleAsBytes, err := stub.GetState(name)
+	marbleAsBytes, err := catalogue.Get(stub, name)
 	if err != nil {
 		return shim.Error("Failed to get marble:" + err.Error())
 	} else if marbleAsBytes == nil {
 		return shim.Error("Marble does not exist")
 	}
 
+	// the new checkpoint rows keep the policy of the marble
+	policy, err := marblePolicy(stub, name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	finalValue := make(map[string]int)
 	amountIterator, err := stub.GetStateByPartialCompositeKey(KEY_TRANSFER, []string{name})
 	if err != nil {
@@ -337,12 +614,293 @@ func (t *HighThroughputChaincode) pruneMarbles(stub shim.ChaincodeStubInterface,
 		if err != nil {
 			return shim.Error(err.Error())
 		}
+		err = putRowPolicy(stub, compositeKey, policy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// owners left with nothing drop out of the portfolio index
+		if value == 0 {
+			err = delPortfolio(stub, owner, name)
+		} else {
+			err = putPortfolio(stub, owner, name)
+		}
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 
 	return shim.Success(nil)
 }
 
+/**
+ * exportMarbleState - read a page of the marble record and delta rows of a marble
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (key)
+ *	- args[1] -> pageSize; number of rows per page, default 100 (not required)
+ *	- args[2] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the exportMarbleState query
+ *
+ * @return A response structure with the snapshot page, its bookmark is empty on the last page
+ */
+func (t *HighThroughputChaincode) exportMarbleState(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_EXPORT).With("marble", name)
+	log.Debug("start export marble")
+
+	page, err := snapshot.Export(stub, KEY_TRANSFER, name, args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+/**
+ * importMarbleState - write a page of an exported snapshot, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> snapshot; page returned by exportMarbleState, pages in order
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the importMarbleState invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) importMarbleState(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_IMPORT)
+
+	err := identity.RequireAdmin(stub, FUNCTION_IMPORT)
+	if err != nil {
+		log.With("error", err.Error()).Warning("import refused")
+		return shim.Error(err.Error())
+	}
+
+	page := &snapshot.Snapshot{}
+	err = json.Unmarshal([]byte(args.String("snapshot")), page)
+	if err != nil {
+		return shim.Error("Invalid snapshot: " + err.Error())
+	}
+	log = log.With("marble", page.Name)
+	log.Debug("start import marble")
+
+	// index the holders with the last page. Range reads do not see the
+	// writes of the import, so the rows of the page are added by hand.
+	var balances map[string]int
+	if page.Bookmark == "" {
+		balances, err = getBalances(stub, page.Name)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = snapshot.Import(stub, KEY_TRANSFER, page)
+	if err != nil {
+		log.With("error", err.Error()).Warning("import failed")
+		return shim.Error(err.Error())
+	}
+
+	if balances != nil {
+		for _, row := range page.Rows[:len(page.Rows)-1] {
+			_, keyParts, err := stub.SplitCompositeKey(row.Key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			amount, _ := strconv.Atoi(keyParts[3])
+			if len(keyParts[1]) != 0 {
+				balances[keyParts[1]] -= amount
+			}
+			if len(keyParts[2]) != 0 {
+				balances[keyParts[2]] += amount
+			}
+		}
+		for owner, amount := range balances {
+			if amount != 0 {
+				err = putPortfolio(stub, owner, page.Name)
+				if err != nil {
+					return shim.Error(err.Error())
+				}
+			}
+		}
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * readPortfolio - read every marble an owner holds
+ * to give in the args array are as follows:
+ *	- args[0] -> owner; owner id (required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readPortfolio query
+ *
+ * @return A response structure with the marbles of the owner and their non-zero amounts
+ */
+func (t *HighThroughputChaincode) readPortfolio(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_READ_PORTFOLIO)
+	log.Debug("start read portfolio")
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey(KEY_PORTFOLIO, []string{owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer indexIterator.Close()
+
+	result := &portfolioResponse{owner, []portfolioEntry{}}
+	for indexIterator.HasNext() {
+		responseRange, err := indexIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		marbleName := keyParts[1]
+		amount, err := getAmount(stub, marbleName, owner)
+		if err != nil {
+			return shim.Error("Cannot get owner Amount, err: " + err.Error())
+		}
+		if amount != 0 {
+			result.Marbles = append(result.Marbles, portfolioEntry{marbleName, amount})
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultBytes)
+}
+
+// putPortfolio adds a marble to the portfolio index of owner. The write is
+// blind, so concurrent transfers to the same owner do not conflict.
+func putPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func delPortfolio(stub shim.ChaincodeStubInterface, owner, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{owner, marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+/**
+ * listMarbles - list the marble records in the catalogue
+ * to give in the args array are as follows:
+ *	- args[0] -> docType; only list records of this docType (not required)
+ *	- args[1] -> pageSize; number of records per page, default 100 (not required)
+ *	- args[2] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listMarbles query
+ *
+ * @return A response structure with a page of marble records in name order
+ */
+func (t *HighThroughputChaincode) listMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_LIST_MARBLES)
+	log.Debug("start list marbles")
+
+	page, err := catalogue.List(stub, args.String("docType"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
+/**
+ * migrateMarbles - move the marble records stored under their bare name into the catalogue, admins only
+ * to give in the args array are as follows:
+ *	- args[0] -> pageSize; number of keys to scan, default 100 (not required)
+ *	- args[1] -> bookmark; bookmark of the previous migration (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the migrateMarbles invocation
+ *
+ * @return A response structure with the moved marbles and the bookmark to continue from, empty when done
+ */
+func (t *HighThroughputChaincode) migrateMarbles(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	log := logger.ForTx(stub, FUNCTION_MIGRATE_MARBLES)
+
+	err := identity.RequireAdmin(stub, FUNCTION_MIGRATE_MARBLES)
+	if err != nil {
+		log.With("error", err.Error()).Warning("migration refused")
+		return shim.Error(err.Error())
+	}
+	log.Debug("start migrate marbles")
+
+	migration, err := catalogue.Migrate(stub, args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	migrationBytes, err := json.Marshal(migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(migrationBytes)
+}
+
+/**
+ * listHolders - list the balance of every owner of a marble
+ * to give in the args array are as follows:
+ *	- args[0] -> name; name of marble (required)
+ *	- args[1] -> nonZero; "true" to leave out owners with a balance of 0 (not required)
+ *	- args[2] -> order; "owner" (default) or "amount" for the largest balances first (not required)
+ *	- args[3] -> pageSize; number of holders per page, default 100 (not required)
+ *	- args[4] -> bookmark; bookmark of the previous page (not required)
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the listHolders query
+ *
+ * @return A response structure with a page of holders, the holder count and the sum of their balances
+ */
+func (t *HighThroughputChaincode) listHolders(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	name := args.String("name")
+
+	log := logger.ForTx(stub, FUNCTION_LIST_HOLDERS).With("marble", name)
+	log.Debug("start list holders")
+
+	// check marble is existed
+	marbleAsBytes, err := catalogue.Get(stub, name)
+	if err != nil {
+		return shim.Error("Failed to get marble:" + err.Error())
+	} else if marbleAsBytes == nil {
+		return shim.Error("Marble does not exist")
+	}
+
+	balances, err := getBalances(stub, name)
+	if err != nil {
+		return shim.Error("Cannot get balances, err: " + err.Error())
+	}
+	page, err := holders.List(name, balances, args.Bool("nonZero"), args.String("order"), args.Int("pageSize"), args.String("bookmark"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageBytes)
+}
+
 func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int, error) {
 	amountResult := 0
 
@@ -371,7 +929,8 @@ func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int,
 					return 0, err
 				}
 				amountResult -= amountInt
-			} else if receiver == owner {
+			}
+			if receiver == owner {
 				amount := keyParts[3]
 				amountInt, err := strconv.Atoi(amount)
 				if err != nil {
@@ -383,3 +942,38 @@ func getAmount(stub shim.ChaincodeStubInterface, marbleName, owner string) (int,
 	}
 	return amountResult, nil
 }
+
+// getBalances sums the delta rows of a marble per owner, like getAmount
+// does for a single owner.
+func getBalances(stub shim.ChaincodeStubInterface, marbleName string) (map[string]int, error) {
+	balances := make(map[string]int)
+
+	amountIterator, err := stub.GetStateByPartialCompositeKey(KEY_TRANSFER, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	defer amountIterator.Close()
+
+	for amountIterator.HasNext() {
+		responseRange, err := amountIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		sender, receiver := keyParts[1], keyParts[2]
+		amount, err := strconv.Atoi(keyParts[3])
+		if err != nil {
+			return nil, err
+		}
+		if len(sender) != 0 {
+			balances[sender] -= amount
+		}
+		if len(receiver) != 0 {
+			balances[receiver] += amount
+		}
+	}
+	return balances, nil
+}
@@ -3,8 +3,13 @@ package highthroughput
 import (
 	"encoding/json"
 	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/logging"
+	"marbles-meetup/snapshot"
 	"marbles-meetup/util"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -31,13 +36,17 @@ func checkAmount(t *testing.T, stub *shim.MockStub, marbleName, owner string, ex
 func initMarble(t *testing.T) *shim.MockStub {
 	var scc = new(HighThroughputChaincode)
 	var stub = shim.NewMockStub("marbles", scc)
+	initMarbleOn(t, stub)
+
+	return stub
+}
+
+func initMarbleOn(t *testing.T, stub *shim.MockStub) {
 	stub.MockInit("1", [][]byte{[]byte("init")})
 	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
 		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
 		[]byte(strconv.Itoa(totalAmount)), []byte(alice)}
 	util.CheckInvoke(t, stub, arguments, txInit)
-
-	return stub
 }
 
 func Test_MARBLES_initMarble_success(t *testing.T) {
@@ -48,7 +57,8 @@ func Test_MARBLES_initMarble_success(t *testing.T) {
 
 	// check marble state
 	sampleMarbleBytes, _ := json.Marshal(sampleMarble)
-	util.CheckState(t, stub, sampleMarble.Name, string(sampleMarbleBytes))
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	util.CheckState(t, stub, marbleKey, string(sampleMarbleBytes))
 
 	// check state
 	key, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, "", alice, strconv.Itoa(totalAmount), txInit})
@@ -80,6 +90,17 @@ func Test_MARBLES_transferMarbles_success(t *testing.T) {
 	checkAmount(t, stub, sampleMarble.Name, bob, transferAmount1)
 }
 
+func Test_MARBLES_self_transferMarbles_success(t *testing.T) {
+	// invoke initMarbles
+	stub := initMarble(t)
+
+	// a transfer to the sender itself leaves the balance unchanged
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(alice), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub, arguments, txTransfer1)
+	checkAmount(t, stub, sampleMarble.Name, alice, totalAmount)
+}
+
 func Test_MARBLES_readMarbles_success(t *testing.T) {
 	fmt.Println("[TEST] readMarbles")
 
@@ -87,7 +108,7 @@ func Test_MARBLES_readMarbles_success(t *testing.T) {
 	stub := initMarble(t)
 
 	// check receiver query (check amount 0)
-	receiverResult := &marbleResponse{sampleMarble, bob, 0}
+	receiverResult := &marbleResponse{sampleMarble, bob, 0, nil, 0}
 	receiverResultBytes, _ := json.Marshal(receiverResult)
 	arguments := [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(bob)}
 	util.CheckQuery(t, stub, arguments, string(receiverResultBytes), "1")
@@ -98,13 +119,13 @@ func Test_MARBLES_readMarbles_success(t *testing.T) {
 	util.CheckInvoke(t, stub, arguments, "1")
 
 	// check sender query
-	senderResult := &marbleResponse{sampleMarble, alice, totalAmount - transferAmount1}
+	senderResult := &marbleResponse{sampleMarble, alice, totalAmount - transferAmount1, nil, 0}
 	senderResultBytes, _ := json.Marshal(senderResult)
 	arguments = [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(alice)}
 	util.CheckQuery(t, stub, arguments, string(senderResultBytes), "1")
 
 	// check receiver query
-	receiverResult = &marbleResponse{sampleMarble, bob, transferAmount1}
+	receiverResult = &marbleResponse{sampleMarble, bob, transferAmount1, nil, 0}
 	receiverResultBytes, _ = json.Marshal(receiverResult)
 	arguments = [][]byte{[]byte(FUNCTION_READ), []byte(sampleMarble.Name), []byte(bob)}
 	util.CheckQuery(t, stub, arguments, string(receiverResultBytes), "1")
@@ -214,4 +235,297 @@ func Test_MARBLES_pruneMarbles_success(t *testing.T) {
 	checkAmount(t, stub, sampleMarble.Name, alice, aliceAmount)
 	checkAmount(t, stub, sampleMarble.Name, bob,bobAmount)
 	checkAmount(t, stub, sampleMarble.Name, carol, carolAmount)
-}
\ No newline at end of file
+}
+func Test_MARBLES_transferMarbles_object_success(t *testing.T) {
+	// invoke initMarbles
+	stub := initMarble(t)
+
+	// invoke transfer with JSON object argument
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(`{"name":"` + sampleMarble.Name +
+		`","sender":"` + alice + `","receiver":"` + bob + `","amount":` + strconv.Itoa(transferAmount1) + `}`)}
+	util.CheckInvoke(t, stub, arguments, txTransfer1)
+
+	// check added state
+	key, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, bob, strconv.Itoa(transferAmount1), txTransfer1})
+	util.CheckState(t, stub, key, string([]byte{0x00}))
+
+	// check receiver query with JSON object argument
+	receiverResult := &marbleResponse{sampleMarble, bob, transferAmount1, nil, 0}
+	receiverResultBytes, _ := json.Marshal(receiverResult)
+	arguments = [][]byte{[]byte(FUNCTION_READ), []byte(`{"name":"` + sampleMarble.Name + `","owner":"` + bob + `"}`)}
+	util.CheckQuery(t, stub, arguments, string(receiverResultBytes), "1")
+}
+
+func Test_MARBLES_transferMarbles_fail_log(t *testing.T) {
+	// invoke initMarbles
+	stub := initMarble(t)
+	sink := util.NewLogSink(logging.DEBUG)
+	defer sink.Close()
+
+	// invoke transfer more than alice has
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(bob), []byte(strconv.Itoa(totalAmount + 1))}
+	util.CheckInvokeFails(t, stub, arguments, "Cannot transfer amount", txTransfer1)
+
+	// check amounts are redacted
+	util.CheckLog(t, sink, logging.WARNING, "sender cannot transfer amount", map[string]interface{}{
+		"txid": txTransfer1, "function": FUNCTION_TRANSFER, "marble": sampleMarble.Name,
+		"senderAmount": logging.REDACTED, "amount": logging.REDACTED,
+	})
+}
+
+func Test_MARBLES_transferMarbles_access(t *testing.T) {
+	// invoke initMarbles
+	stub := util.NewRecordingStub("marbles", new(HighThroughputChaincode))
+	initMarbleOn(t, stub.MockStub)
+
+	// invoke transfer
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(bob), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub.MockStub, arguments, txTransfer1)
+
+	// check only the marble record and the delta rows are read
+	access := stub.LastAccess()
+	prefix, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name})
+	marbleKey, _ := stub.CreateCompositeKey(catalogue.KEY_MARBLE, []string{sampleMarble.Name})
+	access.AssertReads(t, marbleKey)
+	access.AssertRangeReads(t, prefix)
+
+	// check the only writes are a new delta row and the blind portfolio index of the receiver
+	key, _ := stub.CreateCompositeKey(KEY_TRANSFER, []string{sampleMarble.Name, alice, bob, strconv.Itoa(transferAmount1), txTransfer1})
+	indexKey, _ := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{bob, sampleMarble.Name})
+	access.AssertWritesOnly(t, key, indexKey)
+	access.AssertNoReadOf(t, indexKey)
+}
+
+func Test_MARBLES_initMarble_fail(t *testing.T) {
+	var scc = new(HighThroughputChaincode)
+	var stub = shim.NewMockStub("marbles", scc)
+	stub.MockInit("1", [][]byte{[]byte("init")})
+
+	// missing owner
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte(strconv.Itoa(totalAmount))}
+	util.CheckInvokeFails(t, stub, arguments, "Incorrect number of arguments. Expecting 5", "1")
+
+	// negative amount
+	arguments = [][]byte{[]byte(FUNCTION_INIT), []byte(sampleMarble.Name),
+		[]byte(sampleMarble.Color), []byte(strconv.Itoa(sampleMarble.Size)),
+		[]byte("-1"), []byte(alice)}
+	util.CheckInvokeFails(t, stub, arguments, "amount cannot be less than 0", "1")
+	marbleKey, _ := catalogue.Key(stub, sampleMarble.Name)
+	util.CheckStateNotExisted(t, stub, marbleKey)
+}
+
+var marblesTarget = util.Target{
+	Name:      "highThroughput",
+	Chaincode: func() shim.Chaincode { return new(HighThroughputChaincode) },
+	Functions: util.Functions{Init: FUNCTION_INIT, Transfer: FUNCTION_TRANSFER,
+		Read: FUNCTION_READ, Prune: FUNCTION_PRUNE},
+	SenderCheck: true,
+}
+
+func Test_MARBLES_workload_conservation(t *testing.T) {
+	util.CheckWorkloads(t, marblesTarget, util.DefaultWorkload, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func Benchmark_MARBLES_initMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_INIT)
+}
+
+func Benchmark_MARBLES_transferMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_TRANSFER)
+}
+
+func Benchmark_MARBLES_readMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_READ)
+}
+
+func Benchmark_MARBLES_pruneMarbles(b *testing.B) {
+	util.BenchmarkFunction(b, marblesTarget, util.OP_PRUNE)
+}
+
+// exportPages pages through exportMarbleState and returns every page.
+func exportPages(t *testing.T, stub *shim.MockStub, name string, pageSize int) [][]byte {
+	var pages [][]byte
+	bookmark := ""
+	for {
+		arguments := [][]byte{[]byte(FUNCTION_EXPORT), []byte(name), []byte(strconv.Itoa(pageSize)), []byte(bookmark)}
+		res := stub.MockInvoke("export", arguments)
+		if res.Status != shim.OK {
+			t.Fatalf("export failed: %s", res.Message)
+		}
+		pages = append(pages, res.Payload)
+		page := &snapshot.Snapshot{}
+		json.Unmarshal(res.Payload, page)
+		if page.Bookmark == "" {
+			return pages
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func transfersOn(t *testing.T, stub *shim.MockStub) {
+	for i, transfer := range []struct {
+		sender, receiver string
+		amount           int
+	}{{alice, bob, transferAmount1}, {bob, carol, transferAmount2}, {alice, carol, transferAmount3}} {
+		arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+			[]byte(transfer.sender), []byte(transfer.receiver), []byte(strconv.Itoa(transfer.amount))}
+		util.CheckInvoke(t, stub, arguments, "transfer"+strconv.Itoa(i))
+	}
+}
+
+func Test_MARBLES_exportMarbleState_success(t *testing.T) {
+	stub := initMarble(t)
+	transfersOn(t, stub)
+
+	// 4 delta rows and the marble record in pages of 2
+	pages := exportPages(t, stub, sampleMarble.Name, 2)
+	if len(pages) != 3 {
+		t.Fatalf("exported %d pages", len(pages))
+	}
+	last := &snapshot.Snapshot{}
+	json.Unmarshal(pages[2], last)
+	if len(last.Rows) != 1 || last.Rows[0].Key != sampleMarble.Name || last.Hash != last.Digest() {
+		t.Errorf("last page %s", pages[2])
+	}
+
+	// import every page into another namespace
+	target := util.NewIdentityStub("copy", new(HighThroughputChaincode)).As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	target.MockInit("1", [][]byte{[]byte("init")})
+	for i, page := range pages {
+		util.CheckInvoke(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), page}, "import"+strconv.Itoa(i))
+	}
+	if len(target.State) != len(stub.State) {
+		t.Errorf("imported %d keys, exported %d", len(target.State), len(stub.State))
+	}
+	checkAmount(t, target.MockStub, sampleMarble.Name, alice, totalAmount-transferAmount1-transferAmount3)
+	checkAmount(t, target.MockStub, sampleMarble.Name, carol, transferAmount2+transferAmount3)
+	util.CheckQuery(t, target.MockStub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(carol)},
+		`{"owner":"carol","marbles":[{"name":"RedMarble","amount":60}]}`, "1")
+
+	// a single page exports the same rows
+	single := exportPages(t, stub, sampleMarble.Name, 10)
+	if len(single) != 1 {
+		t.Errorf("exported %d pages of 10", len(single))
+	}
+}
+
+func Test_MARBLES_importMarbleState_fail(t *testing.T) {
+	stub := initMarble(t)
+	transfersOn(t, stub)
+	pages := exportPages(t, stub, sampleMarble.Name, 2)
+
+	target := util.NewIdentityStub("copy", new(HighThroughputChaincode)).As("Org1MSP", "alice", nil)
+	target.MockInit("1", [][]byte{[]byte("init")})
+	util.CheckInvokeFails(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), pages[0]}, "Only admins may call importMarbleState", "1")
+
+	target.As("Org1MSP", "ops", map[string]string{identity.ATTRIBUTE_ADMIN: "true"})
+	util.CheckInvokeFails(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), pages[1]}, "Snapshot page does not continue the import of "+sampleMarble.Name, "1")
+
+	// tampered rows
+	page := &snapshot.Snapshot{}
+	json.Unmarshal(pages[0], page)
+	page.Rows[0].Key = strings.Replace(page.Rows[0].Key, strconv.Itoa(totalAmount), strconv.Itoa(totalAmount*10), 1)
+	tampered, _ := json.Marshal(page)
+	util.CheckInvokeFails(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), tampered}, "Snapshot checksum mismatch", "1")
+
+	// rows of another key, even with a matching hash
+	page.Rows[0] = snapshot.Row{Key: alice + sampleMarble.Name, Value: "1000"}
+	page.Hash = page.Digest()
+	tampered, _ = json.Marshal(page)
+	util.CheckInvokeFails(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), tampered}, "Snapshot row 0 is not a delta row of marble "+sampleMarble.Name, "1")
+	util.CheckStateNotExisted(t, target.MockStub, alice+sampleMarble.Name)
+
+	// into a namespace that has the marble
+	for i, page := range pages {
+		util.CheckInvoke(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), page}, "import"+strconv.Itoa(i))
+	}
+	util.CheckInvokeFails(t, target.MockStub, [][]byte{[]byte(FUNCTION_IMPORT), pages[2]}, "This marble already exists: "+sampleMarble.Name, "1")
+}
+
+func Test_MARBLES_listHolders_success(t *testing.T) {
+	stub := initMarble(t)
+	transfersOn(t, stub)
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(carol), []byte(bob), []byte(strconv.Itoa(transferAmount2+transferAmount3))}
+	util.CheckInvoke(t, stub, arguments, txTransfer4)
+
+	// carol gave away everything she received
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name)}
+	util.CheckQuery(t, stub, arguments, `{"marble":"RedMarble","holders":[{"owner":"alice","amount":98960},{"owner":"bob","amount":1040},
+		{"owner":"carol","amount":0}],"count":3,"sum":100000,"bookmark":""}`, "1")
+
+	var holders []string
+	bookmark := ""
+	for {
+		arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name), []byte("true"), []byte("amount"), []byte("1"), []byte(bookmark)}
+		res := stub.MockInvoke("1", arguments)
+		page := struct {
+			Holders  []struct{ Owner string }
+			Count    int
+			Bookmark string
+		}{}
+		json.Unmarshal(res.Payload, &page)
+		if res.Status != shim.OK || len(page.Holders) != 1 || page.Count != 2 {
+			t.Fatalf("listHolders returned %d %s", res.Status, res.Payload)
+		}
+		holders = append(holders, page.Holders[0].Owner)
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	if strings.Join(holders, ",") != "alice,bob" {
+		t.Errorf("paged holders %v", holders)
+	}
+
+	// the balances survive pruning
+	util.CheckInvoke(t, stub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+	arguments = [][]byte{[]byte(FUNCTION_LIST_HOLDERS), []byte(sampleMarble.Name), []byte("true")}
+	util.CheckQuery(t, stub, arguments, `{"marble":"RedMarble","holders":[{"owner":"alice","amount":98960},{"owner":"bob","amount":1040}],
+		"count":2,"sum":100000,"bookmark":""}`, "1")
+}
+
+func Test_MARBLES_listMarbles_success(t *testing.T) {
+	stub := initMarble(t)
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("5"), []byte(bob)}
+	util.CheckInvoke(t, stub, arguments, "2")
+
+	// pages in name order, the bookmark is the last name
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_LIST_MARBLES), []byte("marble"), []byte("1")},
+		`{"marbles":[{"docType":"marble","name":"BlueMarble","color":"blue","size":10}],"bookmark":"BlueMarble"}`, "3")
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_LIST_MARBLES), []byte("marble"), []byte("1"), []byte("BlueMarble")},
+		`{"marbles":[{"docType":"marble","name":"RedMarble","color":"red","size":30}],"bookmark":""}`, "3")
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_LIST_MARBLES), []byte("bag")}, `{"marbles":[],"bookmark":""}`, "3")
+	util.CheckInvokeFails(t, stub, [][]byte{[]byte(FUNCTION_LIST_MARBLES), []byte("marble"), []byte("0")}, "pageSize cannot be less than 1", "3")
+}
+
+func Test_MARBLES_readPortfolio_success(t *testing.T) {
+	stub := initMarble(t)
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("50"), []byte(bob)}
+	util.CheckInvoke(t, stub, arguments, "init_blue")
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(alice), []byte(bob), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub, arguments, txTransfer1)
+
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(bob)},
+		`{"owner":"bob","marbles":[{"name":"BlueMarble","amount":50},{"name":"RedMarble","amount":1000}]}`, "1")
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(carol)}, `{"owner":"carol","marbles":[]}`, "1")
+
+	// a marble given away is left out, and its index row is removed by prune
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name),
+		[]byte(bob), []byte(alice), []byte(strconv.Itoa(transferAmount1))}
+	util.CheckInvoke(t, stub, arguments, txTransfer2)
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(bob)},
+		`{"owner":"bob","marbles":[{"name":"BlueMarble","amount":50}]}`, "1")
+
+	indexKey, _ := stub.CreateCompositeKey(KEY_PORTFOLIO, []string{bob, sampleMarble.Name})
+	util.CheckState(t, stub, indexKey, string([]byte{0x00}))
+	util.CheckInvoke(t, stub, [][]byte{[]byte(FUNCTION_PRUNE), []byte(sampleMarble.Name)}, txPrune)
+	util.CheckStateNotExisted(t, stub, indexKey)
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(alice)},
+		`{"owner":"alice","marbles":[{"name":"RedMarble","amount":100000}]}`, "1")
+}
@@ -0,0 +1,330 @@
+package highthroughput
+
+import (
+	"encoding/json"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/identity"
+	"marbles-meetup/router"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	KEY_SWAP = "Swap/id"
+
+	FUNCTION_PROPOSE_SWAP = "proposeSwap"
+	FUNCTION_ACCEPT_SWAP  = "acceptSwap"
+	FUNCTION_CANCEL_SWAP  = "cancelSwap"
+	FUNCTION_READ_SWAP    = "readSwap"
+
+	// seconds a proposal can be accepted for
+	DEFAULT_SWAP_TTL = 24 * 60 * 60
+	MAX_SWAP_TTL     = 30 * DEFAULT_SWAP_TTL
+)
+
+// swap is an offer of Proposer to give GiveAmount of Give to Counterparty
+// for TakeAmount of Take. Its ID is the txid of the proposal.
+type swap struct {
+	ObjectType   string `json:"docType"`
+	ID           string `json:"id"`
+	Proposer     string `json:"proposer"`
+	Give         string `json:"give"`
+	GiveAmount   int    `json:"giveAmount"`
+	Counterparty string `json:"counterparty"`
+	Take         string `json:"take"`
+	TakeAmount   int    `json:"takeAmount"`
+	Expires      string `json:"expires"`
+}
+
+var (
+	proposeSwapArgs = []router.Arg{
+		router.LowerString("proposer", true),
+		router.String("give", true),
+		router.Int("giveAmount", true, router.Bound(1), nil),
+		router.LowerString("counterparty", true),
+		router.String("take", true),
+		router.Int("takeAmount", true, router.Bound(1), nil),
+		router.Int("ttl", false, router.Bound(1), router.Bound(MAX_SWAP_TTL)),
+	}
+	acceptSwapArgs = []router.Arg{
+		router.String("id", true),
+		router.LowerString("counterparty", true),
+	}
+	cancelSwapArgs = []router.Arg{
+		router.String("id", true),
+		router.LowerString("owner", true),
+	}
+	readSwapArgs = []router.Arg{
+		router.String("id", true),
+	}
+)
+
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ptypes.Timestamp(timestamp)
+}
+
+func getSwap(stub shim.ChaincodeStubInterface, id string) (string, *swap, error) {
+	swapKey, err := stub.CreateCompositeKey(KEY_SWAP, []string{id})
+	if err != nil {
+		return "", nil, err
+	}
+	swapAsBytes, err := stub.GetState(swapKey)
+	if err != nil || swapAsBytes == nil {
+		return swapKey, nil, err
+	}
+	record := &swap{}
+	err = json.Unmarshal(swapAsBytes, record)
+	return swapKey, record, err
+}
+
+func (s *swap) expired(now time.Time) bool {
+	return expiredAt(s.Expires, now)
+}
+
+// expiredAt reports whether now is not before expires, an RFC 3339 time.
+func expiredAt(expires string, now time.Time) bool {
+	expiresTime, err := time.Parse(time.RFC3339Nano, expires)
+	return err != nil || !now.Before(expiresTime)
+}
+
+/**
+ * proposeSwap - offer to swap an amount of one marble for an amount of another
+ * to give in the args array are as follows:
+ *	- args[0] -> proposer; owner who gives
+ *	- args[1] -> give; name of the marble the proposer gives
+ *	- args[2] -> giveAmount; amount the proposer gives
+ *	- args[3] -> counterparty; owner who may accept
+ *	- args[4] -> take; name of the marble the proposer takes
+ *	- args[5] -> takeAmount; amount the proposer takes
+ *	- args[6] -> ttl; seconds the proposal can be accepted for, default a day (not required)
+ *
+ * Owners are the certificate common names of their identities: only the
+ * proposer may propose, and only the counterparty may accept.
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the proposeSwap invocation
+ *
+ * @return A response structure with the proposal, its id is needed to accept or cancel it
+ */
+func (t *HighThroughputChaincode) proposeSwap(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	proposal := &swap{
+		ObjectType:   "swap",
+		ID:           stub.GetTxID(),
+		Proposer:     args.String("proposer"),
+		Give:         args.String("give"),
+		GiveAmount:   args.Int("giveAmount"),
+		Counterparty: args.String("counterparty"),
+		Take:         args.String("take"),
+		TakeAmount:   args.Int("takeAmount"),
+	}
+
+	log := logger.ForTx(stub, FUNCTION_PROPOSE_SWAP).With("swap", proposal.ID)
+	log.Debug("start propose swap")
+
+	proposer, err := identity.IsOwner(stub, proposal.Proposer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !proposer {
+		log.Warning("swap refused")
+		return shim.Error("Only the proposer may propose swap " + proposal.ID)
+	}
+	if proposal.Proposer == proposal.Counterparty {
+		return shim.Error("Cannot swap with oneself")
+	}
+	if proposal.Give == proposal.Take {
+		return shim.Error("Cannot swap a marble for itself")
+	}
+	for _, name := range []string{proposal.Give, proposal.Take} {
+		marbleAsBytes, err := catalogue.Get(stub, name)
+		if err != nil {
+			return shim.Error("Failed to get marble:" + err.Error())
+		} else if marbleAsBytes == nil {
+			return shim.Error("Marble does not exist: " + name)
+		}
+	}
+
+	// fail early, acceptSwap checks the amount again
+	proposerAmount, err := getAmount(stub, proposal.Give, proposal.Proposer)
+	if err != nil {
+		return shim.Error("Cannot get proposer Amount, err: " + err.Error())
+	}
+	if proposerAmount < proposal.GiveAmount {
+		log.WithSensitive("proposerAmount", proposerAmount).WithSensitive("amount", proposal.GiveAmount).Warning("proposer cannot give amount")
+		return shim.Error("Proposer cannot give amount")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ttl := DEFAULT_SWAP_TTL
+	if args.Has("ttl") {
+		ttl = args.Int("ttl")
+	}
+	proposal.Expires = now.Add(time.Duration(ttl) * time.Second).UTC().Format(time.RFC3339Nano)
+
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	swapKey, err := stub.CreateCompositeKey(KEY_SWAP, []string{proposal.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(swapKey, proposalBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proposalBytes)
+}
+
+/**
+ * acceptSwap - swap the marbles of a proposal, both transfers or neither
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the proposal
+ *	- args[1] -> counterparty; owner the proposal was made to, the creator
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the acceptSwap invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) acceptSwap(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_ACCEPT_SWAP).With("swap", id)
+	log.Debug("start accept swap")
+
+	swapKey, proposal, err := getSwap(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get swap:" + err.Error())
+	} else if proposal == nil {
+		return shim.Error("Swap does not exist: " + id)
+	}
+	counterparty, err := identity.IsOwner(stub, proposal.Counterparty)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.Counterparty != args.String("counterparty") || !counterparty {
+		log.Warning("accept refused")
+		return shim.Error("Only the counterparty may accept swap " + id)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.expired(now) {
+		log.Info("swap has expired")
+		return shim.Error("Swap has expired: " + id)
+	}
+
+	// check both sides before writing either
+	give, err := checkMove(stub, log.With("side", "proposer"), proposal.Give, proposal.Proposer, proposal.Counterparty, proposal.GiveAmount, false)
+	if err == errCannotTransfer {
+		return shim.Error("Proposer cannot give amount")
+	} else if err == errLimitExceeded {
+		return shim.Error("Daily transfer limit of the proposer exceeded")
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+	take, err := checkMove(stub, log.With("side", "counterparty"), proposal.Take, proposal.Counterparty, proposal.Proposer, proposal.TakeAmount, false)
+	if err == errCannotTransfer {
+		return shim.Error("Counterparty cannot give amount")
+	} else if err == errLimitExceeded {
+		return shim.Error("Daily transfer limit of the counterparty exceeded")
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = putMoves(stub, give, take)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(swapKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * cancelSwap - withdraw or decline a proposal
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the proposal
+ *	- args[1] -> owner; proposer or counterparty, the creator, anyone once the proposal expired
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the cancelSwap invocation
+ *
+ * @return A response structure indicating success or failure with a message
+ */
+func (t *HighThroughputChaincode) cancelSwap(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+	owner := args.String("owner")
+
+	log := logger.ForTx(stub, FUNCTION_CANCEL_SWAP).With("swap", id)
+	log.Debug("start cancel swap")
+
+	swapKey, proposal, err := getSwap(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get swap:" + err.Error())
+	} else if proposal == nil {
+		return shim.Error("Swap does not exist: " + id)
+	}
+	party, err := identity.IsOwner(stub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !party || owner != proposal.Proposer && owner != proposal.Counterparty {
+		now, err := txTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !proposal.expired(now) {
+			return shim.Error("Only the proposer or the counterparty may cancel swap " + id)
+		}
+	}
+
+	err = stub.DelState(swapKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/**
+ * readSwap - read a proposal
+ * to give in the args array are as follows:
+ *	- args[0] -> id; id of the proposal
+ *
+ * @param stub The chaincode shim
+ * @param args The parsed arguments for the readSwap query
+ *
+ * @return A response structure with the proposal
+ */
+func (t *HighThroughputChaincode) readSwap(stub shim.ChaincodeStubInterface, args router.Args) pb.Response {
+	id := args.String("id")
+
+	log := logger.ForTx(stub, FUNCTION_READ_SWAP).With("swap", id)
+	log.Debug("start read swap")
+
+	_, proposal, err := getSwap(stub, id)
+	if err != nil {
+		return shim.Error("Failed to get swap:" + err.Error())
+	} else if proposal == nil {
+		return shim.Error("Swap does not exist: " + id)
+	}
+	proposalBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proposalBytes)
+}
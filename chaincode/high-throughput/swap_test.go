@@ -0,0 +1,137 @@
+package highthroughput
+
+import (
+	"marbles-meetup/util"
+	"testing"
+	"time"
+)
+
+var swapTime = time.Date(2019, 10, 1, 9, 0, 0, 0, time.UTC)
+
+// swapStub holds 100000 RedMarble of alice and 500 BlueMarble of bob.
+func swapStub(t *testing.T) *util.IdentityStub {
+	stub := util.NewIdentityStub("marbles", new(HighThroughputChaincode)).At(swapTime)
+	initMarbleOn(t, stub.MockStub)
+	arguments := [][]byte{[]byte(FUNCTION_INIT), []byte("BlueMarble"), []byte("blue"), []byte("10"), []byte("500"), []byte(bob)}
+	util.CheckInvoke(t, stub.MockStub, arguments, "init_blue")
+	return stub
+}
+
+// proposeSwap proposes a swap as the proposer, args[0].
+func proposeSwap(t *testing.T, stub *util.IdentityStub, txID string, args ...string) {
+	arguments := [][]byte{[]byte(FUNCTION_PROPOSE_SWAP)}
+	for _, arg := range args {
+		arguments = append(arguments, []byte(arg))
+	}
+	creator := stub.Creator
+	stub.As("Org1MSP", args[0], nil)
+	util.CheckInvoke(t, stub.MockStub, arguments, txID)
+	stub.Creator = creator
+}
+
+func Test_MARBLES_acceptSwap_success(t *testing.T) {
+	stub := swapStub(t)
+	proposeSwap(t, stub, "offer", alice, sampleMarble.Name, "1000", bob, "BlueMarble", "200")
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_SWAP), []byte("offer")},
+		`{"docType":"swap","id":"offer","proposer":"alice","give":"RedMarble","giveAmount":1000,"counterparty":"bob",
+		"take":"BlueMarble","takeAmount":200,"expires":"2019-10-02T09:00:00Z"}`, "read")
+
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "accept")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount-1000)
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 1000)
+	checkAmount(t, stub.MockStub, "BlueMarble", alice, 200)
+	checkAmount(t, stub.MockStub, "BlueMarble", bob, 300)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte(FUNCTION_READ_PORTFOLIO), []byte(alice)},
+		`{"owner":"alice","marbles":[{"name":"BlueMarble","amount":200},{"name":"RedMarble","amount":99000}]}`, "read")
+
+	// a proposal is accepted once
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "Swap does not exist: offer", "again")
+}
+
+func Test_MARBLES_acceptSwap_fail(t *testing.T) {
+	stub := swapStub(t)
+	proposeSwap(t, stub, "offer", alice, sampleMarble.Name, "1000", bob, "BlueMarble", "200", "60")
+	stub.As("Org1MSP", carol, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(carol)},
+		"Only the counterparty may accept swap offer", "carol")
+
+	// naming the counterparty is not enough
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)},
+		"Only the counterparty may accept swap offer", "impostor")
+	checkAmount(t, stub.MockStub, "BlueMarble", alice, 0)
+	stub.As("Org1MSP", bob, nil)
+
+	// bob spends most of his BlueMarble before accepting, nothing is swapped
+	arguments := [][]byte{[]byte(FUNCTION_TRANSFER), []byte("BlueMarble"), []byte(bob), []byte(carol), []byte("350")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "spend")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "Counterparty cannot give amount", "short")
+	checkAmount(t, stub.MockStub, sampleMarble.Name, bob, 0)
+	checkAmount(t, stub.MockStub, "BlueMarble", alice, 0)
+
+	// neither if alice spent hers
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte("BlueMarble"), []byte(carol), []byte(bob), []byte("350")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "refund")
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name), []byte(alice), []byte(carol), []byte("99500")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "spend_red")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "Proposer cannot give amount", "short_red")
+	checkAmount(t, stub.MockStub, "BlueMarble", bob, 500)
+
+	// once it expired
+	arguments = [][]byte{[]byte(FUNCTION_TRANSFER), []byte(sampleMarble.Name), []byte(carol), []byte(alice), []byte("99500")}
+	util.CheckInvoke(t, stub.MockStub, arguments, "refund_red")
+	stub.At(swapTime.Add(time.Minute))
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer"), []byte(bob)}, "Swap has expired: offer", "late")
+}
+
+func Test_MARBLES_proposeSwap_fail(t *testing.T) {
+	stub := swapStub(t)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_PROPOSE_SWAP), []byte(alice), []byte(sampleMarble.Name), []byte("1"),
+		[]byte(bob), []byte("BlueMarble"), []byte("1")}, "Cannot identify the creator", "anonymous")
+
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_PROPOSE_SWAP), []byte(alice), []byte(sampleMarble.Name), []byte("1"),
+		[]byte(bob), []byte("BlueMarble"), []byte("1")}, "Only the proposer may propose swap propose", "propose")
+
+	stub.As("Org1MSP", alice, nil)
+	for expected, args := range map[string][]string{
+		"Proposer cannot give amount":        {alice, sampleMarble.Name, "100001", bob, "BlueMarble", "1"},
+		"Cannot swap with oneself":           {alice, sampleMarble.Name, "1", alice, "BlueMarble", "1"},
+		"Cannot swap a marble for itself":    {alice, sampleMarble.Name, "1", bob, sampleMarble.Name, "1"},
+		"Marble does not exist: GreenMarble": {alice, sampleMarble.Name, "1", bob, "GreenMarble", "1"},
+		"giveAmount cannot be less than 1":   {alice, sampleMarble.Name, "0", bob, "BlueMarble", "1"},
+		"ttl cannot be greater than 2592000": {alice, sampleMarble.Name, "1", bob, "BlueMarble", "1", "2592001"},
+	} {
+		arguments := [][]byte{[]byte(FUNCTION_PROPOSE_SWAP)}
+		for _, arg := range args {
+			arguments = append(arguments, []byte(arg))
+		}
+		util.CheckInvokeFails(t, stub.MockStub, arguments, expected, "propose")
+	}
+}
+
+func Test_MARBLES_cancelSwap_success(t *testing.T) {
+	stub := swapStub(t)
+	proposeSwap(t, stub, "offer1", alice, sampleMarble.Name, "10", bob, "BlueMarble", "1")
+	proposeSwap(t, stub, "offer2", alice, sampleMarble.Name, "10", bob, "BlueMarble", "1", "60")
+
+	// the counterparty declines
+	stub.As("Org1MSP", bob, nil)
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_CANCEL_SWAP), []byte("offer1"), []byte(bob)}, "decline")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_ACCEPT_SWAP), []byte("offer1"), []byte(bob)}, "Swap does not exist: offer1", "accept")
+
+	// others only remove expired proposals, even naming the proposer
+	stub.As("Org1MSP", carol, nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_CANCEL_SWAP), []byte("offer2"), []byte(carol)},
+		"Only the proposer or the counterparty may cancel swap offer2", "early")
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte(FUNCTION_CANCEL_SWAP), []byte("offer2"), []byte(alice)},
+		"Only the proposer or the counterparty may cancel swap offer2", "impostor")
+	stub.At(swapTime.Add(time.Hour))
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte(FUNCTION_CANCEL_SWAP), []byte("offer2"), []byte(carol)}, "cleanup")
+
+	response := stub.MockInvoke("read", [][]byte{[]byte(FUNCTION_READ_SWAP), []byte("offer2")})
+	if response.Message != "Swap does not exist: offer2" {
+		t.Errorf("read a cancelled swap: %s %s", response.Message, response.Payload)
+	}
+	checkAmount(t, stub.MockStub, sampleMarble.Name, alice, totalAmount)
+}
@@ -0,0 +1,105 @@
+// Package holders pages through the balances of every owner of a marble,
+// which the chaincodes aggregate from their own state layout.
+package holders
+
+import (
+	"fmt"
+	"marbles-meetup/paging"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ORDER_OWNER  = "owner"
+	ORDER_AMOUNT = "amount"
+)
+
+type Holder struct {
+	Owner  string `json:"owner"`
+	Amount int    `json:"amount"`
+}
+
+// Page is a page of holders. Count and Sum are over all holders that pass
+// the filter, not only those on the page.
+type Page struct {
+	Marble   string   `json:"marble"`
+	Holders  []Holder `json:"holders"`
+	Count    int      `json:"count"`
+	Sum      int      `json:"sum"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// bookmark is the amount and owner of the last holder of a page.
+func (h Holder) bookmark() string {
+	return strconv.Itoa(h.Amount) + ":" + h.Owner
+}
+
+func parseBookmark(bookmark string) (Holder, error) {
+	parts := strings.SplitN(bookmark, ":", 2)
+	if len(parts) != 2 {
+		return Holder{}, fmt.Errorf("Invalid bookmark")
+	}
+	amount, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Holder{}, fmt.Errorf("Invalid bookmark")
+	}
+	return Holder{parts[1], amount}, nil
+}
+
+/**
+ * List - sort and page the balances of a marble
+ *
+ * @param balances - the balance of every owner that ever held the marble
+ * @param nonZero - leave out owners with a balance of 0
+ * @param order - ORDER_OWNER for ascending owners, ORDER_AMOUNT for
+ *                descending amounts, then ascending owners
+ * @param bookmark - the Bookmark of the previous page, empty for the first
+ */
+func List(name string, balances map[string]int, nonZero bool, order string, pageSize int, bookmark string) (*Page, error) {
+	var before func(a, b Holder) bool
+	switch order {
+	case "", ORDER_OWNER:
+		before = func(a, b Holder) bool { return a.Owner < b.Owner }
+	case ORDER_AMOUNT:
+		before = func(a, b Holder) bool {
+			if a.Amount != b.Amount {
+				return a.Amount > b.Amount
+			}
+			return a.Owner < b.Owner
+		}
+	default:
+		return nil, fmt.Errorf("order must be %s or %s", ORDER_OWNER, ORDER_AMOUNT)
+	}
+	if pageSize <= 0 {
+		pageSize = paging.DEFAULT_PAGE_SIZE
+	}
+
+	page := &Page{Marble: name, Holders: []Holder{}}
+	var all []Holder
+	for owner, amount := range balances {
+		if nonZero && amount == 0 {
+			continue
+		}
+		all = append(all, Holder{owner, amount})
+		page.Count++
+		page.Sum += amount
+	}
+	sort.Slice(all, func(i, j int) bool { return before(all[i], all[j]) })
+
+	start := 0
+	if bookmark != "" {
+		last, err := parseBookmark(bookmark)
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(all), func(i int) bool { return before(last, all[i]) })
+	}
+	for i := start; i < len(all) && len(page.Holders) < pageSize; i++ {
+		page.Holders = append(page.Holders, all[i])
+	}
+	if end := start + len(page.Holders); end < len(all) {
+		page.Bookmark = all[end-1].bookmark()
+	}
+	return page, nil
+}
@@ -0,0 +1,68 @@
+package holders
+
+import (
+	"fmt"
+	"testing"
+)
+
+var balances = map[string]int{"alice": 50, "bob": 0, "carol": 200, "dave": 50, "erin": -10}
+
+func owners(page *Page) string {
+	var result []string
+	for _, holder := range page.Holders {
+		result = append(result, holder.Owner)
+	}
+	return fmt.Sprint(result)
+}
+
+func Test_HOLDERS_List_success(t *testing.T) {
+	page, err := List("RedMarble", balances, false, ORDER_OWNER, 0, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if owners(page) != "[alice bob carol dave erin]" || page.Count != 5 || page.Sum != 290 || page.Bookmark != "" {
+		t.Errorf("page %+v", page)
+	}
+
+	page, _ = List("RedMarble", balances, true, ORDER_AMOUNT, 0, "")
+	if owners(page) != "[carol alice dave erin]" || page.Count != 4 || page.Sum != 290 {
+		t.Errorf("non-zero by amount %+v", page)
+	}
+}
+
+func Test_HOLDERS_List_pages_success(t *testing.T) {
+	for _, order := range []string{ORDER_OWNER, ORDER_AMOUNT} {
+		var listed []string
+		bookmark, pages := "", 0
+		for {
+			page, err := List("RedMarble", balances, false, order, 2, bookmark)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if page.Count != 5 {
+				t.Errorf("count %d on page %d", page.Count, pages)
+			}
+			for _, holder := range page.Holders {
+				listed = append(listed, holder.Owner)
+			}
+			pages++
+			if page.Bookmark == "" {
+				break
+			}
+			bookmark = page.Bookmark
+		}
+		all, _ := List("RedMarble", balances, false, order, 10, "")
+		if fmt.Sprint(listed) != owners(all) || pages != 3 {
+			t.Errorf("%s paged %v in %d pages, expected %s", order, listed, pages, owners(all))
+		}
+	}
+}
+
+func Test_HOLDERS_List_fail(t *testing.T) {
+	if _, err := List("RedMarble", balances, false, "size", 0, ""); err == nil || err.Error() != "order must be owner or amount" {
+		t.Errorf("listed by size: %v", err)
+	}
+	if _, err := List("RedMarble", balances, false, ORDER_AMOUNT, 0, "alice"); err == nil || err.Error() != "Invalid bookmark" {
+		t.Errorf("listed after an invalid bookmark: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Package identity decides what the creator of a transaction may do. Admins
+// are enrolled with the marbles.admin attribute in their certificate, e.g.
+//
+//	fabric-ca-client register --id.name ops --id.attrs 'marbles.admin=true:ecert'
+package identity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const ATTRIBUTE_ADMIN = "marbles.admin"
+
+// IsAdmin reports whether the certificate of the creator has the
+// marbles.admin attribute set to true.
+func IsAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	value, found, err := cid.GetAttributeValue(stub, ATTRIBUTE_ADMIN)
+	if err != nil {
+		return false, fmt.Errorf("Cannot identify the creator: %s", err.Error())
+	}
+	return found && value == "true", nil
+}
+
+// Name is the MSP ID and the certificate common name of the creator, e.g.
+// Org1MSP/alice.
+func Name(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("Cannot identify the creator: %s", err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", fmt.Errorf("Cannot identify the creator: %s", err.Error())
+	}
+	return mspID + "/" + cert.Subject.CommonName, nil
+}
+
+// IsOwner reports whether owner, the name marbles are held under, is the
+// certificate common name of the creator. Owner names are lower case, so
+// the common name is compared regardless of case.
+func IsOwner(stub shim.ChaincodeStubInterface, owner string) (bool, error) {
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return false, fmt.Errorf("Cannot identify the creator: %s", err.Error())
+	}
+	return strings.EqualFold(cert.Subject.CommonName, owner), nil
+}
+
+// RequireAdmin fails unless the creator is an admin.
+func RequireAdmin(stub shim.ChaincodeStubInterface, function string) error {
+	admin, err := IsAdmin(stub)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("Only admins may call %s", function)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package identity
+
+import (
+	"marbles-meetup/util"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type adminChaincode struct {
+}
+
+func (t *adminChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *adminChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if function, _ := stub.GetFunctionAndParameters(); function == "name" {
+		name, err := Name(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success([]byte(name))
+	}
+	if err := RequireAdmin(stub, "configure"); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func Test_IDENTITY_RequireAdmin_success(t *testing.T) {
+	stub := util.NewIdentityStub("identity", new(adminChaincode)).As("Org1MSP", "ops", map[string]string{ATTRIBUTE_ADMIN: "true"})
+	util.CheckInvoke(t, stub.MockStub, [][]byte{[]byte("configure")}, "1")
+}
+
+func Test_IDENTITY_RequireAdmin_fail(t *testing.T) {
+	stub := util.NewIdentityStub("identity", new(adminChaincode))
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte("configure")},
+		"Cannot identify the creator: Expecting a PEM-encoded X509 certificate; PEM block not found", "1")
+
+	stub.As("Org1MSP", "alice", nil)
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte("configure")}, "Only admins may call configure", "1")
+
+	stub.As("Org1MSP", "alice", map[string]string{ATTRIBUTE_ADMIN: "false"})
+	util.CheckInvokeFails(t, stub.MockStub, [][]byte{[]byte("configure")}, "Only admins may call configure", "1")
+}
+
+func Test_IDENTITY_Name_success(t *testing.T) {
+	stub := util.NewIdentityStub("identity", new(adminChaincode)).As("Org2MSP", "bob", nil)
+	util.CheckQuery(t, stub.MockStub, [][]byte{[]byte("name")}, "Org2MSP/bob", "1")
+}
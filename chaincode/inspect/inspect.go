@@ -0,0 +1,215 @@
+// Package inspect decodes a marbles world state, from a JSON dump or the
+// State map of a shim.MockStub, into typed records and per-owner ledgers.
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KEY_TRANSFER is the object type of the delta rows of the high throughput
+// chaincodes: name, sender, receiver, amount and txid. The sender is empty
+// for the rows written by initMarbles and pruneMarbles.
+const KEY_TRANSFER = "Transfer/name/sender/receiver/amount/txid"
+
+// KEY_PORTFOLIO is the object type of the owner to marble index.
+const KEY_PORTFOLIO = "Portfolio/owner/name"
+
+// KEY_BALANCE is the object type of the balances of the general chaincode.
+// Older versions store them at owner+marbleName.
+const KEY_BALANCE = "Balance/owner/name"
+
+// KEY_SWAP is the object type of the swap proposals of the high throughput
+// chaincode.
+const KEY_SWAP = "Swap/id"
+
+// KEY_MARBLE is the object type of the marble records in the catalogue.
+// Older records are stored under the bare marble name.
+const KEY_MARBLE = "Marble/name"
+
+type Marble struct {
+	Key        string `json:"key"`
+	ObjectType string `json:"docType"`
+	Name       string `json:"name"`
+	Color      string `json:"color"`
+	Size       int    `json:"size"`
+}
+
+// Delta is a KEY_TRANSFER row.
+type Delta struct {
+	Key      string `json:"key"`
+	Marble   string `json:"marble"`
+	Sender   string `json:"sender"`
+	Receiver string `json:"receiver"`
+	Amount   int    `json:"amount"`
+	TxID     string `json:"txId"`
+}
+
+// Balance is a KEY_BALANCE row or a point key of the general chaincode,
+// owner+marbleName.
+type Balance struct {
+	Key    string `json:"key"`
+	Marble string `json:"marble"`
+	Owner  string `json:"owner"`
+	Amount int    `json:"amount"`
+}
+
+// Portfolio is a row of the owner to marble index.
+type Portfolio struct {
+	Key    string `json:"key"`
+	Owner  string `json:"owner"`
+	Marble string `json:"marble"`
+}
+
+// Swap is an open swap proposal, Proposal is its JSON record.
+type Swap struct {
+	Key      string          `json:"key"`
+	ID       string          `json:"id"`
+	Proposal json.RawMessage `json:"proposal"`
+}
+
+// Unknown is a key none of the marbles chaincodes writes.
+type Unknown struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type State struct {
+	Marbles    []Marble    `json:"marbles"`
+	Deltas     []Delta     `json:"deltas"`
+	Balances   []Balance   `json:"balances"`
+	Portfolios []Portfolio `json:"portfolios"`
+	Swaps      []Swap      `json:"swaps"`
+	Unknown    []Unknown   `json:"unknown"`
+}
+
+/**
+ * LoadDump - read a JSON state dump, either an object of keys to values or
+ * an array of {"key": ..., "value": ...} objects. Values are strings, the
+ * 0x00 marker of delta rows is "\u0000".
+ */
+func LoadDump(r io.Reader) (map[string][]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string][]byte)
+	object := make(map[string]string)
+	if err := json.Unmarshal(data, &object); err == nil {
+		for key, value := range object {
+			state[key] = []byte(value)
+		}
+		return state, nil
+	}
+	var entries []struct {
+		Key   *string `json:"key"`
+		Value string  `json:"value"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("state dump must be an object of keys to values or a list of {key, value}: %s", err.Error())
+	}
+	for i, entry := range entries {
+		if entry.Key == nil {
+			return nil, fmt.Errorf("state dump entry %d has no key", i)
+		}
+		state[*entry.Key] = []byte(entry.Value)
+	}
+	return state, nil
+}
+
+func splitCompositeKey(key string) (string, []string, bool) {
+	if !strings.HasPrefix(key, "\x00") || !strings.HasSuffix(key, "\x00") {
+		return "", nil, false
+	}
+	parts := strings.Split(key[1:len(key)-1], "\x00")
+	return parts[0], parts[1:], true
+}
+
+/**
+ * Decode - sort every key of state into marble records, delta rows,
+ * general balances and portfolio rows. A point key is a general balance if
+ * its value is a number and it ends with the name of a marble record.
+ *
+ * @return the records in key order
+ */
+func Decode(state map[string][]byte) *State {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &State{}
+	for _, key := range keys {
+		if objectType, attributes, ok := splitCompositeKey(key); ok && (objectType != KEY_MARBLE || len(attributes) != 1) {
+			continue
+		}
+		marble := Marble{Key: key}
+		if json.Unmarshal(state[key], &marble) == nil && marble.ObjectType == "marble" {
+			result.Marbles = append(result.Marbles, marble)
+		}
+	}
+
+	for _, key := range keys {
+		value := state[key]
+		if objectType, attributes, ok := splitCompositeKey(key); ok {
+			if objectType == KEY_TRANSFER && len(attributes) == 5 {
+				if amount, err := strconv.Atoi(attributes[3]); err == nil {
+					result.Deltas = append(result.Deltas, Delta{key, attributes[0], attributes[1], attributes[2], amount, attributes[4]})
+					continue
+				}
+			}
+			if objectType == KEY_BALANCE && len(attributes) == 2 {
+				if amount, err := strconv.Atoi(string(value)); err == nil {
+					result.Balances = append(result.Balances, Balance{key, attributes[1], attributes[0], amount})
+					continue
+				}
+			}
+			if objectType == KEY_PORTFOLIO && len(attributes) == 2 {
+				result.Portfolios = append(result.Portfolios, Portfolio{key, attributes[0], attributes[1]})
+				continue
+			}
+			if objectType == KEY_SWAP && len(attributes) == 1 && json.Valid(value) {
+				result.Swaps = append(result.Swaps, Swap{key, attributes[0], json.RawMessage(value)})
+				continue
+			}
+			if result.isMarble(key) {
+				continue
+			}
+		} else if amount, err := strconv.Atoi(string(value)); err == nil {
+			if balance, ok := result.balance(key, amount); ok {
+				result.Balances = append(result.Balances, balance)
+				continue
+			}
+		} else if result.isMarble(key) {
+			continue
+		}
+		result.Unknown = append(result.Unknown, Unknown{key, string(value)})
+	}
+	return result
+}
+
+func (s *State) isMarble(key string) bool {
+	for _, marble := range s.Marbles {
+		if marble.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// balance splits a general point key, preferring the longest marble name.
+func (s *State) balance(key string, amount int) (Balance, bool) {
+	found := Balance{}
+	for _, marble := range s.Marbles {
+		if strings.HasSuffix(key, marble.Name) && len(key) > len(marble.Name) && len(marble.Name) > len(found.Marble) {
+			found = Balance{key, marble.Name, strings.TrimSuffix(key, marble.Name), amount}
+		}
+	}
+	return found, found.Marble != ""
+}
@@ -0,0 +1,154 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"marbles-meetup/general"
+	"marbles-meetup/high-throughput"
+	"marbles-meetup/util"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func invoke(t *testing.T, stub *shim.MockStub, txID string, args ...string) {
+	var input [][]byte
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	if response := stub.MockInvoke(txID, input); response.Status != shim.OK {
+		t.Fatalf("%s failed: %s", args[0], response.Message)
+	}
+}
+
+func transfers(t *testing.T, stub *shim.MockStub) {
+	invoke(t, stub, "tx0", "initMarbles", "redMarbles", "red", "30", "1000", "alice")
+	invoke(t, stub, "tx1", "transferMarbles", "redMarbles", "alice", "bob", "300")
+	invoke(t, stub, "tx2", "transferMarbles", "redMarbles", "bob", "carol", "100")
+}
+
+func Test_INSPECT_Decode_highThroughput_success(t *testing.T) {
+	// proposeSwap needs the proposer as creator
+	identityStub := util.NewIdentityStub("marbles", new(highthroughput.HighThroughputChaincode)).As("Org1MSP", "alice", nil)
+	stub := identityStub.MockStub
+	transfers(t, stub)
+
+	state := Decode(stub.State)
+	if len(state.Marbles) != 1 || state.Marbles[0].Name != "redMarbles" || state.Marbles[0].Size != 30 {
+		t.Errorf("marbles %+v", state.Marbles)
+	}
+	if len(state.Deltas) != 3 || len(state.Balances) != 0 || len(state.Portfolios) != 3 || len(state.Unknown) != 0 {
+		t.Fatalf("decoded %+v", state)
+	}
+	if delta := state.Deltas[0]; delta.Sender != "" || delta.Receiver != "alice" || delta.Amount != 1000 || delta.TxID != "tx0" {
+		t.Errorf("init row %+v", delta)
+	}
+
+	expected := map[string]int{"alice": 700, "bob": 200, "carol": 100}
+	ledgers := state.Ledgers("")
+	if len(ledgers) != 3 {
+		t.Fatalf("%d ledgers", len(ledgers))
+	}
+	for _, ledger := range ledgers {
+		if ledger.Marble != "redMarbles" || ledger.Balance != expected[ledger.Owner] {
+			t.Errorf("ledger of %s has balance %d, expected %d", ledger.Owner, ledger.Balance, expected[ledger.Owner])
+		}
+	}
+	if bob := ledgers[1]; len(bob.Entries) != 2 || bob.Entries[0].Change != 300 || bob.Entries[0].Counterparty != "alice" ||
+		bob.Entries[1].Change != -100 || bob.Entries[1].Balance != 200 {
+		t.Errorf("ledger of bob %+v", bob.Entries)
+	}
+
+	invoke(t, stub, "tx3", "initMarbles", "blueMarbles", "blue", "10", "50", "bob")
+	invoke(t, stub, "offer", "proposeSwap", "alice", "redMarbles", "10", "bob", "blueMarbles", "1")
+	if swaps := Decode(stub.State).Swaps; len(swaps) != 1 || swaps[0].ID != "offer" {
+		t.Errorf("swaps %+v", swaps)
+	}
+
+	invoke(t, stub, "tx4", "pruneMarbles", "redMarbles")
+	for _, ledger := range Decode(stub.State).Ledgers("redMarbles") {
+		if len(ledger.Entries) != 1 || ledger.Entries[0].TxID != "tx4" || ledger.Balance != expected[ledger.Owner] {
+			t.Errorf("pruned ledger %+v", ledger)
+		}
+	}
+}
+
+func Test_INSPECT_Decode_general_success(t *testing.T) {
+	stub := shim.NewMockStub("marbles", new(general.SimpleChaincode))
+	transfers(t, stub)
+	invoke(t, stub, "tx3", "initMarbles", "Marbles", "blue", "10", "5", "alice")
+	stub.State["config"] = []byte("on")
+	// a balance of an older version at owner+marbleName
+	stub.State["daveredMarbles"] = []byte("1")
+
+	state := Decode(stub.State)
+	if len(state.Marbles) != 2 || len(state.Deltas) != 0 || len(state.Balances) != 5 {
+		t.Fatalf("decoded %+v", state)
+	}
+	for _, balance := range state.Balances {
+		if balance.Owner == "alice" && balance.Marble == "redMarbles" && balance.Amount != 700 {
+			t.Errorf("balance %+v", balance)
+		}
+		// daveredMarbles ends with both marble names, the longest wins
+		if balance.Key == "daveredMarbles" && (balance.Owner != "dave" || balance.Marble != "redMarbles") {
+			t.Errorf("balance %+v", balance)
+		}
+	}
+	if len(state.Unknown) != 1 || state.Unknown[0].Key != "config" {
+		t.Errorf("unknown keys %+v", state.Unknown)
+	}
+	if ledgers := state.Ledgers("Marbles"); len(ledgers) != 1 || ledgers[0].Owner != "alice" || ledgers[0].Balance != 5 {
+		t.Errorf("ledgers of Marbles %+v", ledgers)
+	}
+}
+
+func Test_INSPECT_LoadDump_success(t *testing.T) {
+	key := "\x00" + KEY_TRANSFER + "\x00redMarbles\x00alice\x00bob\x0020000\x00tx1\x00"
+	object, _ := json.Marshal(map[string]string{key: "\x00", "redMarbles": `{"docType":"marble","name":"redMarbles"}`})
+	list, _ := json.Marshal([]map[string]string{{"key": key, "value": "\x00"}, {"key": "redMarbles", "value": `{"docType":"marble","name":"redMarbles"}`}})
+
+	for _, dump := range [][]byte{object, list} {
+		state, err := LoadDump(bytes.NewReader(dump))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		decoded := Decode(state)
+		if len(decoded.Marbles) != 1 || len(decoded.Deltas) != 1 || decoded.Deltas[0].Amount != 20000 {
+			t.Errorf("decoded %s as %+v", dump, decoded)
+		}
+	}
+}
+
+func Test_INSPECT_LoadDump_fail(t *testing.T) {
+	for _, dump := range []string{`"key"`, `[{"value":"1"}]`, `{"key":1}`} {
+		if _, err := LoadDump(strings.NewReader(dump)); err == nil {
+			t.Errorf("loaded %s", dump)
+		}
+	}
+}
+
+func Test_INSPECT_Write_success(t *testing.T) {
+	stub := shim.NewMockStub("marbles", new(highthroughput.HighThroughputChaincode))
+	transfers(t, stub)
+	ledgers := Decode(stub.State).Ledgers("")
+
+	for format, expected := range map[string][]string{
+		FORMAT_TEXT: {"marble redMarbles\n", "  owner bob, balance 200\n", "         -100       200  carol        tx2\n"},
+		FORMAT_JSON: {`"owner": "carol"`, `"balance": 700`},
+		FORMAT_CSV:  {"marble,owner,counterparty,txId,change,balance\n", "redMarbles,alice,,tx0,1000,1000\n", "redMarbles,bob,carol,tx2,-100,200\n"},
+	} {
+		var written bytes.Buffer
+		if err := Write(&written, ledgers, format); err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, line := range expected {
+			if !strings.Contains(written.String(), line) {
+				t.Errorf("%s output does not contain %q:\n%s", format, line, written.String())
+			}
+		}
+	}
+	if err := Write(&bytes.Buffer{}, ledgers, "xml"); err == nil {
+		t.Errorf("wrote unknown format")
+	}
+}
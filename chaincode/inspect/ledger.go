@@ -0,0 +1,139 @@
+package inspect
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+	FORMAT_CSV  = "csv"
+)
+
+// Entry is one change to the balance of an owner. Counterparty is empty for
+// the rows written by initMarbles and pruneMarbles and for general balances.
+type Entry struct {
+	Counterparty string `json:"counterparty"`
+	TxID         string `json:"txId,omitempty"`
+	Key          string `json:"key"`
+	Change       int    `json:"change"`
+	Balance      int    `json:"balance"`
+}
+
+type Ledger struct {
+	Marble  string  `json:"marble"`
+	Owner   string  `json:"owner"`
+	Entries []Entry `json:"entries"`
+	Balance int     `json:"balance"`
+}
+
+func (l *Ledger) add(counterparty, txID, key string, change int) {
+	l.Balance += change
+	l.Entries = append(l.Entries, Entry{counterparty, txID, key, change, l.Balance})
+}
+
+/**
+ * Ledgers - the ledger of every owner of every marble. A delta row is an
+ * entry of the sender and of the receiver, a general balance is a single
+ * entry. Entries are in key order, which for delta rows is by sender and
+ * receiver rather than by time, so only the final balance is authoritative.
+ *
+ * @param marble - only build the ledgers of this marble if not empty
+ * @return the ledgers by marble, then by owner
+ */
+func (s *State) Ledgers(marble string) []*Ledger {
+	ledgers := make(map[[2]string]*Ledger)
+	get := func(marble, owner string) *Ledger {
+		id := [2]string{marble, owner}
+		if ledgers[id] == nil {
+			ledgers[id] = &Ledger{Marble: marble, Owner: owner}
+		}
+		return ledgers[id]
+	}
+	for _, delta := range s.Deltas {
+		if marble != "" && delta.Marble != marble {
+			continue
+		}
+		if delta.Sender != "" {
+			get(delta.Marble, delta.Sender).add(delta.Receiver, delta.TxID, delta.Key, -delta.Amount)
+		}
+		if delta.Receiver != "" {
+			get(delta.Marble, delta.Receiver).add(delta.Sender, delta.TxID, delta.Key, delta.Amount)
+		}
+	}
+	for _, balance := range s.Balances {
+		if marble == "" || balance.Marble == marble {
+			get(balance.Marble, balance.Owner).add("", "", balance.Key, balance.Amount)
+		}
+	}
+
+	result := make([]*Ledger, 0, len(ledgers))
+	for _, ledger := range ledgers {
+		result = append(result, ledger)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Marble != result[j].Marble {
+			return result[i].Marble < result[j].Marble
+		}
+		return result[i].Owner < result[j].Owner
+	})
+	return result
+}
+
+/**
+ * Write - print ledgers in format: text lists every entry under a heading
+ * per marble and owner, json is the ledgers with their entries and csv is
+ * one row per entry.
+ */
+func Write(w io.Writer, ledgers []*Ledger, format string) error {
+	switch format {
+	case FORMAT_TEXT:
+		return writeText(w, ledgers)
+	case FORMAT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ledgers)
+	case FORMAT_CSV:
+		return writeCSV(w, ledgers)
+	}
+	return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FORMAT_TEXT, FORMAT_JSON, FORMAT_CSV)
+}
+
+func writeText(w io.Writer, ledgers []*Ledger) error {
+	marble := ""
+	for _, ledger := range ledgers {
+		if ledger.Marble != marble {
+			marble = ledger.Marble
+			if _, err := fmt.Fprintf(w, "marble %s\n", marble); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "  owner %s, balance %d\n", ledger.Owner, ledger.Balance)
+		for _, entry := range ledger.Entries {
+			counterparty := entry.Counterparty
+			if counterparty == "" {
+				counterparty = "-"
+			}
+			fmt.Fprintf(w, "    %+9d %9d  %-12s %s\n", entry.Change, entry.Balance, counterparty, entry.TxID)
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, ledgers []*Ledger) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"marble", "owner", "counterparty", "txId", "change", "balance"})
+	for _, ledger := range ledgers {
+		for _, entry := range ledger.Entries {
+			writer.Write([]string{ledger.Marble, ledger.Owner, entry.Counterparty, entry.TxID,
+				strconv.Itoa(entry.Change), strconv.Itoa(entry.Balance)})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,234 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"marbles-meetup/client"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// request is a single generated call.
+type request struct {
+	Function string
+	Args     []string
+}
+
+// Result is the outcome of one request.
+type Result struct {
+	Function string
+	Latency  time.Duration
+	Success  bool
+	Reason   string
+}
+
+var (
+	codePattern    = regexp.MustCompile(`code:\s*([A-Z_]+)`)
+	timeoutPattern = regexp.MustCompile(`REQUEST_TIMEOUT`)
+)
+
+/**
+ * FailureReason - classify a failed gateway message, e.g. the validation
+ * code of "Failed to invoke chaincode. cause:Error: The invoke chaincode
+ * transaction was invalid, code:MVCC_READ_CONFLICT"
+ *
+ * @return the validation code, or a short description of the failure
+ */
+func FailureReason(message string) string {
+	if match := codePattern.FindStringSubmatch(message); match != nil {
+		return match[1]
+	}
+	switch {
+	case timeoutPattern.MatchString(message):
+		return "REQUEST_TIMEOUT"
+	case strings.Contains(message, "Failed to authenticate token"):
+		return "authentication failed"
+	case strings.Contains(message, "field is missing or Invalid"):
+		return "invalid request: " + strings.TrimSuffix(message, " field is missing or Invalid in the request")
+	case strings.Contains(message, "sender cannot transfer amount"):
+		return "insufficient balance"
+	case strings.Contains(message, "proposal"):
+		return "endorsement failed"
+	}
+	message = strings.TrimSpace(message)
+	if len(message) > 80 {
+		message = message[:80] + "..."
+	}
+	return message
+}
+
+// reason is the FailureReason of a request error.
+func reason(err error) string {
+	var clientErr *client.Error
+	if errors.As(err, &clientErr) {
+		if clientErr.StatusCode != http.StatusOK {
+			return "HTTP " + strconv.Itoa(clientErr.StatusCode)
+		}
+		return FailureReason(clientErr.Message)
+	}
+	return FailureReason(err.Error())
+}
+
+// Runner sends the requests of a spec through the gateway client once
+// each, without retries, so that every failure is reported.
+type Runner struct {
+	Spec    *Spec
+	invokes *client.Chaincode
+	queries *client.Chaincode
+	random  *rand.Rand
+	lock    sync.Mutex
+}
+
+// NewRunner returns a runner for a validated spec. httpClient may be nil.
+func NewRunner(spec *Spec, httpClient *http.Client) *Runner {
+	c := client.New(spec.URL)
+	if httpClient != nil {
+		c.HTTPClient = httpClient
+	}
+	c.Retry = client.NoRetry
+	c.SetToken(spec.Token)
+	channel, name, high, _ := spec.chaincode()
+	invokes := c.Chaincode(channel, name, spec.Peers...)
+	invokes.High = high
+	// the gateway only routes invokes through /high
+	queries := c.Chaincode(channel, name, spec.QueryPeer)
+	return &Runner{Spec: spec, invokes: invokes, queries: queries, random: rand.New(rand.NewSource(spec.Seed))}
+}
+
+func (r *Runner) next() request {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	spec := r.Spec
+
+	var functions []string
+	total := 0
+	for function, weight := range spec.Mix {
+		functions = append(functions, function)
+		total += weight
+	}
+	sort.Strings(functions)
+	pick := r.random.Intn(total)
+	function := functions[len(functions)-1]
+	for _, f := range functions {
+		if pick < spec.Mix[f] {
+			function = f
+			break
+		}
+		pick -= spec.Mix[f]
+	}
+
+	switch function {
+	case FUNCTION_TRANSFER:
+		sender := spec.Senders[r.random.Intn(len(spec.Senders))]
+		receiver := spec.Receivers[r.random.Intn(len(spec.Receivers))]
+		amount := spec.Amount.Min + r.random.Intn(spec.Amount.Max-spec.Amount.Min+1)
+		return request{function, []string{spec.Marble.Name, sender, receiver, strconv.Itoa(amount)}}
+	case FUNCTION_READ:
+		owners := append(append([]string(nil), spec.Senders...), spec.Receivers...)
+		return request{function, []string{spec.Marble.Name, owners[r.random.Intn(len(owners))]}}
+	}
+	return request{function, []string{spec.Marble.Name}}
+}
+
+func (r *Runner) do(ctx context.Context, req request) *Result {
+	start := time.Now()
+	var err error
+	if req.Function == FUNCTION_READ {
+		_, err = r.queries.Query(ctx, req.Function, req.Args...)
+	} else {
+		_, err = r.invokes.Invoke(ctx, req.Function, req.Args...)
+	}
+	result := &Result{Function: req.Function, Latency: time.Since(start), Success: err == nil}
+	if err != nil {
+		result.Reason = reason(err)
+	}
+	return result
+}
+
+// Init creates the marble of the spec with its supply owned by the first
+// sender.
+func (r *Runner) Init(ctx context.Context) error {
+	marble := r.Spec.Marble
+	_, err := r.invokes.InitMarbles(ctx, client.Marble{Name: marble.Name, Color: marble.Color, Size: marble.Size},
+		marble.Supply, r.Spec.Senders[0])
+	return err
+}
+
+/**
+ * Run - send requests from Spec.Concurrency workers until Spec.Duration has
+ * passed, Spec.Requests were sent or ctx is done, at Spec.Rate requests per
+ * second if it is set
+ *
+ * @return the report of every completed request
+ */
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	if r.Spec.Init {
+		if err := r.Init(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if r.Spec.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Spec.Duration)
+		defer cancel()
+	}
+
+	tickets := make(chan struct{})
+	go func() {
+		defer close(tickets)
+		var ticker *time.Ticker
+		if r.Spec.Rate > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / r.Spec.Rate))
+			defer ticker.Stop()
+		}
+		for sent := 0; r.Spec.Requests <= 0 || sent < r.Spec.Requests; sent++ {
+			if ticker != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case tickets <- struct{}{}:
+			}
+		}
+	}()
+
+	start := time.Now()
+	results := make(chan *Result)
+	var workers sync.WaitGroup
+	for i := 0; i < r.Spec.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for range tickets {
+				result := r.do(ctx, r.next())
+				if ctx.Err() != nil && !result.Success {
+					// cut off by the end of the run, not by the gateway
+					continue
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	report := &Report{}
+	for result := range results {
+		report.Add(result)
+	}
+	report.Elapsed = time.Since(start)
+	return report, nil
+}
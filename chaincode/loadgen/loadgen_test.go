@@ -0,0 +1,211 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type gatewayResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// fakeGateway answers like server/app.js. Every third transfer fails with
+// an MVCC_READ_CONFLICT.
+type fakeGateway struct {
+	lock      sync.Mutex
+	transfers int
+	requests  []string
+	tokens    map[string]bool
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.tokens == nil {
+		g.tokens = make(map[string]bool)
+	}
+	g.tokens[r.Header.Get("authorization")] = true
+
+	if r.Method == http.MethodGet {
+		g.requests = append(g.requests, "GET "+r.URL.Path+" "+r.URL.Query().Get("fcn")+" "+r.URL.Query().Get("args"))
+		w.Write([]byte(`redMarbles now has {"marble":{"docType":"marble","name":"redMarbles","color":"red","size":30},"owner":"bob","amount":20} after the move`))
+		return
+	}
+	body := struct {
+		Fcn  string   `json:"fcn"`
+		Args []string `json:"args"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&body)
+	g.requests = append(g.requests, "POST "+r.URL.Path+" "+body.Fcn+" "+strings.Join(body.Args, ","))
+	response := gatewayResponse{true, "Successfully invoked the chaincode Org1 to the channel 'mychannel' for transaction ID: 1234"}
+	if body.Fcn == FUNCTION_TRANSFER {
+		g.transfers++
+		if g.transfers%3 == 0 {
+			response = gatewayResponse{false, "Failed to invoke chaincode. cause:Error: The invoke chaincode transaction was invalid, code:MVCC_READ_CONFLICT"}
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func testSpec(url string) *Spec {
+	spec := &Spec{
+		URL:         url,
+		Endpoint:    "/high/channels/mychannel/chaincodes/marblehighthroughputphantom",
+		Peers:       []string{"peer0.org1.example.com"},
+		Init:        true,
+		Senders:     []string{"alice"},
+		Receivers:   []string{"bob", "carol"},
+		Mix:         map[string]int{FUNCTION_TRANSFER: 3, FUNCTION_READ: 1},
+		Concurrency: 4,
+		Requests:    40,
+		Seed:        1,
+	}
+	spec.Marble.Name = "redMarbles"
+	spec.Marble.Supply = 100000
+	return spec
+}
+
+func Test_LOADGEN_run_success(t *testing.T) {
+	gateway := &fakeGateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	spec := testSpec(server.URL)
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	report, err := NewRunner(spec, server.Client()).Run(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if report.Total() != 40 {
+		t.Fatalf("report has %d requests, expected 40", report.Total())
+	}
+	if gateway.requests[0] != "POST /high/channels/mychannel/chaincodes/marblehighthroughputphantom initMarbles redMarbles,red,0,100000,alice" {
+		t.Errorf("first request was %s", gateway.requests[0])
+	}
+	reads, transfers := 0, 0
+	for _, request := range gateway.requests[1:] {
+		switch {
+		case strings.HasPrefix(request, "GET /channels/mychannel/chaincodes/marblehighthroughputphantom readMarbles [\"redMarbles\","):
+			reads++
+		case strings.HasPrefix(request, "POST /high/channels/mychannel/chaincodes/marblehighthroughputphantom transferMarbles redMarbles,alice,"):
+			transfers++
+		default:
+			t.Errorf("unexpected request %s", request)
+		}
+	}
+	if reads == 0 || transfers == 0 || reads+transfers != 40 {
+		t.Errorf("%d reads and %d transfers were sent", reads, transfers)
+	}
+	if failed := transfers / 3; report.Failed != failed || report.Reasons["MVCC_READ_CONFLICT"] != failed {
+		t.Errorf("failures %v were not %d MVCC_READ_CONFLICT as expected", report.Reasons, failed)
+	}
+	if len(gateway.tokens) != 1 || !gateway.tokens["Bearer pass"] {
+		t.Errorf("authorization headers %v were not Bearer pass", gateway.tokens)
+	}
+	if latencies := report.Latencies(FUNCTION_TRANSFER); latencies.P50 <= 0 || latencies.P50 > latencies.P99 || latencies.P99 > latencies.Max {
+		t.Errorf("latencies %v are not ordered", latencies)
+	}
+
+	var printed bytes.Buffer
+	report.Print(&printed)
+	for _, expected := range []string{"requests      40", "readMarbles", "transferMarbles", "MVCC_READ_CONFLICT"} {
+		if !strings.Contains(printed.String(), expected) {
+			t.Errorf("report does not contain %q:\n%s", expected, printed.String())
+		}
+	}
+}
+
+func Test_LOADGEN_run_duration_success(t *testing.T) {
+	server := httptest.NewServer(&fakeGateway{})
+	defer server.Close()
+
+	spec := testSpec(server.URL)
+	spec.Requests = 0
+	spec.Duration = 300 * time.Millisecond
+	spec.Rate = 20
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	report, err := NewRunner(spec, server.Client()).Run(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// 20 per second for 300ms
+	if report.Total() < 1 || report.Total() > 7 {
+		t.Errorf("report has %d requests, expected at most 6", report.Total())
+	}
+}
+
+func Test_LOADGEN_run_fail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success":false,"message":"Failed to authenticate token. Make sure to include the token returned from /users call in the authorization header  as a Bearer token"}`))
+	}))
+	defer server.Close()
+
+	spec := testSpec(server.URL)
+	spec.Token = "invalid"
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err := NewRunner(spec, server.Client()).Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "initMarbles: Failed to authenticate token") {
+		t.Fatalf("Run returned %v, expected the failed initMarbles", err)
+	}
+
+	spec.Init = false
+	report, err := NewRunner(spec, server.Client()).Run(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report.Failed != 40 || report.Reasons["authentication failed"] != 40 {
+		t.Errorf("failures %v were not 40 authentication failed", report.Reasons)
+	}
+}
+
+func Test_LOADGEN_FailureReason_success(t *testing.T) {
+	reasons := map[string]string{
+		"Failed to invoke chaincode. cause:Error: The invoke chaincode transaction was invalid, code:MVCC_READ_CONFLICT":                                              "MVCC_READ_CONFLICT",
+		"Failed to invoke chaincode. cause:Error: The invoke chaincode transaction was invalid, code:PHANTOM_READ_CONFLICT":                                           "PHANTOM_READ_CONFLICT",
+		"Failed to invoke chaincode. cause:REQUEST_TIMEOUT:localhost:7051":                                                                                            "REQUEST_TIMEOUT",
+		"Failed to invoke chaincode. cause:invoke chaincode proposal resulted in an error :: Error: transaction returned with failure: sender cannot transfer amount": "insufficient balance",
+		"'args' field is missing or Invalid in the request":                                                                                                           "invalid request: 'args'",
+		"HTTP 502": "HTTP 502",
+	}
+	for message, expected := range reasons {
+		if reason := FailureReason(message); reason != expected {
+			t.Errorf("reason of %q was %q, expected %q", message, reason, expected)
+		}
+	}
+}
+
+func Test_LOADGEN_LoadSpec_success(t *testing.T) {
+	paths, _ := filepath.Glob("examples/*.yaml")
+	if len(paths) == 0 {
+		t.Fatal("No example specs")
+	}
+	for _, path := range paths {
+		if _, err := LoadSpec(path); err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	spec := &Spec{URL: "http://localhost:4000", Endpoint: "/users", Mix: map[string]int{FUNCTION_INIT: 1}}
+	err := spec.Validate()
+	for _, expected := range []string{"endpoint must be", "marble.name is required", "mix cannot contain initMarbles", "duration or requests is required"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Validate returned %v, expected %q", err, expected)
+		}
+	}
+}
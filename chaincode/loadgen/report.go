@@ -0,0 +1,125 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Latencies are the latency percentiles of a set of requests.
+type Latencies struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Report collects the results of a run.
+type Report struct {
+	Elapsed   time.Duration
+	Results   []*Result
+	Succeeded int
+	Failed    int
+	// Reasons counts failures by FailureReason.
+	Reasons map[string]int
+}
+
+func (r *Report) Add(result *Result) {
+	r.Results = append(r.Results, result)
+	if result.Success {
+		r.Succeeded++
+		return
+	}
+	r.Failed++
+	if r.Reasons == nil {
+		r.Reasons = make(map[string]int)
+	}
+	r.Reasons[result.Reason]++
+}
+
+func (r *Report) Total() int {
+	return r.Succeeded + r.Failed
+}
+
+func (r *Report) SuccessRate() float64 {
+	if r.Total() == 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / float64(r.Total())
+}
+
+// Latencies returns the percentiles of the requests to function, or of
+// every request if function is empty.
+func (r *Report) Latencies(function string) Latencies {
+	var latencies []time.Duration
+	for _, result := range r.Results {
+		if function == "" || result.Function == function {
+			latencies = append(latencies, result.Latency)
+		}
+	}
+	if len(latencies) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p int) time.Duration {
+		// nearest rank
+		rank := (p*len(latencies) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return latencies[rank-1]
+	}
+	return Latencies{percentile(50), percentile(90), percentile(99), latencies[len(latencies)-1]}
+}
+
+func (r *Report) functions() []string {
+	seen := make(map[string]bool)
+	var functions []string
+	for _, result := range r.Results {
+		if !seen[result.Function] {
+			seen[result.Function] = true
+			functions = append(functions, result.Function)
+		}
+	}
+	sort.Strings(functions)
+	return functions
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "requests      %d in %s (%.1f/s)\n", r.Total(), r.Elapsed.Round(time.Millisecond),
+		float64(r.Total())/r.Elapsed.Seconds())
+	fmt.Fprintf(w, "success rate  %.2f%% (%d ok, %d failed)\n", 100*r.SuccessRate(), r.Succeeded, r.Failed)
+	fmt.Fprintf(w, "\n%-18s %8s %10s %10s %10s %10s\n", "latency", "count", "p50", "p90", "p99", "max")
+	for _, function := range append(r.functions(), "") {
+		label, count := function, 0
+		for _, result := range r.Results {
+			if function == "" || result.Function == function {
+				count++
+			}
+		}
+		if function == "" {
+			label = "all"
+		}
+		l := r.Latencies(function)
+		fmt.Fprintf(w, "%-18s %8d %10s %10s %10s %10s\n", label, count, l.P50.Round(time.Millisecond),
+			l.P90.Round(time.Millisecond), l.P99.Round(time.Millisecond), l.Max.Round(time.Millisecond))
+	}
+	if len(r.Reasons) == 0 {
+		return
+	}
+	var reasons []string
+	for reason := range r.Reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if r.Reasons[reasons[i]] != r.Reasons[reasons[j]] {
+			return r.Reasons[reasons[i]] > r.Reasons[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	fmt.Fprintf(w, "\nfailures\n")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %6d  %s\n", r.Reasons[reason], reason)
+	}
+}
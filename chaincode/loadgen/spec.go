@@ -0,0 +1,150 @@
+package loadgen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	FUNCTION_INIT     = "initMarbles"
+	FUNCTION_TRANSFER = "transferMarbles"
+	FUNCTION_READ     = "readMarbles"
+	FUNCTION_PRUNE    = "pruneMarbles"
+)
+
+// Spec is a load test against the REST gateway in server/app.js, e.g.
+//
+//	url: http://localhost:4000
+//	endpoint: /high/channels/mychannel/chaincodes/marblehighthroughputphantom
+//	token: pass
+//	peers: [peer0.org1.example.com, peer0.org2.example.com]
+//	marble: {name: blueMarbles, color: blue, size: 50, supply: 100000}
+//	init: true
+//	senders: [alice]
+//	receivers: [bob, carol]
+//	mix: {transferMarbles: 9, readMarbles: 1}
+//	amount: {min: 1, max: 100}
+//	concurrency: 10
+//	rate: 20
+//	duration: 30s
+//
+// Invokes are posted to endpoint, queries are sent to the same chaincode
+// without the /high prefix since the gateway only routes invokes there.
+type Spec struct {
+	URL      string   `yaml:"url"`
+	Endpoint string   `yaml:"endpoint"`
+	Token    string   `yaml:"token"`
+	Peers    []string `yaml:"peers"`
+	// QueryPeer is the peer readMarbles is sent to, the first peer by default.
+	QueryPeer string `yaml:"queryPeer"`
+	Marble    struct {
+		Name   string `yaml:"name"`
+		Color  string `yaml:"color"`
+		Size   int    `yaml:"size"`
+		Supply int    `yaml:"supply"`
+	} `yaml:"marble"`
+	// Init creates the marble with its supply owned by the first sender
+	// before the run starts. It is not part of the report.
+	Init      bool           `yaml:"init"`
+	Senders   []string       `yaml:"senders"`
+	Receivers []string       `yaml:"receivers"`
+	Mix       map[string]int `yaml:"mix"`
+	Amount    struct {
+		Min int `yaml:"min"`
+		Max int `yaml:"max"`
+	} `yaml:"amount"`
+	Concurrency int `yaml:"concurrency"`
+	// Rate is the number of requests per second over all workers, 0 sends
+	// as fast as the workers can.
+	Rate     float64       `yaml:"rate"`
+	Duration time.Duration `yaml:"duration"`
+	// Requests stops the run after that many requests, 0 runs for Duration.
+	Requests int   `yaml:"requests"`
+	Seed     int64 `yaml:"seed"`
+}
+
+func LoadSpec(path string) (*Spec, error) {
+	specBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(specBytes, spec); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return spec, nil
+}
+
+// Validate checks the spec and fills in the defaults.
+func (s *Spec) Validate() error {
+	var errs []string
+	if s.URL == "" {
+		errs = append(errs, "url is required")
+	}
+	if _, _, _, ok := s.chaincode(); !ok {
+		errs = append(errs, "endpoint must be /channels/:channelName/chaincodes/:chaincodeName or /high/...")
+	}
+	if s.Marble.Name == "" {
+		errs = append(errs, "marble.name is required")
+	}
+	if len(s.Senders) == 0 || len(s.Receivers) == 0 {
+		errs = append(errs, "senders and receivers are required")
+	}
+	if len(s.Mix) == 0 {
+		errs = append(errs, "mix is required")
+	}
+	for function, weight := range s.Mix {
+		if function != FUNCTION_TRANSFER && function != FUNCTION_READ && function != FUNCTION_PRUNE {
+			errs = append(errs, "mix cannot contain "+function)
+		}
+		if weight < 0 {
+			errs = append(errs, "mix weight of "+function+" cannot be less than 0")
+		}
+	}
+	if s.Amount.Min < 0 || s.Amount.Max < s.Amount.Min {
+		errs = append(errs, "amount must satisfy 0 <= min <= max")
+	}
+	if s.Duration <= 0 && s.Requests <= 0 {
+		errs = append(errs, "duration or requests is required")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Invalid spec: %s", strings.Join(errs, "; "))
+	}
+
+	if s.Token == "" {
+		s.Token = "pass"
+	}
+	if s.QueryPeer == "" && len(s.Peers) > 0 {
+		s.QueryPeer = s.Peers[0]
+	}
+	if s.Concurrency <= 0 {
+		s.Concurrency = 1
+	}
+	if s.Amount.Max == 0 {
+		s.Amount.Min, s.Amount.Max = 1, 1
+	}
+	if s.Marble.Color == "" {
+		s.Marble.Color = "red"
+	}
+	return nil
+}
+
+// chaincode splits Endpoint into the channel and chaincode name.
+func (s *Spec) chaincode() (channel, name string, high bool, ok bool) {
+	path := s.Endpoint
+	if strings.HasPrefix(path, "/high/") {
+		high, path = true, strings.TrimPrefix(path, "/high")
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "channels" || parts[2] != "chaincodes" || parts[1] == "" || parts[3] == "" {
+		return "", "", false, false
+	}
+	return parts[1], parts[3], high, true
+}
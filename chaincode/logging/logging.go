@@ -0,0 +1,264 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARNING
+	ERROR
+)
+
+const (
+	ENV_LEVEL = "CORE_CHAINCODE_LOGGING_LEVEL"
+	REDACTED  = "[REDACTED]"
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR"}
+
+func (l Level) String() string {
+	if l < DEBUG || l > ERROR {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// ParseLevel accepts the level names used by CORE_CHAINCODE_LOGGING_LEVEL,
+// case-insensitively. WARN and CRITICAL are folded into WARNING and ERROR.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "INFO", "NOTICE":
+		return INFO, nil
+	case "WARNING", "WARN":
+		return WARNING, nil
+	case "ERROR", "CRITICAL":
+		return ERROR, nil
+	}
+	return INFO, fmt.Errorf("unknown logging level %s", name)
+}
+
+// Entry is a single log record as handed to a Sink. Sensitive field values
+// are already redacted.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Logger  string
+	Message string
+	Fields  map[string]interface{}
+}
+
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	record := make(map[string]interface{}, len(e.Fields)+4)
+	for key, value := range e.Fields {
+		record[key] = value
+	}
+	record["ts"] = e.Time.UTC().Format(time.RFC3339Nano)
+	record["level"] = e.Level
+	record["logger"] = e.Logger
+	record["msg"] = e.Message
+	return json.Marshal(record)
+}
+
+type Sink interface {
+	Write(entry *Entry)
+}
+
+// JSONSink writes one JSON object per entry.
+type JSONSink struct {
+	Writer io.Writer
+}
+
+func (s *JSONSink) Write(entry *Entry) {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintln(s.Writer, "failed to marshal log entry:", err.Error())
+		return
+	}
+	s.Writer.Write(append(entryBytes, '\n'))
+}
+
+var (
+	lock   sync.RWMutex
+	level  = INFO
+	redact = true
+	sink   Sink = &JSONSink{os.Stdout}
+)
+
+func init() {
+	if name, exists := os.LookupEnv(ENV_LEVEL); exists {
+		if envLevel, err := ParseLevel(name); err == nil {
+			level = envLevel
+		}
+	}
+}
+
+func SetLevel(l Level) {
+	lock.Lock()
+	defer lock.Unlock()
+	level = l
+}
+
+func GetLevel() Level {
+	lock.RLock()
+	defer lock.RUnlock()
+	return level
+}
+
+// SetRedact controls whether values added with WithSensitive are written
+// as REDACTED. Redaction is on by default.
+func SetRedact(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	redact = enabled
+}
+
+// SetSink replaces the destination of every logger and returns the
+// previous one so tests can restore it.
+func SetSink(s Sink) Sink {
+	lock.Lock()
+	defer lock.Unlock()
+	previous := sink
+	sink = s
+	return previous
+}
+
+/**
+ * Configure - apply the logging part of a chaincode Init call
+ * to give in the args array are as follows:
+ *	- args[0] -> level; DEBUG, INFO, WARNING or ERROR (not required)
+ *	- args[1] -> redact; "false" to log sensitive values (not required)
+ *
+ * Without a level argument the level from CORE_CHAINCODE_LOGGING_LEVEL is kept.
+ * The settings are not saved to state, so they only hold in the process that
+ * ran Init: the chaincode containers of other peers, and a restarted one,
+ * start from CORE_CHAINCODE_LOGGING_LEVEL again.
+ */
+func Configure(args []string) error {
+	if len(args) > 0 && args[0] != "" {
+		l, err := ParseLevel(args[0])
+		if err != nil {
+			return err
+		}
+		SetLevel(l)
+	}
+	if len(args) > 1 {
+		enabled, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return fmt.Errorf("redact must be true or false")
+		}
+		SetRedact(enabled)
+	}
+	return nil
+}
+
+type field struct {
+	key       string
+	value     interface{}
+	sensitive bool
+}
+
+// Logger is an immutable named logger with a set of fields attached to
+// every entry it writes. With and WithSensitive return a new Logger.
+type Logger struct {
+	name   string
+	fields []field
+}
+
+func NewLogger(name string) *Logger {
+	return &Logger{name: name}
+}
+
+func (l *Logger) with(f field) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{name: l.name, fields: append(fields, f)}
+}
+
+func (l *Logger) With(key string, value interface{}) *Logger {
+	return l.with(field{key, value, false})
+}
+
+// WithSensitive attaches a value such as a balance or an amount that is
+// only written when redaction is disabled.
+func (l *Logger) WithSensitive(key string, value interface{}) *Logger {
+	return l.with(field{key, value, true})
+}
+
+// ForTx attaches the txid and the function of the invocation on stub.
+func (l *Logger) ForTx(stub shim.ChaincodeStubInterface, function string) *Logger {
+	return l.With("txid", stub.GetTxID()).With("function", function)
+}
+
+func (l *Logger) IsEnabledFor(lv Level) bool {
+	return lv >= GetLevel()
+}
+
+func (l *Logger) log(lv Level, message string) {
+	lock.RLock()
+	enabled, redacted, s := lv >= level, redact, sink
+	lock.RUnlock()
+	if !enabled {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(l.fields))
+	for _, f := range l.fields {
+		if f.sensitive && redacted {
+			fields[f.key] = REDACTED
+		} else {
+			fields[f.key] = f.value
+		}
+	}
+	s.Write(&Entry{time.Now(), lv, l.name, message, fields})
+}
+
+func (l *Logger) Debug(message string) {
+	l.log(DEBUG, message)
+}
+
+func (l *Logger) Info(message string) {
+	l.log(INFO, message)
+}
+
+func (l *Logger) Warning(message string) {
+	l.log(WARNING, message)
+}
+
+func (l *Logger) Error(message string) {
+	l.log(ERROR, message)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DEBUG, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(INFO, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(WARNING, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ERROR, fmt.Sprintf(format, args...))
+}
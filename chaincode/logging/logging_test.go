@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type testChaincode struct {
+}
+
+func (t *testChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *testChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func captureJSON(t *testing.T, configure []string, write func(logger *Logger)) []map[string]interface{} {
+	var buffer bytes.Buffer
+	previous := SetSink(&JSONSink{&buffer})
+	previousLevel := GetLevel()
+	defer func() {
+		SetSink(previous)
+		SetLevel(previousLevel)
+		SetRedact(true)
+	}()
+
+	err := Configure(configure)
+	if err != nil {
+		fmt.Println("Configure failed", err.Error())
+		t.FailNow()
+	}
+	write(NewLogger("test"))
+
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(&buffer)
+	for decoder.More() {
+		record := make(map[string]interface{})
+		if err := decoder.Decode(&record); err != nil {
+			fmt.Println("Log output is not JSON", err.Error())
+			t.FailNow()
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func Test_LOGGING_parseLevel_success(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": DEBUG, "INFO": INFO, "warn": WARNING, "CRITICAL": ERROR} {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			fmt.Println("Level", name, "was not parsed as", expected)
+			t.FailNow()
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		fmt.Println("Level loud should not be parsed")
+		t.FailNow()
+	}
+}
+
+func Test_LOGGING_level_success(t *testing.T) {
+	records := captureJSON(t, []string{"WARNING"}, func(logger *Logger) {
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warning("warning")
+		logger.Errorf("error %d", 1)
+	})
+
+	if len(records) != 2 || records[0]["msg"] != "warning" || records[1]["msg"] != "error 1" {
+		fmt.Println("Unexpected records", records)
+		t.FailNow()
+	}
+	if records[1]["level"] != "ERROR" || records[1]["logger"] != "test" {
+		fmt.Println("Unexpected record", records[1])
+		t.FailNow()
+	}
+}
+
+func Test_LOGGING_fields_success(t *testing.T) {
+	stub := shim.NewMockStub("logging", new(testChaincode))
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	records := captureJSON(t, []string{"DEBUG"}, func(logger *Logger) {
+		log := logger.ForTx(stub, "transferMarbles").With("marble", "RedMarble")
+		log.WithSensitive("amount", 20).Info("transfer")
+		log.Info("done")
+	})
+
+	expected := map[string]interface{}{"txid": "tx1", "function": "transferMarbles", "marble": "RedMarble", "amount": REDACTED}
+	for key, value := range expected {
+		if records[0][key] != value {
+			fmt.Println("Field", key, records[0][key], "was not", value, "as expected")
+			t.FailNow()
+		}
+	}
+	if _, exists := records[1]["amount"]; exists {
+		fmt.Println("Field amount leaked into", records[1])
+		t.FailNow()
+	}
+}
+
+func Test_LOGGING_redact_disabled_success(t *testing.T) {
+	records := captureJSON(t, []string{"", "false"}, func(logger *Logger) {
+		logger.WithSensitive("amount", 20).Warning("transfer")
+	})
+
+	if records[0]["amount"] != float64(20) {
+		fmt.Println("Field amount", records[0]["amount"], "was not 20 as expected")
+		t.FailNow()
+	}
+}
+
+func Test_LOGGING_configure_fail(t *testing.T) {
+	if err := Configure([]string{"loud"}); err == nil {
+		fmt.Println("Configure should fail with unknown level")
+		t.FailNow()
+	}
+	if err := Configure([]string{"INFO", "maybe"}); err == nil {
+		fmt.Println("Configure should fail with unknown redact")
+		t.FailNow()
+	}
+	SetLevel(INFO)
+}
@@ -0,0 +1,133 @@
+// Package paging pages through range queries with bookmarks. The bookmark
+// is simply the last key of the previous page and works the same
+// everywhere, each page starts reading just after it.
+package paging
+
+import (
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 100
+	MAX_PAGE_SIZE     = 1000
+)
+
+/**
+ * Read - collect a page of results from iterator, which must return keys in
+ * ascending order. SimpleKeys and CompositeKeys start the iterator after
+ * the bookmark, any keys up to it are skipped.
+ *
+ * @param bookmark - the bookmark of the previous page, empty for the first
+ * @param pageSize - the maximum number of results, DEFAULT_PAGE_SIZE if 0
+ * @return the results with keys after bookmark and the bookmark of the next
+ *         page, which is empty if there are no more results
+ */
+func Read(iterator shim.StateQueryIteratorInterface, bookmark string, pageSize int) ([]*queryresult.KV, string, error) {
+	defer iterator.Close()
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	var page []*queryresult.KV
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		if bookmark != "" && result.Key <= bookmark {
+			continue
+		}
+		if len(page) == pageSize {
+			return page, page[len(page)-1].Key, nil
+		}
+		page = append(page, result)
+	}
+	return page, "", nil
+}
+
+// after is the first key that sorts after key.
+func after(key string) string {
+	return key + "\x00"
+}
+
+// SimpleKeys iterates over the keys after bookmark that are not composite
+// keys. Peers read an empty range from "\x01" to the end, which leaves out
+// the composite keys. MockStub does not, so the bounds are explicit.
+func SimpleKeys(stub shim.ChaincodeStubInterface, bookmark string) (shim.StateQueryIteratorInterface, error) {
+	start := "\x01"
+	if bookmark != "" {
+		start = after(bookmark)
+	}
+	return stub.GetStateByRange(start, string(utf8.MaxRune))
+}
+
+/**
+ * CompositeKeys - iterate over the composite keys of objectType and
+ * attributes after bookmark, a key of that range
+ *
+ * GetStateByRange refuses composite keys, so the range starts after the
+ * bookmark through the WithPagination query, whose bookmark is the start key
+ * of the range. It is only allowed in read-only transactions. MockStub does
+ * not implement it, there the range starts at the first key and Read skips
+ * the keys up to bookmark.
+ */
+func CompositeKeys(stub shim.ChaincodeStubInterface, objectType string, attributes []string, bookmark string) (shim.StateQueryIteratorInterface, error) {
+	if bookmark == "" {
+		return stub.GetStateByPartialCompositeKey(objectType, attributes)
+	}
+	iterator := &compositeIterator{stub: stub, objectType: objectType, attributes: attributes, bookmark: after(bookmark)}
+	err := iterator.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if iterator.current == nil {
+		return stub.GetStateByPartialCompositeKey(objectType, attributes)
+	}
+	return iterator, nil
+}
+
+// compositeIterator reads a composite key range one WithPagination query of
+// MAX_PAGE_SIZE keys at a time.
+type compositeIterator struct {
+	stub       shim.ChaincodeStubInterface
+	objectType string
+	attributes []string
+	// bookmark starts the next query, empty after the last
+	bookmark string
+	current  shim.StateQueryIteratorInterface
+	err      error
+}
+
+func (c *compositeIterator) fetch() error {
+	iterator, metadata, err := c.stub.GetStateByPartialCompositeKeyWithPagination(c.objectType, c.attributes, MAX_PAGE_SIZE, c.bookmark)
+	if err != nil {
+		return err
+	}
+	c.current = iterator
+	c.bookmark = ""
+	if metadata != nil && metadata.FetchedRecordsCount == MAX_PAGE_SIZE {
+		c.bookmark = metadata.Bookmark
+	}
+	return nil
+}
+
+func (c *compositeIterator) HasNext() bool {
+	for c.err == nil && !c.current.HasNext() && c.bookmark != "" {
+		c.current.Close()
+		c.err = c.fetch()
+	}
+	return c.err != nil || c.current.HasNext()
+}
+
+func (c *compositeIterator) Next() (*queryresult.KV, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.current.Next()
+}
+
+func (c *compositeIterator) Close() error {
+	return c.current.Close()
+}
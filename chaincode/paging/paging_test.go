@@ -0,0 +1,163 @@
+package paging
+
+import (
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func stubWithKeys(t *testing.T, count int) *shim.MockStub {
+	stub := shim.NewMockStub("paging", nil)
+	stub.MockTransactionStart("1")
+	for i := 0; i < count; i++ {
+		key, _ := stub.CreateCompositeKey("row", []string{fmt.Sprintf("%04d", i)})
+		stub.PutState(key, []byte{0x00})
+	}
+	stub.MockTransactionEnd("1")
+	return stub
+}
+
+func Test_PAGING_Read_success(t *testing.T) {
+	stub := stubWithKeys(t, 5)
+
+	var keys []string
+	bookmark, pages := "", 0
+	for {
+		iterator, _ := stub.GetStateByPartialCompositeKey("row", nil)
+		page, next, err := Read(iterator, bookmark, 2)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, result := range page {
+			keys = append(keys, result.Key)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		bookmark = next
+	}
+	if len(keys) != 5 || pages != 3 {
+		t.Errorf("read %d keys in %d pages", len(keys), pages)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			t.Errorf("keys out of order: %q", keys)
+		}
+	}
+}
+
+func Test_PAGING_Read_exact_success(t *testing.T) {
+	stub := stubWithKeys(t, 4)
+
+	// a full last page has no next bookmark
+	iterator, _ := stub.GetStateByPartialCompositeKey("row", nil)
+	page, next, _ := Read(iterator, "", 4)
+	if len(page) != 4 || next != "" {
+		t.Errorf("page of %d with bookmark %q", len(page), next)
+	}
+
+	iterator, _ = stub.GetStateByPartialCompositeKey("row", nil)
+	page, next, _ = Read(iterator, "", 0)
+	if len(page) != 4 || next != "" {
+		t.Errorf("default page of %d with bookmark %q", len(page), next)
+	}
+}
+
+// pagedStub implements the WithPagination query as peers do, the bookmark is
+// the start key, and keeps the keys it read.
+type pagedStub struct {
+	*shim.MockStub
+	read []string
+}
+
+type sliceIterator struct {
+	results []*queryresult.KV
+}
+
+func (s *sliceIterator) HasNext() bool {
+	return len(s.results) > 0
+}
+
+func (s *sliceIterator) Next() (*queryresult.KV, error) {
+	result := s.results[0]
+	s.results = s.results[1:]
+	return result, nil
+}
+
+func (s *sliceIterator) Close() error {
+	return nil
+}
+
+func (p *pagedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	start, _ := p.CreateCompositeKey(objectType, keys)
+	end := start + string(utf8.MaxRune)
+	if bookmark != "" {
+		start = bookmark
+	}
+	iterator := shim.NewMockStateRangeQueryIterator(p.MockStub, start, end)
+	page := &sliceIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for iterator.HasNext() {
+		result, _ := iterator.Next()
+		if metadata.FetchedRecordsCount == pageSize {
+			metadata.Bookmark = result.Key
+			break
+		}
+		p.read = append(p.read, result.Key)
+		page.results = append(page.results, result)
+		metadata.FetchedRecordsCount++
+	}
+	return page, metadata, nil
+}
+
+func Test_PAGING_CompositeKeys_success(t *testing.T) {
+	stub := &pagedStub{MockStub: stubWithKeys(t, 2*MAX_PAGE_SIZE+10)}
+
+	bookmark, _ := stub.CreateCompositeKey("row", []string{"0004"})
+	iterator, _ := CompositeKeys(stub, "row", nil, bookmark)
+	page, next, _ := Read(iterator, bookmark, 3)
+	first, _ := stub.CreateCompositeKey("row", []string{"0005"})
+	if len(page) != 3 || page[0].Key != first {
+		t.Errorf("page of %d starting at %q", len(page), page[0].Key)
+	}
+	for _, key := range stub.read {
+		if key <= bookmark {
+			t.Errorf("read %q up to the bookmark", key)
+		}
+	}
+
+	// the range goes on past a full query
+	stub.read = nil
+	bookmark = next
+	iterator, _ = CompositeKeys(stub, "row", nil, bookmark)
+	page, next, _ = Read(iterator, bookmark, 2*MAX_PAGE_SIZE)
+	if len(page) != 2*MAX_PAGE_SIZE || next == "" {
+		t.Errorf("page of %d with bookmark %q", len(page), next)
+	}
+	for _, key := range stub.read {
+		if key <= bookmark {
+			t.Errorf("read %q up to the bookmark", key)
+		}
+	}
+}
+
+func Test_PAGING_SimpleKeys_success(t *testing.T) {
+	stub := shim.NewMockStub("paging", nil)
+	stub.MockTransactionStart("1")
+	for _, key := range []string{"a", "b", "ba", "c"} {
+		stub.PutState(key, []byte{0x00})
+	}
+	stub.MockTransactionEnd("1")
+
+	iterator, _ := SimpleKeys(stub, "b")
+	page, next, _ := Read(iterator, "b", 0)
+	if len(page) != 2 || page[0].Key != "ba" || next != "" {
+		t.Errorf("page of %d after b with bookmark %q", len(page), next)
+	}
+}
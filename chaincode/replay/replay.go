@@ -0,0 +1,220 @@
+// Package replay feeds a recorded sequence of invocations into a fresh
+// shim.MockStub to reproduce the state and balances they led to. The
+// sequence is a JSONL transaction log or the valid transactions of blocks.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"marbles-meetup/blocks"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Transaction is one line of a transaction log:
+//
+//	{"txId":"tx1","timestamp":"2019-10-01T09:00:00Z","creator":"alice","function":"transferMarbles","args":["redMarbles","alice","bob","20"]}
+//
+// Timestamp is RFC 3339 and defaults to the Unix epoch, Creator is what
+// GetCreator returns during the invocation.
+type Transaction struct {
+	TxID      string   `json:"txId"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	Creator   string   `json:"creator,omitempty"`
+	Function  string   `json:"function"`
+	Args      []string `json:"args"`
+}
+
+func (t *Transaction) input() [][]byte {
+	input := [][]byte{[]byte(t.Function)}
+	for _, arg := range t.Args {
+		input = append(input, []byte(arg))
+	}
+	return input
+}
+
+// LoadLog reads a JSONL transaction log, skipping blank lines.
+func LoadLog(r io.Reader) ([]Transaction, error) {
+	var transactions []Transaction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var transaction Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &transaction); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		if transaction.TxID == "" || transaction.Function == "" {
+			return nil, fmt.Errorf("line %d: txId and function are required", line)
+		}
+		if _, err := transaction.timestamp(); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, scanner.Err()
+}
+
+func (t *Transaction) timestamp() (time.Time, error) {
+	if t.Timestamp == "" {
+		return time.Unix(0, 0), nil
+	}
+	return time.Parse(time.RFC3339Nano, t.Timestamp)
+}
+
+/**
+ * FromBlocks - the transactions of chaincode in blocks that the peers
+ * committed. Invalid transactions are left out since their writes were
+ * discarded.
+ */
+func FromBlocks(parsed []*blocks.Block, chaincode string) []Transaction {
+	var transactions []Transaction
+	for _, block := range parsed {
+		for _, transaction := range block.Transactions {
+			if transaction.Chaincode != chaincode || !transaction.Valid() {
+				continue
+			}
+			transactions = append(transactions, Transaction{
+				TxID:      transaction.TxID,
+				Timestamp: transaction.Timestamp,
+				Function:  transaction.Function,
+				Args:      transaction.Args,
+			})
+		}
+	}
+	return transactions
+}
+
+// Stub is the shim.MockStub a replay runs on. Unlike MockStub it returns
+// the creator of the transaction log from GetCreator and the logged
+// timestamp from GetTxTimestamp.
+type Stub struct {
+	*shim.MockStub
+	cc      shim.Chaincode
+	creator []byte
+	current *Transaction
+}
+
+type replayChaincode struct {
+	stub *Stub
+}
+
+func (r *replayChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return r.stub.cc.Init(r.stub)
+}
+
+func (r *replayChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	// MockInvoke stamps the current time, overwrite it with the logged one
+	timestamp, _ := r.stub.current.timestamp()
+	r.stub.TxTimestamp, _ = ptypes.TimestampProto(timestamp)
+	r.stub.creator = []byte(r.stub.current.Creator)
+	return r.stub.cc.Invoke(r.stub)
+}
+
+func NewStub(name string, cc shim.Chaincode) *Stub {
+	stub := &Stub{cc: cc}
+	stub.MockStub = shim.NewMockStub(name, &replayChaincode{stub})
+	return stub
+}
+
+func (s *Stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+// Outcome is the response of the chaincode to a replayed transaction.
+type Outcome struct {
+	Transaction
+	Status  int32  `json:"status"`
+	Message string `json:"message,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+func (o *Outcome) Success() bool {
+	return o.Status == shim.OK
+}
+
+/**
+ * Replay - run Init and then every transaction on a fresh stub of cc, in
+ * order. Failed invocations leave the state unchanged, as on a peer, and
+ * are reported in the outcomes.
+ *
+ * @param stopAt - the txid of the last transaction to replay, or empty to
+ *                 replay all of them
+ * @return the stub with the resulting state and the outcome of every
+ *         replayed transaction
+ */
+func Replay(cc shim.Chaincode, transactions []Transaction, stopAt string) (*Stub, []Outcome, error) {
+	seen := make(map[string]bool)
+	for _, transaction := range transactions {
+		if seen[transaction.TxID] {
+			return nil, nil, fmt.Errorf("duplicate transaction %s", transaction.TxID)
+		}
+		seen[transaction.TxID] = true
+	}
+	if stopAt != "" && !seen[stopAt] {
+		return nil, nil, fmt.Errorf("transaction %s is not in the log", stopAt)
+	}
+
+	stub := NewStub("replay", cc)
+	if response := stub.MockInit("replay-init", [][]byte{[]byte("init")}); response.Status != shim.OK {
+		return nil, nil, fmt.Errorf("init failed: %s", response.Message)
+	}
+	var outcomes []Outcome
+	for i := range transactions {
+		stub.current = &transactions[i]
+		response := stub.MockInvoke(stub.current.TxID, stub.current.input())
+		// MockStub blocks once its event channel is full
+		for len(stub.ChaincodeEventsChannel) > 0 {
+			<-stub.ChaincodeEventsChannel
+		}
+		outcomes = append(outcomes, Outcome{*stub.current, response.Status, response.Message, string(response.Payload)})
+		if stub.current.TxID == stopAt {
+			break
+		}
+	}
+	return stub, outcomes, nil
+}
+
+// Difference is a key whose value differs between the replayed and a
+// captured state. Expected or Actual is nil if the key is missing there.
+type Difference struct {
+	Key      string
+	Expected []byte
+	Actual   []byte
+}
+
+func (d *Difference) String() string {
+	describe := func(value []byte) string {
+		if value == nil {
+			return "missing"
+		}
+		return blocks.FormatValue(string(value))
+	}
+	return fmt.Sprintf("%s: expected %s, replayed %s", blocks.FormatKey(d.Key), describe(d.Expected), describe(d.Actual))
+}
+
+// Compare lists the keys of actual and expected with different values, in
+// key order.
+func Compare(actual, expected map[string][]byte) []Difference {
+	var differences []Difference
+	for key, value := range expected {
+		if actualValue, exists := actual[key]; !exists || string(actualValue) != string(value) {
+			differences = append(differences, Difference{key, value, actualValue})
+		}
+	}
+	for key, value := range actual {
+		if _, exists := expected[key]; !exists {
+			differences = append(differences, Difference{key, nil, value})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Key < differences[j].Key })
+	return differences
+}
@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"io/ioutil"
+	"marbles-meetup/blocks"
+	"marbles-meetup/high-throughput"
+	"marbles-meetup/inspect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const transactionLog = `{"txId":"tx0","timestamp":"2019-10-01T09:00:00Z","creator":"admin","function":"initMarbles","args":["redMarbles","red","30","1000","alice"]}
+{"txId":"tx1","timestamp":"2019-10-01T09:00:01Z","creator":"alice","function":"transferMarbles","args":["redMarbles","alice","bob","300"]}
+
+{"txId":"tx2","timestamp":"2019-10-01T09:00:02Z","creator":"bob","function":"transferMarbles","args":["redMarbles","bob","carol","500"]}
+{"txId":"tx3","timestamp":"2019-10-01T09:00:03Z","creator":"bob","function":"transferMarbles","args":["redMarbles","bob","carol","100"]}
+`
+
+// witnessChaincode stores the timestamp and creator it is invoked with.
+type witnessChaincode struct{}
+
+func (w *witnessChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (w *witnessChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	timestamp, _ := stub.GetTxTimestamp()
+	creator, _ := stub.GetCreator()
+	stub.PutState(stub.GetTxID(), []byte(ptypes.TimestampString(timestamp)+" "+string(creator)))
+	return shim.Success(nil)
+}
+
+func loadLog(t *testing.T) []Transaction {
+	transactions, err := LoadLog(strings.NewReader(transactionLog))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return transactions
+}
+
+func Test_REPLAY_LoadLog_success(t *testing.T) {
+	transactions := loadLog(t)
+	if len(transactions) != 4 || transactions[1].Creator != "alice" || strings.Join(transactions[1].Args, ",") != "redMarbles,alice,bob,300" {
+		t.Errorf("loaded %+v", transactions)
+	}
+}
+
+func Test_REPLAY_LoadLog_fail(t *testing.T) {
+	for _, log := range []string{
+		`{"txId":"tx0","function":"initMarbles"}` + "\n" + `{"txId":"tx1"`,
+		`{"function":"initMarbles"}`,
+		`{"txId":"tx0","function":"initMarbles","timestamp":"yesterday"}`,
+	} {
+		if _, err := LoadLog(strings.NewReader(log)); err == nil {
+			t.Errorf("loaded %s", log)
+		}
+	}
+}
+
+func Test_REPLAY_Replay_success(t *testing.T) {
+	stub, outcomes, err := Replay(new(highthroughput.HighThroughputChaincode), loadLog(t), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(outcomes) != 4 || !outcomes[1].Success() || outcomes[2].Success() || !outcomes[3].Success() {
+		t.Fatalf("outcomes %+v", outcomes)
+	}
+
+	expected := map[string]int{"alice": 700, "bob": 200, "carol": 100}
+	for _, ledger := range inspect.Decode(stub.State).Ledgers("redMarbles") {
+		if ledger.Balance != expected[ledger.Owner] {
+			t.Errorf("%s has %d, expected %d", ledger.Owner, ledger.Balance, expected[ledger.Owner])
+		}
+	}
+
+	again, _, _ := Replay(new(highthroughput.HighThroughputChaincode), loadLog(t), "")
+	if differences := Compare(again.State, stub.State); len(differences) != 0 {
+		t.Errorf("replays differ: %v", differences)
+	}
+}
+
+func Test_REPLAY_Replay_deterministic_success(t *testing.T) {
+	stub, _, err := Replay(new(witnessChaincode), loadLog(t), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if value := string(stub.State["tx1"]); value != "2019-10-01T09:00:01Z alice" {
+		t.Errorf("tx1 saw %q", value)
+	}
+	if value := string(stub.State["tx3"]); value != "2019-10-01T09:00:03Z bob" {
+		t.Errorf("tx3 saw %q", value)
+	}
+}
+
+func Test_REPLAY_Replay_stopAt_success(t *testing.T) {
+	stub, outcomes, err := Replay(new(highthroughput.HighThroughputChaincode), loadLog(t), "tx1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(outcomes) != 2 || outcomes[1].TxID != "tx1" {
+		t.Errorf("outcomes %+v", outcomes)
+	}
+	for _, ledger := range inspect.Decode(stub.State).Ledgers("") {
+		if ledger.Owner == "carol" || (ledger.Owner == "bob" && ledger.Balance != 300) {
+			t.Errorf("ledger %+v", ledger)
+		}
+	}
+}
+
+func Test_REPLAY_Replay_fail(t *testing.T) {
+	transactions := loadLog(t)
+	if _, _, err := Replay(new(highthroughput.HighThroughputChaincode), transactions, "tx9"); err == nil || !strings.Contains(err.Error(), "tx9") {
+		t.Errorf("replayed up to a missing transaction: %v", err)
+	}
+	transactions = append(transactions, transactions[0])
+	if _, _, err := Replay(new(highthroughput.HighThroughputChaincode), transactions, ""); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("replayed a duplicate transaction: %v", err)
+	}
+}
+
+func Test_REPLAY_FromBlocks_success(t *testing.T) {
+	data, err := ioutil.ReadFile("../blocks/testdata/block_sdk.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	block, err := blocks.ParseBlock(data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// tx2 failed with MVCC_READ_CONFLICT
+	transactions := FromBlocks([]*blocks.Block{block}, "marblehighthroughput")
+	if len(transactions) != 1 || transactions[0].TxID != "tx1" || transactions[0].Function != "transferMarbles" ||
+		transactions[0].Timestamp == "" || strings.Join(transactions[0].Args, ",") != "redMarbles,alice,bob,20000" {
+		t.Errorf("transactions %+v", transactions)
+	}
+	if transactions := FromBlocks([]*blocks.Block{block}, "marbles"); len(transactions) != 0 {
+		t.Errorf("transactions of another chaincode %+v", transactions)
+	}
+}
+
+func Test_REPLAY_Compare_success(t *testing.T) {
+	actual := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "\x00Transfer\x00c\x00": {0x00}}
+	expected := map[string][]byte{"a": []byte("1"), "b": []byte("3"), "d": []byte("4")}
+	differences := Compare(actual, expected)
+	if len(differences) != 3 {
+		t.Fatalf("differences %v", differences)
+	}
+	for i, text := range []string{`Transfer("c"): expected missing, replayed "\x00"`, "b: expected 3, replayed 2", "d: expected 4, replayed missing"} {
+		if differences[i].String() != text {
+			t.Errorf("difference %d is %q, expected %q", i, differences[i].String(), text)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"marbles-meetup/logging"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	FUNCTION_DESCRIBE = "describe"
+
+	TYPE_STRING = "string"
+	TYPE_INT    = "int"
+	TYPE_JSON   = "json"
+	TYPE_BOOL   = "bool"
+)
+
+// Normalizer rewrites a raw argument before it is validated.
+// Name is what describe reports to clients.
+type Normalizer struct {
+	Name  string
+	Apply func(string) string
+}
+
+var Lower = &Normalizer{"lower", strings.ToLower}
+
+func (n *Normalizer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.Name)
+}
+
+// Arg is the schema of a single function argument.
+type Arg struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	Required  bool        `json:"required"`
+	Min       *int        `json:"min,omitempty"`
+	Max       *int        `json:"max,omitempty"`
+	MaxLength *int        `json:"maxLength,omitempty"`
+	Key       bool        `json:"key,omitempty"`
+	Normalize *Normalizer `json:"normalize,omitempty"`
+}
+
+// Handler is called with arguments that already passed the schema.
+type Handler func(stub shim.ChaincodeStubInterface, args Args) pb.Response
+
+// Function binds a function name to its argument schema and handler.
+type Function struct {
+	Name    string  `json:"name"`
+	Args    []Arg   `json:"args"`
+	Handler Handler `json:"-"`
+}
+
+var logger = logging.NewLogger("router")
+
+type Router struct {
+	functions []Function
+	index     map[string]int
+}
+
+// String is a string argument. A required one must not be empty.
+func String(name string, required bool) Arg {
+	return Arg{Name: name, Type: TYPE_STRING, Required: required}
+}
+
+func LowerString(name string, required bool) Arg {
+	return Arg{Name: name, Type: TYPE_STRING, Required: required, Normalize: Lower}
+}
+
+// Key is a string argument that becomes part of a state key. It must not
+// be empty, hold 0x00 or be longer than maxLength bytes.
+func Key(name string, required bool, maxLength int) Arg {
+	return Arg{Name: name, Type: TYPE_STRING, Required: required, MaxLength: Bound(maxLength), Key: true}
+}
+
+func LowerKey(name string, required bool, maxLength int) Arg {
+	return Arg{Name: name, Type: TYPE_STRING, Required: required, MaxLength: Bound(maxLength), Key: true, Normalize: Lower}
+}
+
+func Int(name string, required bool, min *int, max *int) Arg {
+	return Arg{Name: name, Type: TYPE_INT, Required: required, Min: min, Max: max}
+}
+
+// JSON is an argument holding a JSON document. In the object form it may be
+// given as a nested value instead of a string.
+func JSON(name string, required bool) Arg {
+	return Arg{Name: name, Type: TYPE_JSON, Required: required}
+}
+
+func Bool(name string, required bool) Arg {
+	return Arg{Name: name, Type: TYPE_BOOL, Required: required}
+}
+
+func Bound(value int) *int {
+	return &value
+}
+
+func New(functions ...Function) *Router {
+	r := &Router{functions: functions, index: make(map[string]int)}
+	for i, function := range functions {
+		r.index[function.Name] = i
+	}
+	return r
+}
+
+// Handle dispatches the invocation on stub to the matching function, or
+// answers describe with the JSON schema of every registered function.
+// Arguments are either positional strings in schema order or a single
+// JSON object keyed by argument name.
+func (r *Router) Handle(stub shim.ChaincodeStubInterface) pb.Response {
+	function, params := stub.GetFunctionAndParameters()
+	log := logger.ForTx(stub, function)
+	log.Debug("invoke is running")
+
+	if function == FUNCTION_DESCRIBE {
+		return r.describe()
+	}
+
+	i, exists := r.index[function]
+	if !exists {
+		log.Warning("invoke did not find func")
+		return shim.Error("Received unknown function invocation")
+	}
+
+	args, err := r.functions[i].parse(params)
+	if err != nil {
+		log.With("error", err.Error()).Info("invalid arguments")
+		return shim.Error(err.Error())
+	}
+	return r.functions[i].Handler(stub, args)
+}
+
+func (r *Router) describe() pb.Response {
+	schemaBytes, err := json.Marshal(r.functions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(schemaBytes)
+}
+
+func (f *Function) parse(params []string) (Args, error) {
+	// a lone JSON argument is positional, not the object form
+	lone := len(f.Args) == 1 && f.Args[0].Type == TYPE_JSON
+	if len(params) == 1 && !lone {
+		if fields, ok := decodeObject(params[0]); ok {
+			return f.parseObject(fields)
+		}
+	}
+
+	required := 0
+	for _, arg := range f.Args {
+		if arg.Required {
+			required++
+		}
+	}
+	if len(params) < required || len(params) > len(f.Args) {
+		if required == len(f.Args) {
+			return nil, fmt.Errorf("Incorrect number of arguments. Expecting %d", required)
+		}
+		return nil, fmt.Errorf("Incorrect number of arguments. Expecting %d to %d", required, len(f.Args))
+	}
+
+	args := make(Args)
+	for i, param := range params {
+		value, err := f.Args[i].parse(param)
+		if err != nil {
+			return nil, err
+		}
+		args[f.Args[i].Name] = value
+	}
+	return args, nil
+}
+
+// parseObject reads the arguments from the fields of a single JSON object
+// keyed by argument name, e.g. {"name":"RedMarble","amount":20}. Every field
+// is checked so the error lists all invalid fields at once.
+func (f *Function) parseObject(fields map[string]interface{}) (Args, error) {
+	args := make(Args)
+	var errs []string
+	for _, arg := range f.Args {
+		field, exists := fields[arg.Name]
+		delete(fields, arg.Name)
+		if !exists || field == nil {
+			if arg.Required {
+				errs = append(errs, arg.Name+" is required")
+			}
+			continue
+		}
+
+		var param string
+		switch field := field.(type) {
+		case string:
+			param = field
+		case json.Number:
+			if arg.Type != TYPE_INT && arg.Type != TYPE_JSON {
+				errs = append(errs, fmt.Sprintf("%s must be a %s", arg.Name, arg.Type))
+				continue
+			}
+			param = field.String()
+		case bool:
+			if arg.Type != TYPE_BOOL && arg.Type != TYPE_JSON {
+				errs = append(errs, fmt.Sprintf("%s must be a %s", arg.Name, arg.Type))
+				continue
+			}
+			param = strconv.FormatBool(field)
+		default:
+			if arg.Type == TYPE_JSON {
+				fieldBytes, _ := json.Marshal(field)
+				param = string(fieldBytes)
+				break
+			}
+			errs = append(errs, fmt.Sprintf("%s must be a %s", arg.Name, arg.Type))
+			continue
+		}
+
+		value, err := arg.parse(param)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		args[arg.Name] = value
+	}
+
+	var unknown []string
+	for name := range fields {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, name+" is not an argument")
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Invalid arguments: %s", strings.Join(errs, "; "))
+	}
+	return args, nil
+}
+
+// decodeObject decodes param if it holds exactly one JSON object and nothing
+// after it. Anything else, e.g. a marble named {x}, is a positional argument.
+func decodeObject(param string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(param), "{") {
+		return nil, false
+	}
+	fields := make(map[string]interface{})
+	decoder := json.NewDecoder(strings.NewReader(param))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+	return fields, true
+}
+
+func (a *Arg) parse(param string) (interface{}, error) {
+	if a.Normalize != nil {
+		param = a.Normalize.Apply(param)
+	}
+
+	switch a.Type {
+	case TYPE_STRING:
+		if (a.Key || a.Required) && param == "" {
+			return nil, fmt.Errorf("%s cannot be empty", a.Name)
+		}
+		if a.Key && strings.Contains(param, "\x00") {
+			return nil, fmt.Errorf("%s cannot contain 0x00", a.Name)
+		}
+		if a.MaxLength != nil && len(param) > *a.MaxLength {
+			return nil, fmt.Errorf("%s cannot be longer than %d bytes", a.Name, *a.MaxLength)
+		}
+		return param, nil
+	case TYPE_INT:
+		value, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a numeric string", a.Name)
+		}
+		if a.Min != nil && value < *a.Min {
+			return nil, fmt.Errorf("%s cannot be less than %d", a.Name, *a.Min)
+		}
+		if a.Max != nil && value > *a.Max {
+			return nil, fmt.Errorf("%s cannot be greater than %d", a.Name, *a.Max)
+		}
+		return value, nil
+	case TYPE_JSON:
+		if !json.Valid([]byte(param)) {
+			return nil, fmt.Errorf("%s must be JSON", a.Name)
+		}
+		return param, nil
+	case TYPE_BOOL:
+		value, err := strconv.ParseBool(param)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", a.Name)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s has unknown type %s", a.Name, a.Type)
+}
+
+// Args holds parsed arguments by schema name. Optional arguments that were
+// not given are absent.
+type Args map[string]interface{}
+
+func (a Args) Has(name string) bool {
+	_, exists := a[name]
+	return exists
+}
+
+func (a Args) String(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+func (a Args) Int(name string) int {
+	value, _ := a[name].(int)
+	return value
+}
+
+func (a Args) Bool(name string) bool {
+	value, _ := a[name].(bool)
+	return value
+}
@@ -0,0 +1,222 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"marbles-meetup/util"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var echoArgs = []Arg{
+	String("name", true),
+	LowerString("owner", true),
+	Int("amount", true, Bound(0), Bound(100)),
+	String("memo", false),
+}
+
+type echoChaincode struct {
+}
+
+func (t *echoChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *echoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return New(
+		Function{Name: "echo", Args: echoArgs, Handler: t.echo},
+	).Handle(stub)
+}
+
+func (t *echoChaincode) echo(stub shim.ChaincodeStubInterface, args Args) pb.Response {
+	result := fmt.Sprintf("%s/%s/%d/%t", args.String("name"), args.String("owner"), args.Int("amount"), args.Has("memo"))
+	return shim.Success([]byte(result))
+}
+
+func checkError(t *testing.T, stub *shim.MockStub, args [][]byte, expect string) {
+	res := stub.MockInvoke("1", args)
+	if res.Status == shim.OK {
+		fmt.Println("Invoke should fail with", expect)
+		t.FailNow()
+	}
+	if res.Message != expect {
+		fmt.Println("Error", res.Message, "was not", expect, "as expected")
+		t.FailNow()
+	}
+}
+
+func Test_ROUTER_handle_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(echoChaincode))
+
+	arguments := [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("Alice"), []byte("30")}
+	util.CheckQuery(t, stub, arguments, "RedMarble/alice/30/false", "1")
+
+	arguments = [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("Alice"), []byte("30"), []byte("gift")}
+	util.CheckQuery(t, stub, arguments, "RedMarble/alice/30/true", "1")
+
+	// only required strings must not be empty
+	arguments = [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("Alice"), []byte("30"), []byte("")}
+	util.CheckQuery(t, stub, arguments, "RedMarble/alice/30/true", "1")
+}
+
+func Test_ROUTER_handle_fail(t *testing.T) {
+	stub := shim.NewMockStub("router", new(echoChaincode))
+
+	checkError(t, stub, [][]byte{[]byte("unknown")}, "Received unknown function invocation")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte("RedMarble")}, "Incorrect number of arguments. Expecting 3 to 4")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("alice"), []byte("many")}, "amount must be a numeric string")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("alice"), []byte("-1")}, "amount cannot be less than 0")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte("RedMarble"), []byte("alice"), []byte("101")}, "amount cannot be greater than 100")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte(""), []byte("alice"), []byte("30")}, "name cannot be empty")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte(`{"name":"RedMarble","owner":"","amount":30}`)}, "Invalid arguments: owner cannot be empty")
+}
+
+func Test_ROUTER_describe_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(echoChaincode))
+
+	expected := []Function{{Name: "echo", Args: echoArgs}}
+	expectedBytes, _ := json.Marshal(expected)
+	util.CheckQuery(t, stub, [][]byte{[]byte(FUNCTION_DESCRIBE)}, string(expectedBytes), "1")
+
+	described := []map[string]interface{}{}
+	res := stub.MockInvoke("1", [][]byte{[]byte(FUNCTION_DESCRIBE)})
+	json.Unmarshal(res.Payload, &described)
+	owner := described[0]["args"].([]interface{})[1].(map[string]interface{})
+	if owner["normalize"] != "lower" {
+		fmt.Println("owner normalize", owner["normalize"], "was not lower as expected")
+		t.FailNow()
+	}
+}
+
+func Test_ROUTER_handle_object_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(echoChaincode))
+
+	arguments := [][]byte{[]byte("echo"), []byte(`{"amount":30,"owner":"Alice","name":"RedMarble"}`)}
+	util.CheckQuery(t, stub, arguments, "RedMarble/alice/30/false", "1")
+
+	arguments = [][]byte{[]byte("echo"), []byte(`{"name":"RedMarble","owner":"alice","amount":"30","memo":"gift"}`)}
+	util.CheckQuery(t, stub, arguments, "RedMarble/alice/30/true", "1")
+}
+
+func Test_ROUTER_handle_object_fail(t *testing.T) {
+	stub := shim.NewMockStub("router", new(echoChaincode))
+
+	// anything but exactly one JSON object is a positional argument
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte(`{"name":"RedMarble"`)},
+		"Incorrect number of arguments. Expecting 3 to 4")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte(`{"name":"RedMarble","owner":"alice","amount":30} {}`)},
+		"Incorrect number of arguments. Expecting 3 to 4")
+	checkError(t, stub, [][]byte{[]byte("echo"), []byte(`{"name":1,"amount":-1,"color":"red"}`)},
+		"Invalid arguments: name must be a string; owner is required; amount cannot be less than 0; color is not an argument")
+}
+
+type documentChaincode struct {
+}
+
+func (t *documentChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *documentChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	echo := func(stub shim.ChaincodeStubInterface, args Args) pb.Response {
+		return shim.Success([]byte(args.String("document") + "/" + args.String("name")))
+	}
+	return New(
+		Function{Name: "lone", Args: []Arg{JSON("document", true)}, Handler: echo},
+		Function{Name: "named", Args: []Arg{String("name", true), JSON("document", true)}, Handler: echo},
+		Function{Name: "name", Args: []Arg{String("name", true)}, Handler: echo},
+	).Handle(stub)
+}
+
+func Test_ROUTER_handle_json_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(documentChaincode))
+
+	// a lone JSON argument is positional even if it is an object
+	arguments := [][]byte{[]byte("lone"), []byte(`{"rows":[1,2]}`)}
+	util.CheckQuery(t, stub, arguments, `{"rows":[1,2]}/`, "1")
+
+	arguments = [][]byte{[]byte("named"), []byte(`{"name":"RedMarble","document":{"rows":[1,2.5]}}`)}
+	util.CheckQuery(t, stub, arguments, `{"rows":[1,2.5]}/RedMarble`, "1")
+
+	arguments = [][]byte{[]byte("named"), []byte("RedMarble"), []byte(`[1]`)}
+	util.CheckQuery(t, stub, arguments, `[1]/RedMarble`, "1")
+
+	// a name that only looks like an object is positional
+	arguments = [][]byte{[]byte("name"), []byte(`{x}`)}
+	util.CheckQuery(t, stub, arguments, `/{x}`, "1")
+
+	arguments = [][]byte{[]byte("name"), []byte(`{"name":"RedMarble"}`)}
+	util.CheckQuery(t, stub, arguments, `/RedMarble`, "1")
+}
+
+func Test_ROUTER_handle_json_fail(t *testing.T) {
+	stub := shim.NewMockStub("router", new(documentChaincode))
+
+	checkError(t, stub, [][]byte{[]byte("lone"), []byte(`{"rows":`)}, "document must be JSON")
+	checkError(t, stub, [][]byte{[]byte("named"), []byte(`{"name":"RedMarble","document":"{"}`)},
+		"Invalid arguments: document must be JSON")
+}
+
+type flagChaincode struct {
+}
+
+func (t *flagChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *flagChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	echo := func(stub shim.ChaincodeStubInterface, args Args) pb.Response {
+		return shim.Success([]byte(fmt.Sprintf("%t/%t", args.Bool("flag"), args.Has("flag"))))
+	}
+	return New(Function{Name: "flag", Args: []Arg{Bool("flag", false)}, Handler: echo}).Handle(stub)
+}
+
+func Test_ROUTER_handle_bool_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(flagChaincode))
+
+	util.CheckQuery(t, stub, [][]byte{[]byte("flag"), []byte("true")}, "true/true", "1")
+	util.CheckQuery(t, stub, [][]byte{[]byte("flag"), []byte(`{"flag":false}`)}, "false/true", "1")
+	util.CheckQuery(t, stub, [][]byte{[]byte("flag"), []byte(`{"flag":"1"}`)}, "true/true", "1")
+	util.CheckQuery(t, stub, [][]byte{[]byte("flag")}, "false/false", "1")
+}
+
+func Test_ROUTER_handle_bool_fail(t *testing.T) {
+	stub := shim.NewMockStub("router", new(flagChaincode))
+
+	checkError(t, stub, [][]byte{[]byte("flag"), []byte("yes")}, "flag must be true or false")
+	checkError(t, stub, [][]byte{[]byte("flag"), []byte(`{"flag":1}`)}, "Invalid arguments: flag must be a bool")
+}
+
+type keyChaincode struct {
+}
+
+func (t *keyChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *keyChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	echo := func(stub shim.ChaincodeStubInterface, args Args) pb.Response {
+		return shim.Success([]byte(args.String("owner") + "/" + args.String("name")))
+	}
+	keyArgs := []Arg{LowerKey("owner", true, 5), Key("name", false, 10)}
+	return New(Function{Name: "key", Args: keyArgs, Handler: echo}).Handle(stub)
+}
+
+func Test_ROUTER_handle_key_success(t *testing.T) {
+	stub := shim.NewMockStub("router", new(keyChaincode))
+
+	util.CheckQuery(t, stub, [][]byte{[]byte("key"), []byte("Alice"), []byte("RedMarble")}, "alice/RedMarble", "1")
+	util.CheckQuery(t, stub, [][]byte{[]byte("key"), []byte("bob")}, "bob/", "1")
+}
+
+func Test_ROUTER_handle_key_fail(t *testing.T) {
+	stub := shim.NewMockStub("router", new(keyChaincode))
+
+	checkError(t, stub, [][]byte{[]byte("key"), []byte("")}, "owner cannot be empty")
+	checkError(t, stub, [][]byte{[]byte("key"), []byte("alice"), []byte("")}, "name cannot be empty")
+	checkError(t, stub, [][]byte{[]byte("key"), []byte("al\x00ce")}, "owner cannot contain 0x00")
+	checkError(t, stub, [][]byte{[]byte("key"), []byte("alice"), []byte("RedMarbles!")}, "name cannot be longer than 10 bytes")
+	checkError(t, stub, [][]byte{[]byte("key"), []byte(`{"owner":"carol","name":""}`)}, "Invalid arguments: name cannot be empty")
+}
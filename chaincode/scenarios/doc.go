@@ -0,0 +1,4 @@
+// Package scenarios holds YAML test scenarios for the marble chaincodes.
+// Every *.yaml file in this directory runs with go test; see util.Scenario
+// for the format.
+package scenarios
@@ -0,0 +1,21 @@
+package scenarios
+
+import (
+	"marbles-meetup/general"
+	"marbles-meetup/high-throughput"
+	"marbles-meetup/high-throughput-phantom"
+	"marbles-meetup/util"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var chaincodes = map[string]func() shim.Chaincode{
+	"general":               func() shim.Chaincode { return new(general.SimpleChaincode) },
+	"highThroughput":        func() shim.Chaincode { return new(highthroughput.HighThroughputChaincode) },
+	"highThroughputPhantom": func() shim.Chaincode { return new(highthroughputphantom.HighThroughputChaincode) },
+}
+
+func Test_SCENARIOS(t *testing.T) {
+	util.RunScenarios(t, "*.yaml", chaincodes)
+}
@@ -0,0 +1,248 @@
+// Package snapshot exports the state of a marble of the high throughput
+// chaincodes as portable JSON pages and imports them into another
+// namespace, e.g. to move a marble to another channel or to seed a test
+// network.
+//
+// A snapshot holds the rows of one marble in key order: the delta rows,
+// including the checkpoint rows pruneMarbles writes, and the marble record
+// last under the bare marble name, wherever the catalogue stores it. Since
+// an import writes the marble record only with the last page, the marble
+// cannot be used before it is complete. Rows keep their key-level
+// endorsement policy, so a marble with its own endorsers keeps them.
+//
+// The hash of a page is a checksum against pages damaged on the way. It is
+// not signed, so whoever edits a page can compute it again: only import
+// pages exported by a peer you trust.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"marbles-meetup/catalogue"
+	"marbles-meetup/paging"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+)
+
+const VERSION = 1
+
+// Row is a key of the marble. Policy is its validation parameter, none if
+// the chaincode endorsement policy applies.
+type Row struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Policy []byte `json:"policy,omitempty"`
+}
+
+// Snapshot is a page of the rows of the marble Name after the bookmark
+// From. Bookmark is where the next page starts, or empty for the last page.
+type Snapshot struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	From     string `json:"from"`
+	Rows     []Row  `json:"rows"`
+	Bookmark string `json:"bookmark"`
+	Hash     string `json:"hash"`
+}
+
+// Digest is the checksum of the page, the hex SHA-256 of every field but
+// Hash, each prefixed with its length.
+func (s *Snapshot) Digest() string {
+	hash := sha256.New()
+	write := func(field string) {
+		fmt.Fprintf(hash, "%d:%s", len(field), field)
+	}
+	write(strconv.Itoa(s.Version))
+	write(s.Name)
+	write(s.From)
+	write(strconv.Itoa(len(s.Rows)))
+	for _, row := range s.Rows {
+		write(row.Key)
+		write(row.Value)
+		write(string(row.Policy))
+	}
+	write(s.Bookmark)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/**
+ * Export - read a page of the rows of a marble
+ *
+ * @param objectType - the object type of the delta rows, KEY_TRANSFER
+ * @param bookmark - the Bookmark of the previous page, empty for the first
+ * @return the sealed page
+ */
+func Export(stub shim.ChaincodeStubInterface, objectType, name string, pageSize int, bookmark string) (*Snapshot, error) {
+	marbleAsBytes, err := catalogue.Get(stub, name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get marble: %s", err.Error())
+	} else if marbleAsBytes == nil {
+		return nil, fmt.Errorf("Marble does not exist")
+	}
+	prefix, err := stub.CreateCompositeKey(objectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	if bookmark != "" && !isDeltaKey(bookmark, prefix) {
+		return nil, fmt.Errorf("Invalid bookmark for marble %s", name)
+	}
+	if pageSize <= 0 {
+		pageSize = paging.DEFAULT_PAGE_SIZE
+	}
+
+	iterator, err := paging.CompositeKeys(stub, objectType, []string{name}, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	page, next, err := paging.Read(iterator, bookmark, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Version: VERSION, Name: name, From: bookmark, Rows: []Row{}, Bookmark: next}
+	for _, result := range page {
+		policy, err := stub.GetStateValidationParameter(result.Key)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Rows = append(snapshot.Rows, Row{result.Key, string(result.Value), policy})
+	}
+	// the marble record sorts after the delta rows
+	if next == "" {
+		if len(snapshot.Rows) < pageSize {
+			marbleKey, err := catalogue.Key(stub, name)
+			if err != nil {
+				return nil, err
+			}
+			policy, err := stub.GetStateValidationParameter(marbleKey)
+			if err != nil {
+				return nil, err
+			}
+			snapshot.Rows = append(snapshot.Rows, Row{name, string(marbleAsBytes), policy})
+		} else {
+			snapshot.Bookmark = snapshot.Rows[len(snapshot.Rows)-1].Key
+		}
+	}
+	snapshot.Hash = snapshot.Digest()
+	return snapshot, nil
+}
+
+func isDeltaKey(key, prefix string) bool {
+	return len(key) > len(prefix) && key[:len(prefix)] == prefix
+}
+
+/**
+ * Import - write a page of a snapshot. The pages must be imported in order
+ * into a namespace without the marble: the first page only if the marble
+ * has no rows, every other page only if the last row is its From bookmark.
+ * Callers check that the creator may import.
+ */
+func Import(stub shim.ChaincodeStubInterface, objectType string, snapshot *Snapshot) error {
+	if snapshot.Version != VERSION {
+		return fmt.Errorf("Unsupported snapshot version %d", snapshot.Version)
+	}
+	if snapshot.Hash != snapshot.Digest() {
+		return fmt.Errorf("Snapshot checksum mismatch")
+	}
+	if err := validate(stub, objectType, snapshot); err != nil {
+		return err
+	}
+
+	marbleAsBytes, err := catalogue.Get(stub, snapshot.Name)
+	if err != nil {
+		return fmt.Errorf("Failed to get marble: %s", err.Error())
+	} else if marbleAsBytes != nil {
+		return fmt.Errorf("This marble already exists: %s", snapshot.Name)
+	}
+	last := ""
+	iterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{snapshot.Name})
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	for iterator.HasNext() {
+		result, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		last = result.Key
+	}
+	if last != snapshot.From {
+		return fmt.Errorf("Snapshot page does not continue the import of %s", snapshot.Name)
+	}
+
+	for _, row := range snapshot.Rows {
+		key := row.Key
+		if row.Key == snapshot.Name {
+			key, err = catalogue.Key(stub, row.Key)
+			if err != nil {
+				return err
+			}
+		}
+		err = stub.PutState(key, []byte(row.Value))
+		if err != nil {
+			return err
+		}
+		if len(row.Policy) > 0 {
+			err = stub.SetStateValidationParameter(key, row.Policy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks that snapshot only holds rows of its marble, in order,
+// with the marble record last and only on the last page.
+func validate(stub shim.ChaincodeStubInterface, objectType string, snapshot *Snapshot) error {
+	prefix, err := stub.CreateCompositeKey(objectType, []string{snapshot.Name})
+	if err != nil {
+		return err
+	}
+	if snapshot.From != "" && !isDeltaKey(snapshot.From, prefix) {
+		return fmt.Errorf("Invalid snapshot bookmark")
+	}
+	previous := snapshot.From
+	complete := false
+	for i, row := range snapshot.Rows {
+		if row.Key <= previous {
+			return fmt.Errorf("Snapshot row %d is out of order", i)
+		}
+		previous = row.Key
+		if len(row.Policy) > 0 {
+			if _, err := statebased.NewStateEP(row.Policy); err != nil {
+				return fmt.Errorf("Snapshot row %d has an invalid policy", i)
+			}
+		}
+
+		if row.Key == snapshot.Name && i == len(snapshot.Rows)-1 {
+			record := struct {
+				ObjectType string `json:"docType"`
+				Name       string `json:"name"`
+			}{}
+			if err := json.Unmarshal([]byte(row.Value), &record); err != nil || record.ObjectType != "marble" || record.Name != snapshot.Name {
+				return fmt.Errorf("Snapshot row %d is not the record of marble %s", i, snapshot.Name)
+			}
+			complete = true
+			continue
+		}
+		if !isDeltaKey(row.Key, prefix) || row.Value != "\x00" {
+			return fmt.Errorf("Snapshot row %d is not a delta row of marble %s", i, snapshot.Name)
+		}
+		_, attributes, err := stub.SplitCompositeKey(row.Key)
+		if err != nil || len(attributes) != 5 {
+			return fmt.Errorf("Snapshot row %d is not a delta row of marble %s", i, snapshot.Name)
+		}
+		if _, err := strconv.Atoi(attributes[3]); err != nil {
+			return fmt.Errorf("Snapshot row %d has an invalid amount", i)
+		}
+	}
+	if complete != (snapshot.Bookmark == "") {
+		return fmt.Errorf("Only the last snapshot page holds the marble record")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const transferKey = "\x00Transfer\x00RedMarble\x00\x00alice\x00100\x00tx0\x00"
+
+func Test_SNAPSHOT_Digest_success(t *testing.T) {
+	snapshot := &Snapshot{Version: VERSION, Name: "RedMarble", Rows: []Row{{transferKey, "\x00", nil}}}
+	digest := snapshot.Digest()
+
+	// moving a byte between fields changes the digest
+	moved := &Snapshot{Version: VERSION, Name: "RedMarbl", From: "e", Rows: []Row{{transferKey, "\x00", nil}}}
+	if moved.Digest() == digest {
+		t.Errorf("digest ignores field boundaries")
+	}
+	snapshot.Hash = "anything"
+	if snapshot.Digest() != digest {
+		t.Errorf("digest covers the hash")
+	}
+
+	endorsed := &Snapshot{Version: VERSION, Name: "RedMarble", Rows: []Row{{transferKey, "\x00", []byte("policy")}}}
+	if endorsed.Digest() == digest {
+		t.Errorf("digest ignores the policy")
+	}
+}
+
+func Test_SNAPSHOT_Import_fail(t *testing.T) {
+	stub := shim.NewMockStub("snapshot", nil)
+	stub.MockTransactionStart("1")
+	defer stub.MockTransactionEnd("1")
+
+	for expected, snapshot := range map[string]*Snapshot{
+		"Unsupported snapshot version 2":       {Version: 2, Name: "RedMarble"},
+		"Snapshot row 1 is out of order":       {Version: VERSION, Name: "RedMarble", Rows: []Row{{transferKey, "\x00", nil}, {transferKey, "\x00", nil}}},
+		"Snapshot row 0 has an invalid policy": {Version: VERSION, Name: "RedMarble", Rows: []Row{{transferKey, "\x00", []byte("\xff")}}},
+		"Snapshot row 0 has an invalid amount": {Version: VERSION, Name: "RedMarble",
+			Rows: []Row{{"\x00Transfer\x00RedMarble\x00\x00alice\x00many\x00tx0\x00", "\x00", nil}}},
+		"Snapshot row 0 is not a delta row of marble RedMarble": {Version: VERSION, Name: "RedMarble",
+			Rows: []Row{{"\x00Transfer\x00BlueMarble\x00\x00alice\x00100\x00tx0\x00", "\x00", nil}}},
+		"Snapshot row 1 is not the record of marble RedMarble": {Version: VERSION, Name: "RedMarble",
+			Rows: []Row{{transferKey, "\x00", nil}, {"RedMarble", `{"docType":"marble","name":"BlueMarble"}`, nil}}},
+		"Only the last snapshot page holds the marble record": {Version: VERSION, Name: "RedMarble", Rows: []Row{{transferKey, "\x00", nil}}},
+	} {
+		snapshot.Hash = snapshot.Digest()
+		if err := Import(stub, "Transfer", snapshot); err == nil || err.Error() != expected {
+			t.Errorf("import failed with %v, expected %q", err, expected)
+		}
+	}
+	if len(stub.State) != 0 {
+		t.Errorf("failed imports wrote %d keys", len(stub.State))
+	}
+}
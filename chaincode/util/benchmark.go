@@ -0,0 +1,123 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const benchmarkMarble = "BenchMarble"
+
+// BenchmarkState is the ledger a benchmark runs against: Owners holders of
+// a single marble, created by the first owner, and Deltas transfers from the
+// first owner to the others that were not pruned.
+type BenchmarkState struct {
+	Owners int
+	Deltas int
+}
+
+var BenchmarkStates = []BenchmarkState{
+	{Owners: 10, Deltas: 10},
+	{Owners: 10, Deltas: 100},
+	{Owners: 10, Deltas: 1000},
+	{Owners: 100, Deltas: 100},
+	{Owners: 100, Deltas: 1000},
+}
+
+func (s BenchmarkState) String() string {
+	return fmt.Sprintf("owners=%d/deltas=%d", s.Owners, s.Deltas)
+}
+
+func (s BenchmarkState) owner(i int) string {
+	return "owner" + strconv.Itoa(i)
+}
+
+func (s BenchmarkState) setUp(b *testing.B, target Target) *RecordingStub {
+	b.Helper()
+	stub := NewRecordingStub(target.Name, target.Chaincode())
+	stub.MockInit("init", [][]byte{[]byte("init")})
+	ops := []Op{{Kind: OP_INIT, Marble: benchmarkMarble, Owner: s.owner(0), Amount: 1000000}}
+	for i := 0; i < s.Deltas; i++ {
+		ops = append(ops, Op{Kind: OP_TRANSFER, Marble: benchmarkMarble, Owner: s.owner(0),
+			Receiver: s.owner(1 + i%(s.Owners-1)), Amount: 1})
+	}
+	for i, op := range ops {
+		if res := stub.MockInvoke("setup"+strconv.Itoa(i), op.Args(target.Functions)); res.Status != shim.OK {
+			b.Fatalf("Set up %s (%s) failed: %s", s, op, res.Message)
+		}
+		DrainEvents(stub.MockStub)
+	}
+	stub.Accesses = nil
+	return stub
+}
+
+// benchmarkOp is the op measured per kind. Reads and transfers start from
+// the first owner, which has a delta row for every transfer.
+func (s BenchmarkState) benchmarkOp(kind OpKind) Op {
+	switch kind {
+	case OP_INIT:
+		return Op{Kind: OP_INIT, Marble: "NewMarble", Owner: s.owner(0), Amount: 100}
+	case OP_TRANSFER:
+		return Op{Kind: OP_TRANSFER, Marble: benchmarkMarble, Owner: s.owner(0), Receiver: s.owner(1), Amount: 1}
+	case OP_READ:
+		return Op{Kind: OP_READ, Marble: benchmarkMarble, Owner: s.owner(0)}
+	}
+	return Op{Kind: kind, Marble: benchmarkMarble}
+}
+
+// StateSize is the number of keys in stub and the bytes of their keys and
+// values.
+func StateSize(stub *shim.MockStub) (keys int, bytes int) {
+	for key, value := range stub.State {
+		keys++
+		bytes += len(key) + len(value)
+	}
+	return keys, bytes
+}
+
+/**
+ * BenchmarkFunction - benchmark one marble function of target on every
+ * BenchmarkStates entry. Every invocation is reverted outside the timer, so
+ * the ledger keeps the same shape for all b.N iterations. Besides time and
+ * allocations it reports
+ *	- state-keys and state-bytes of the ledger before the invocation
+ *	- keys-touched/op, the keys read, scanned by range, written or deleted
+ *
+ * @param kind the op kind of the function, OP_PRUNE is skipped on targets without pruneMarbles
+ */
+func BenchmarkFunction(b *testing.B, target Target, kind OpKind) {
+	if !target.runs(Op{Kind: kind}) {
+		b.Skipf("%s has no %s", target.Name, kind)
+	}
+	for _, state := range BenchmarkStates {
+		state := state
+		b.Run(state.String(), func(b *testing.B) {
+			stub := state.setUp(b, target)
+			keys, bytes := StateSize(stub.MockStub)
+			args := state.benchmarkOp(kind).Args(target.Functions)
+			touched := 0
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res := stub.MockInvoke("bench"+strconv.Itoa(i), args)
+				b.StopTimer()
+				DrainEvents(stub.MockStub)
+				if res.Status != shim.OK {
+					b.Fatalf("Invoke (%s) failed: %s", convertArgToString(args), res.Message)
+				}
+				touched += stub.LastAccess().Touched()
+				stub.RevertLast()
+				stub.Accesses = nil
+				b.StartTimer()
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(keys), "state-keys")
+			b.ReportMetric(float64(bytes), "state-bytes")
+			b.ReportMetric(float64(touched)/float64(b.N), "keys-touched/op")
+		})
+	}
+}
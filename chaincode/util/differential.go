@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ReadDiff is a readMarbles query that answered differently on two targets.
+type ReadDiff struct {
+	Marble string
+	Owner  string
+	A      string
+	B      string
+}
+
+// Divergence is the first step after which two targets disagree.
+type Divergence struct {
+	A, B    string
+	Step    int
+	Op      Op
+	StatusA string
+	StatusB string
+	Reads   []ReadDiff
+}
+
+func describeResponse(status int32, message string) string {
+	if status == shim.OK {
+		return "OK"
+	}
+	return fmt.Sprintf("%d %s", status, message)
+}
+
+// Report renders the divergence with both sides aligned per query.
+func (d *Divergence) Report() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%s and %s diverge at step %d: %s", d.A, d.B, d.Step, d.Op))
+	if d.StatusA != d.StatusB {
+		lines = append(lines, fmt.Sprintf("  status  %-24s %s", d.A+":", d.StatusA))
+		lines = append(lines, fmt.Sprintf("          %-24s %s", d.B+":", d.StatusB))
+	}
+	for _, read := range d.Reads {
+		lines = append(lines, fmt.Sprintf("  readMarbles %s %s", read.Marble, read.Owner))
+		lines = append(lines, fmt.Sprintf("          %-24s %s", d.A+":", read.A))
+		lines = append(lines, fmt.Sprintf("          %-24s %s", d.B+":", read.B))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func readAll(stub *shim.MockStub, functions Functions, marbles, owners []string) map[[2]string]string {
+	results := make(map[[2]string]string)
+	for _, marble := range marbles {
+		for _, owner := range owners {
+			res := stub.MockInvoke("read", Op{Kind: OP_READ, Marble: marble, Owner: owner}.Args(functions))
+			if res.Status == shim.OK {
+				results[[2]string{marble, owner}] = string(res.Payload)
+			} else {
+				results[[2]string{marble, owner}] = describeResponse(res.Status, res.Message)
+			}
+		}
+	}
+	return results
+}
+
+/**
+ * DiffWorkload - replay ops on a fresh stub of each target and compare them
+ * after every step: the status of the step and the readMarbles output of
+ * every marble and owner in config. Prune steps only run on targets that
+ * support them, pruning must not change any balance.
+ *
+ * @return the first divergence, or nil if both targets agree throughout
+ */
+func DiffWorkload(a, b Target, config WorkloadConfig, ops []Op) *Divergence {
+	stubA := shim.NewMockStub(a.Name, a.Chaincode())
+	stubA.MockInit("init", [][]byte{[]byte("init")})
+	stubB := shim.NewMockStub(b.Name, b.Chaincode())
+	stubB.MockInit("init", [][]byte{[]byte("init")})
+
+	for step, op := range ops {
+		txID := "tx" + strconv.Itoa(step)
+		statusA, statusB := "skipped", "skipped"
+		if a.runs(op) {
+			res := stubA.MockInvoke(txID, op.Args(a.Functions))
+			DrainEvents(stubA)
+			statusA = describeResponse(res.Status, res.Message)
+		}
+		if b.runs(op) {
+			res := stubB.MockInvoke(txID, op.Args(b.Functions))
+			DrainEvents(stubB)
+			statusB = describeResponse(res.Status, res.Message)
+		}
+
+		divergence := &Divergence{A: a.Name, B: b.Name, Step: step, Op: op, StatusA: statusA, StatusB: statusB}
+		if statusA == "skipped" || statusB == "skipped" {
+			divergence.StatusA, divergence.StatusB = "", ""
+		} else if (statusA == "OK") != (statusB == "OK") {
+			return divergence
+		}
+
+		readsA := readAll(stubA, a.Functions, config.Marbles, config.Owners)
+		readsB := readAll(stubB, b.Functions, config.Marbles, config.Owners)
+		for query, resultA := range readsA {
+			if resultB := readsB[query]; resultA != resultB {
+				divergence.Reads = append(divergence.Reads, ReadDiff{query[0], query[1], resultA, resultB})
+			}
+		}
+		if len(divergence.Reads) > 0 {
+			sort.Slice(divergence.Reads, func(i, j int) bool {
+				if divergence.Reads[i].Marble != divergence.Reads[j].Marble {
+					return divergence.Reads[i].Marble < divergence.Reads[j].Marble
+				}
+				return divergence.Reads[i].Owner < divergence.Reads[j].Owner
+			})
+			return divergence
+		}
+	}
+	return nil
+}
+
+// CheckDifferential replays a generated workload per seed on both targets
+// and prints the divergence report of the first seed they disagree on.
+func CheckDifferential(t *testing.T, a, b Target, config WorkloadConfig, seeds ...int64) {
+	t.Helper()
+	for _, seed := range seeds {
+		divergence := DiffWorkload(a, b, config, GenerateWorkload(seed, config))
+		if divergence != nil {
+			t.Fatalf("Workload seed %d: %s", seed, divergence.Report())
+		}
+	}
+}
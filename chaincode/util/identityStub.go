@@ -0,0 +1,94 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Identity returns a serialized identity of mspID as GetCreator returns
+// it on a peer: a self-signed certificate for commonName carrying attrs the
+// way fabric-ca enrolls them.
+func Identity(mspID, commonName string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if len(attrs) > 0 {
+		attrsBytes, _ := json.Marshal(&attrmgr.Attributes{Attrs: attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: attrmgr.AttrOID, Value: attrsBytes}}
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	creator, _ := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	return creator
+}
+
+// IdentityStub is a shim.MockStub whose GetCreator returns Creator, which
+// the plain MockStub cannot. If Now is set, GetTxTimestamp returns it
+// instead of the time MockInvoke stamps.
+type IdentityStub struct {
+	*shim.MockStub
+	cc      shim.Chaincode
+	Creator []byte
+	Now     time.Time
+}
+
+type identityChaincode struct {
+	stub *IdentityStub
+}
+
+func (i *identityChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return i.stub.cc.Init(i.stub)
+}
+
+func (i *identityChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if !i.stub.Now.IsZero() {
+		i.stub.TxTimestamp, _ = ptypes.TimestampProto(i.stub.Now)
+	}
+	return i.stub.cc.Invoke(i.stub)
+}
+
+func NewIdentityStub(name string, cc shim.Chaincode) *IdentityStub {
+	stub := &IdentityStub{cc: cc}
+	stub.MockStub = shim.NewMockStub(name, &identityChaincode{stub})
+	return stub
+}
+
+func (s *IdentityStub) GetCreator() ([]byte, error) {
+	return s.Creator, nil
+}
+
+// As makes the following invocations come from commonName of mspID.
+func (s *IdentityStub) As(mspID, commonName string, attrs map[string]string) *IdentityStub {
+	s.Creator = Identity(mspID, commonName, attrs)
+	return s
+}
+
+// At makes the following invocations happen at now.
+func (s *IdentityStub) At(now time.Time) *IdentityStub {
+	s.Now = now
+	return s
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"fmt"
+	"marbles-meetup/logging"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// LogSink captures every entry written through the logging package so
+// tests can assert on log output. Close restores the previous sink and level.
+type LogSink struct {
+	lock     sync.Mutex
+	entries  []*logging.Entry
+	previous logging.Sink
+	level    logging.Level
+}
+
+func NewLogSink(level logging.Level) *LogSink {
+	sink := &LogSink{level: logging.GetLevel()}
+	sink.previous = logging.SetSink(sink)
+	logging.SetLevel(level)
+	return sink
+}
+
+func (s *LogSink) Write(entry *logging.Entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, entry)
+}
+
+func (s *LogSink) Entries() []*logging.Entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*logging.Entry(nil), s.entries...)
+}
+
+func (s *LogSink) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = nil
+}
+
+func (s *LogSink) Close() {
+	logging.SetSink(s.previous)
+	logging.SetLevel(s.level)
+}
+
+// Find returns the first entry with the given level and message whose
+// fields include every key/value in fields, or nil.
+func (s *LogSink) Find(level logging.Level, message string, fields map[string]interface{}) *logging.Entry {
+	for _, entry := range s.Entries() {
+		if entry.Level != level || entry.Message != message {
+			continue
+		}
+		matched := true
+		for key, value := range fields {
+			if fmt.Sprint(entry.Fields[key]) != fmt.Sprint(value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return entry
+		}
+	}
+	return nil
+}
+
+func CheckLog(t *testing.T, sink *LogSink, level logging.Level, message string, fields map[string]interface{}) {
+	t.Helper()
+	if sink.Find(level, message, fields) == nil {
+		var written []string
+		for _, entry := range sink.Entries() {
+			written = append(written, fmt.Sprintf("  %s %s %v", entry.Level, entry.Message, entry.Fields))
+		}
+		t.Fatalf("Log %s %s %v was not written, got:\n%s", level, message, fields, strings.Join(written, "\n"))
+	}
+}
+
+func CheckNoLog(t *testing.T, sink *LogSink, level logging.Level, message string) {
+	t.Helper()
+	if sink.Find(level, message, nil) != nil {
+		t.Fatalf("Log %s %s was written", level, message)
+	}
+}
@@ -0,0 +1,226 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// RangeRead is the [Start, End) bounds of a range query.
+type RangeRead struct {
+	Start string
+	End   string
+}
+
+// Access is the read/write set of a single Init or Invoke. Scanned holds
+// the keys returned by range reads.
+type Access struct {
+	TxID       string
+	Function   string
+	Reads      []string
+	RangeReads []RangeRead
+	Scanned    []string
+	Writes     []string
+	Deletes    []string
+	previous   map[string][]byte
+}
+
+// Touched is the number of keys read, scanned, written or deleted.
+func (a *Access) Touched() int {
+	return len(a.Reads) + len(a.Scanned) + len(a.Writes) + len(a.Deletes)
+}
+
+// RecordingStub is a shim.MockStub that hands itself to the chaincode and
+// records every key the chaincode touches per invocation. Since the
+// embedded MockStub invokes the recording stub, MockInvoke, CheckInvoke and
+// the other helpers record as well.
+type RecordingStub struct {
+	*shim.MockStub
+	cc       shim.Chaincode
+	current  *Access
+	Accesses []*Access
+}
+
+type recordingChaincode struct {
+	stub *RecordingStub
+}
+
+func (r *recordingChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	r.stub.start()
+	return r.stub.cc.Init(r.stub)
+}
+
+func (r *recordingChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	r.stub.start()
+	return r.stub.cc.Invoke(r.stub)
+}
+
+func NewRecordingStub(name string, cc shim.Chaincode) *RecordingStub {
+	stub := &RecordingStub{cc: cc}
+	stub.MockStub = shim.NewMockStub(name, &recordingChaincode{stub})
+	return stub
+}
+
+func (s *RecordingStub) start() {
+	function, _ := s.GetFunctionAndParameters()
+	s.current = &Access{TxID: s.GetTxID(), Function: function, previous: make(map[string][]byte)}
+	s.Accesses = append(s.Accesses, s.current)
+}
+
+// LastAccess returns the read/write set of the latest invocation.
+func (s *RecordingStub) LastAccess() *Access {
+	if len(s.Accesses) == 0 {
+		return &Access{}
+	}
+	return s.Accesses[len(s.Accesses)-1]
+}
+
+func (s *RecordingStub) GetState(key string) ([]byte, error) {
+	s.current.Reads = append(s.current.Reads, key)
+	return s.MockStub.GetState(key)
+}
+
+// RevertLast restores every key written or deleted by the latest
+// invocation to its value before it.
+func (s *RecordingStub) RevertLast() {
+	access := s.LastAccess()
+	s.MockTransactionStart("revert")
+	defer s.MockTransactionEnd("revert")
+	for key, value := range access.previous {
+		if value == nil {
+			s.MockStub.DelState(key)
+		} else {
+			s.MockStub.PutState(key, value)
+		}
+	}
+}
+
+func (s *RecordingStub) remember(key string) {
+	if _, exists := s.current.previous[key]; !exists {
+		s.current.previous[key] = s.MockStub.State[key]
+	}
+}
+
+func (s *RecordingStub) PutState(key string, value []byte) error {
+	s.remember(key)
+	s.current.Writes = append(s.current.Writes, key)
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *RecordingStub) DelState(key string) error {
+	s.remember(key)
+	s.current.Deletes = append(s.current.Deletes, key)
+	return s.MockStub.DelState(key)
+}
+
+func (s *RecordingStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	s.current.RangeReads = append(s.current.RangeReads, RangeRead{startKey, endKey})
+	iterator, err := s.MockStub.GetStateByRange(startKey, endKey)
+	return s.scan(iterator), err
+}
+
+func (s *RecordingStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	s.current.RangeReads = append(s.current.RangeReads, RangeRead{partialKey, partialKey + string(rune(0x10FFFF))})
+	iterator, err := s.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	return s.scan(iterator), err
+}
+
+func (s *RecordingStub) scan(iterator shim.StateQueryIteratorInterface) shim.StateQueryIteratorInterface {
+	if iterator == nil {
+		return nil
+	}
+	return &recordingIterator{iterator, s.current}
+}
+
+type recordingIterator struct {
+	shim.StateQueryIteratorInterface
+	access *Access
+}
+
+func (i *recordingIterator) Next() (*queryresult.KV, error) {
+	kv, err := i.StateQueryIteratorInterface.Next()
+	if kv != nil {
+		i.access.Scanned = append(i.access.Scanned, kv.Key)
+	}
+	return kv, err
+}
+
+// AssertNoReadOf fails if key was point read or fell inside a range read.
+func (a *Access) AssertNoReadOf(t *testing.T, key string) {
+	t.Helper()
+	for _, read := range a.Reads {
+		if read == key {
+			t.Fatalf("%s (%s) read %s", a.Function, a.TxID, FormatKey(key))
+		}
+	}
+	for _, rangeRead := range a.RangeReads {
+		if rangeRead.Start <= key && key < rangeRead.End {
+			t.Fatalf("%s (%s) read %s by range from %s", a.Function, a.TxID, FormatKey(key), FormatKey(rangeRead.Start))
+		}
+	}
+}
+
+// AssertReads fails unless every key was point read.
+func (a *Access) AssertReads(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if !contains(a.Reads, key) {
+			t.Fatalf("%s (%s) did not read %s", a.Function, a.TxID, FormatKey(key))
+		}
+	}
+}
+
+// AssertRangeReads fails unless at least one range read was made and every
+// range read starts with prefix.
+func (a *Access) AssertRangeReads(t *testing.T, prefix string) {
+	t.Helper()
+	if len(a.RangeReads) == 0 {
+		t.Fatalf("%s (%s) made no range read", a.Function, a.TxID)
+	}
+	for _, rangeRead := range a.RangeReads {
+		if !strings.HasPrefix(rangeRead.Start, prefix) {
+			t.Fatalf("%s (%s) range read from %s outside %s", a.Function, a.TxID, FormatKey(rangeRead.Start), FormatKey(prefix))
+		}
+	}
+}
+
+func (a *Access) AssertNoRangeReads(t *testing.T) {
+	t.Helper()
+	if len(a.RangeReads) != 0 {
+		t.Fatalf("%s (%s) made %d range reads from %s", a.Function, a.TxID, len(a.RangeReads), FormatKey(a.RangeReads[0].Start))
+	}
+}
+
+// AssertWritesOnly fails if a key was written or deleted that starts with
+// none of the given prefixes. A full key is its own prefix.
+func (a *Access) AssertWritesOnly(t *testing.T, prefixes ...string) {
+	t.Helper()
+	for _, key := range append(append([]string(nil), a.Writes...), a.Deletes...) {
+		matched := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			t.Fatalf("%s (%s) wrote unexpected key %s", a.Function, a.TxID, FormatKey(key))
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
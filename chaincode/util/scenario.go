@@ -0,0 +1,277 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is a chaincode test written as YAML, e.g.
+//
+//	name: transfer to bob
+//	chaincode: highThroughput
+//	steps:
+//	  - invoke: initMarbles
+//	    args: [RedMarble, red, 30, 100000, alice]
+//	  - invoke: transferMarbles
+//	    args: {name: RedMarble, sender: alice, receiver: bob, amount: 20}
+//	    txid: transfer1
+//	  - query: readMarbles
+//	    args: [RedMarble, bob]
+//	    expect:
+//	      payload: {marble: {docType: marble, name: RedMarble, color: red, size: 30}, owner: bob, amount: 20}
+//	state:
+//	  - composite: [Transfer/name/sender/receiver/amount/txid, RedMarble, alice, bob, "20", transfer1]
+//	  - key: RedMarble
+//	    exists: false
+type Scenario struct {
+	Name      string          `yaml:"name"`
+	Chaincode string          `yaml:"chaincode"`
+	Init      []string        `yaml:"init"`
+	Steps     []ScenarioStep  `yaml:"steps"`
+	State     []ScenarioState `yaml:"state"`
+	path      string
+}
+
+// ScenarioStep is a single invoke or query. Args are either a list, sent
+// positionally, or a map, sent as a single JSON object argument.
+type ScenarioStep struct {
+	Name   string         `yaml:"name"`
+	Invoke string         `yaml:"invoke"`
+	Query  string         `yaml:"query"`
+	Args   interface{}    `yaml:"args"`
+	TxID   string         `yaml:"txid"`
+	Expect ScenarioExpect `yaml:"expect"`
+}
+
+// ScenarioExpect defaults to status 200. Error is matched as a substring of
+// the response message and implies status 500. Payload is compared as JSON.
+type ScenarioExpect struct {
+	Status  int32       `yaml:"status"`
+	Error   string      `yaml:"error"`
+	Payload interface{} `yaml:"payload"`
+}
+
+// ScenarioState is a key expected in the final state, given either as a
+// plain key or as the objectType and attributes of a composite key.
+// Value is compared as a string, JSON as JSON. Exists defaults to true.
+type ScenarioState struct {
+	Key       string      `yaml:"key"`
+	Composite []string    `yaml:"composite"`
+	Exists    *bool       `yaml:"exists"`
+	Value     *string     `yaml:"value"`
+	JSON      interface{} `yaml:"json"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	scenarioBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{path: path}
+	if err := yaml.UnmarshalStrict(scenarioBytes, scenario); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	for i, step := range scenario.Steps {
+		if (step.Invoke == "") == (step.Query == "") {
+			return nil, fmt.Errorf("%s: step %d needs either invoke or query", path, i)
+		}
+	}
+	return scenario, nil
+}
+
+// toJSONValue turns YAML maps into JSON objects and normalizes numbers the
+// way encoding/json decodes them, so both sides compare with JSONDiff.
+func toJSONValue(value interface{}) (interface{}, error) {
+	valueBytes, err := json.Marshal(toStringKeys(value))
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = json.Unmarshal(valueBytes, &normalized)
+	return normalized, err
+}
+
+func toStringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, v := range value {
+			converted[fmt.Sprint(key)] = toStringKeys(v)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, v := range value {
+			converted[i] = toStringKeys(v)
+		}
+		return converted
+	}
+	return value
+}
+
+func (s *ScenarioStep) function() string {
+	if s.Invoke != "" {
+		return s.Invoke
+	}
+	return s.Query
+}
+
+func (s *ScenarioStep) args() ([][]byte, error) {
+	args := [][]byte{[]byte(s.function())}
+	switch stepArgs := s.Args.(type) {
+	case nil:
+	case []interface{}:
+		for _, arg := range stepArgs {
+			args = append(args, []byte(fmt.Sprint(arg)))
+		}
+	case map[interface{}]interface{}:
+		objectBytes, err := json.Marshal(toStringKeys(stepArgs))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, objectBytes)
+	default:
+		return nil, fmt.Errorf("args must be a list or a map")
+	}
+	return args, nil
+}
+
+func (s *ScenarioState) key(stub shim.ChaincodeStubInterface) (string, error) {
+	if len(s.Composite) > 0 {
+		return stub.CreateCompositeKey(s.Composite[0], s.Composite[1:])
+	}
+	return s.Key, nil
+}
+
+// RunScenario runs scenario on a fresh RecordingStub of the chaincode it
+// names. Query steps must not write state.
+func RunScenario(t *testing.T, scenario *Scenario, chaincodes map[string]func() shim.Chaincode) {
+	t.Helper()
+	fail := func(format string, args ...interface{}) {
+		t.Helper()
+		t.Fatalf("Scenario %s (%s): %s", scenario.Name, scenario.path, fmt.Sprintf(format, args...))
+	}
+	newChaincode, exists := chaincodes[scenario.Chaincode]
+	if !exists {
+		fail("unknown chaincode %s", scenario.Chaincode)
+	}
+
+	stub := NewRecordingStub(scenario.Chaincode, newChaincode())
+	initArgs := [][]byte{[]byte("init")}
+	for _, arg := range scenario.Init {
+		initArgs = append(initArgs, []byte(arg))
+	}
+	if res := stub.MockInit("init", initArgs); res.Status != shim.OK {
+		fail("init failed: %s", res.Message)
+	}
+
+	for i, step := range scenario.Steps {
+		label := step.Name
+		if label == "" {
+			label = "step " + strconv.Itoa(i) + " " + step.function()
+		}
+		txID := step.TxID
+		if txID == "" {
+			txID = "tx" + strconv.Itoa(i)
+		}
+		args, err := step.args()
+		if err != nil {
+			fail("%s: %s", label, err.Error())
+		}
+
+		res := stub.MockInvoke(txID, args)
+		DrainEvents(stub.MockStub)
+		expectedStatus := step.Expect.Status
+		if expectedStatus == 0 && step.Expect.Error != "" {
+			expectedStatus = shim.ERROR
+		} else if expectedStatus == 0 {
+			expectedStatus = shim.OK
+		}
+		if res.Status != expectedStatus {
+			fail("%s: status %d (%s) was not %d as expected", label, res.Status, res.Message, expectedStatus)
+		}
+		if !strings.Contains(res.Message, step.Expect.Error) {
+			fail("%s: error %q does not contain %q", label, res.Message, step.Expect.Error)
+		}
+		if step.Query != "" {
+			if access := stub.LastAccess(); len(access.Writes)+len(access.Deletes) > 0 {
+				fail("%s: query wrote state", label)
+			}
+		}
+		if step.Expect.Payload != nil {
+			expected, err := toJSONValue(step.Expect.Payload)
+			if err != nil {
+				fail("%s: %s", label, err.Error())
+			}
+			var actual interface{}
+			if err := json.Unmarshal(res.Payload, &actual); err != nil {
+				fail("%s: payload %s is not JSON", label, string(res.Payload))
+			}
+			if diff := JSONDiff(expected, actual); diff != nil {
+				fail("%s: payload differs from expected JSON:\n%s", label, strings.Join(diff, "\n"))
+			}
+		}
+	}
+
+	for _, state := range scenario.State {
+		key, err := state.key(stub)
+		if err != nil {
+			fail("state %v: %s", state.Composite, err.Error())
+		}
+		value, exists := stub.State[key]
+		if state.Exists != nil && !*state.Exists {
+			if exists {
+				fail("state %s exists", FormatKey(key))
+			}
+			continue
+		}
+		if !exists {
+			fail("state %s does not exist", FormatKey(key))
+		}
+		if state.Value != nil && string(value) != *state.Value {
+			fail("state %s value %s was not %s as expected", FormatKey(key), FormatValue(value), FormatValue([]byte(*state.Value)))
+		}
+		if state.JSON != nil {
+			expected, err := toJSONValue(state.JSON)
+			if err != nil {
+				fail("state %s: %s", FormatKey(key), err.Error())
+			}
+			var actual interface{}
+			if err := json.Unmarshal(value, &actual); err != nil {
+				fail("state %s value %s is not JSON", FormatKey(key), FormatValue(value))
+			}
+			if diff := JSONDiff(expected, actual); diff != nil {
+				fail("state %s differs from expected JSON:\n%s", FormatKey(key), strings.Join(diff, "\n"))
+			}
+		}
+	}
+}
+
+// RunScenarios runs every scenario file matching pattern as a subtest.
+func RunScenarios(t *testing.T, pattern string, chaincodes map[string]func() shim.Chaincode) {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No scenarios match %s", pattern)
+	}
+	for _, path := range paths {
+		scenario, err := LoadScenario(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		t.Run(scenario.Name, func(t *testing.T) {
+			RunScenario(t, scenario, chaincodes)
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package util
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -9,46 +12,78 @@ import (
 )
 
 func CheckState(t *testing.T, stub *shim.MockStub, name string, value string) {
+	t.Helper()
 	byteState := stub.State[name]
 	if byteState == nil {
-		fmt.Println("State", name, "failed to get value")
-		t.FailNow()
+		t.Errorf("State %s failed to get value", FormatKey(name))
+		return
 	}
-	if string(byteState) != value {
-		fmt.Println("State value", string(byteState), "was not", value, "as expected")
-		t.FailNow()
+	if string(byteState) == value {
+		return
+	}
+	if diff, bothJSON := compareJSON(value, byteState); !bothJSON {
+		t.Errorf("State %s value %s was not %s as expected", FormatKey(name), FormatValue(byteState), FormatValue([]byte(value)))
+	} else if diff != nil {
+		t.Errorf("State %s differs from expected JSON:\n%s", FormatKey(name), strings.Join(diff, "\n"))
+	}
+}
+
+// DrainEvents drops the events stub buffered. MockStub buffers 100 events
+// and then blocks SetEvent, which long workloads of charged transfers reach.
+func DrainEvents(stub *shim.MockStub) {
+	for len(stub.ChaincodeEventsChannel) > 0 {
+		<-stub.ChaincodeEventsChannel
 	}
 }
 
 func CheckStateNotExisted(t *testing.T, stub *shim.MockStub, name string) {
+	t.Helper()
 	byteState := stub.State[name]
 	if byteState != nil {
-		fmt.Println("State", name, "is existed")
-		t.FailNow()
+		t.Errorf("State %s is existed with value %s", FormatKey(name), FormatValue(byteState))
 	}
 }
 
+// CheckQuery compares the payload with expect as JSON when both are JSON,
+// so field order and number formatting do not matter, and as plain text
+// otherwise.
 func CheckQuery(t *testing.T, stub *shim.MockStub, args [][]byte, expect string, txId string) {
+	t.Helper()
 	res := stub.MockInvoke(txId, args)
 	if res.Status != shim.OK {
-		fmt.Println("Query (", convertArgToString(args), ") failed", string(res.Message))
-		t.FailNow()
+		t.Fatalf("Query (%s) failed: %s", convertArgToString(args), res.Message)
 	}
 	if res.Payload == nil {
-		fmt.Println("Query (", convertArgToString(args), ") failed to get result")
-		t.FailNow()
+		t.Fatalf("Query (%s) failed to get result", convertArgToString(args))
 	}
-	if string(res.Payload) != expect {
-		fmt.Println("Query result ", string(res.Payload), "was not", expect, "as expected")
-		t.FailNow()
+	if string(res.Payload) == expect {
+		return
+	}
+	if diff, bothJSON := compareJSON(expect, res.Payload); !bothJSON {
+		t.Errorf("Query (%s) result %s was not %s as expected", convertArgToString(args), string(res.Payload), expect)
+	} else if diff != nil {
+		t.Errorf("Query (%s) result differs from expected JSON:\n%s", convertArgToString(args), strings.Join(diff, "\n"))
 	}
 }
 
 func CheckInvoke(t *testing.T, stub *shim.MockStub, args [][]byte, txId string) {
+	t.Helper()
 	res := stub.MockInvoke(txId, args)
 	if res.Status != shim.OK {
-		fmt.Println("Invoke (", convertArgToString(args), ") failed: ", string(res.Message))
-		t.FailNow()
+		t.Fatalf("Invoke (%s) failed: %s", convertArgToString(args), res.Message)
+	}
+}
+
+// CheckInvokeFails expects the invocation to fail with a message that
+// contains expectedErr.
+func CheckInvokeFails(t *testing.T, stub *shim.MockStub, args [][]byte, expectedErr string, txId string) {
+	t.Helper()
+	res := stub.MockInvoke(txId, args)
+	if res.Status == shim.OK {
+		t.Fatalf("Invoke (%s) succeeded, expected error %q", convertArgToString(args), expectedErr)
+	}
+	if !strings.Contains(res.Message, expectedErr) {
+		t.Errorf("Invoke (%s) failed with %q, expected error %q", convertArgToString(args), res.Message, expectedErr)
 	}
 }
 
@@ -59,4 +94,112 @@ func convertArgToString(args [][]byte) string {
 		strs = append(strs, arg)
 	}
 	return strings.Join(strs, ", ")
-}
\ No newline at end of file
+}
+
+// compareJSON returns the differences between two JSON documents. bothJSON
+// is false if either one does not parse.
+func compareJSON(expected string, actual []byte) (diff []string, bothJSON bool) {
+	var expectedValue, actualValue interface{}
+	if json.Unmarshal([]byte(expected), &expectedValue) != nil || json.Unmarshal(actual, &actualValue) != nil {
+		return nil, false
+	}
+	return JSONDiff(expectedValue, actualValue), true
+}
+
+// JSONDiff lists every path at which two decoded JSON values differ, e.g.
+// "$.marble.color: expected "red", got "blue"". It returns nil when the
+// values are semantically equal.
+func JSONDiff(expected, actual interface{}) []string {
+	var diff []string
+	jsonDiff("$", expected, actual, &diff)
+	return diff
+}
+
+func jsonDiff(path string, expected, actual interface{}, diff *[]string) {
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		actualValue, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for key := range expectedValue {
+			keys[key] = true
+		}
+		for key := range actualValue {
+			keys[key] = true
+		}
+		var sorted []string
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			e, inExpected := expectedValue[key]
+			a, inActual := actualValue[key]
+			switch {
+			case !inActual:
+				*diff = append(*diff, fmt.Sprintf("%s.%s: missing, expected %s", path, key, renderJSON(e)))
+			case !inExpected:
+				*diff = append(*diff, fmt.Sprintf("%s.%s: unexpected %s", path, key, renderJSON(a)))
+			default:
+				jsonDiff(path+"."+key, e, a, diff)
+			}
+		}
+		return
+	case []interface{}:
+		actualValue, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(expectedValue) || i < len(actualValue); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(actualValue):
+				*diff = append(*diff, fmt.Sprintf("%s: missing, expected %s", itemPath, renderJSON(expectedValue[i])))
+			case i >= len(expectedValue):
+				*diff = append(*diff, fmt.Sprintf("%s: unexpected %s", itemPath, renderJSON(actualValue[i])))
+			default:
+				jsonDiff(itemPath, expectedValue[i], actualValue[i], diff)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		*diff = append(*diff, fmt.Sprintf("%s: expected %s, got %s", path, renderJSON(expected), renderJSON(actual)))
+	}
+}
+
+func renderJSON(value interface{}) string {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(valueBytes)
+}
+
+// FormatKey renders a composite key as objectType("attr", ...) so that the
+// 0x00 separators and empty attributes are visible. Other keys are quoted
+// only if they are not printable.
+func FormatKey(key string) string {
+	if !strings.HasPrefix(key, "\x00") {
+		return FormatValue([]byte(key))
+	}
+	parts := strings.Split(strings.TrimSuffix(key[1:], "\x00"), "\x00")
+	attributes := make([]string, 0, len(parts)-1)
+	for _, attribute := range parts[1:] {
+		attributes = append(attributes, fmt.Sprintf("%q", attribute))
+	}
+	return parts[0] + "(" + strings.Join(attributes, ", ") + ")"
+}
+
+// FormatValue renders state values that are not printable text, such as
+// the 0x00 marker of the high throughput delta rows, with escapes.
+func FormatValue(value []byte) string {
+	for _, r := range string(value) {
+		if r < 0x20 || r == 0x7f || r == 0xFFFD {
+			return fmt.Sprintf("%q", string(value))
+		}
+	}
+	return string(value)
+}
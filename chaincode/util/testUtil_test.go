@@ -0,0 +1,78 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type echoChaincode struct {
+}
+
+func (t *echoChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *echoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "fail" {
+		return shim.Error(strings.Join(args, " "))
+	}
+	return shim.Success([]byte(strings.Join(args, " ")))
+}
+
+func decode(t *testing.T, value string) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+	return decoded
+}
+
+func Test_UTIL_JSONDiff_success(t *testing.T) {
+	expected := decode(t, `{"marble":{"name":"RedMarble","color":"red"},"owner":"bob","amount":20,"tags":[1,2]}`)
+
+	same := decode(t, `{"amount":20.0,"tags":[1,2],"owner":"bob","marble":{"color":"red","name":"RedMarble"}}`)
+	if diff := JSONDiff(expected, same); diff != nil {
+		t.Errorf("Reordered JSON should be equal, got %v", diff)
+	}
+
+	other := decode(t, `{"marble":{"name":"RedMarble","color":"blue"},"amount":"20","tags":[1],"extra":true}`)
+	expectedDiff := []string{
+		`$.amount: expected 20, got "20"`,
+		`$.extra: unexpected true`,
+		`$.marble.color: expected "red", got "blue"`,
+		`$.owner: missing, expected "bob"`,
+		`$.tags[1]: missing, expected 2`,
+	}
+	if diff := JSONDiff(expected, other); strings.Join(diff, "\n") != strings.Join(expectedDiff, "\n") {
+		t.Errorf("Diff was\n%s\nexpected\n%s", strings.Join(diff, "\n"), strings.Join(expectedDiff, "\n"))
+	}
+}
+
+func Test_UTIL_FormatKey_success(t *testing.T) {
+	stub := shim.NewMockStub("util", new(echoChaincode))
+	key, _ := stub.CreateCompositeKey("Transfer/name/sender/receiver/amount/txid", []string{"RedMarble", "", "alice", "100", "tx1"})
+
+	expected := `Transfer/name/sender/receiver/amount/txid("RedMarble", "", "alice", "100", "tx1")`
+	if FormatKey(key) != expected {
+		t.Errorf("FormatKey was %s, expected %s", FormatKey(key), expected)
+	}
+	if FormatKey("aliceRedMarble") != "aliceRedMarble" {
+		t.Errorf("FormatKey changed plain key to %s", FormatKey("aliceRedMarble"))
+	}
+	if FormatValue([]byte{0x00}) != `"\x00"` {
+		t.Errorf("FormatValue was %s", FormatValue([]byte{0x00}))
+	}
+}
+
+func Test_UTIL_CheckQuery_semantic_success(t *testing.T) {
+	stub := shim.NewMockStub("util", new(echoChaincode))
+
+	CheckQuery(t, stub, [][]byte{[]byte("echo"), []byte(`{"b":1,`), []byte(`"a":2}`)}, `{"a":2,"b":1}`, "1")
+	CheckQuery(t, stub, [][]byte{[]byte("echo"), []byte("plain")}, "plain", "1")
+	CheckInvokeFails(t, stub, [][]byte{[]byte("fail"), []byte("Marble does not exist")}, "does not exist", "1")
+}
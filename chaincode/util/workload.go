@@ -0,0 +1,286 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// OpKind is the marble function a workload step calls. Each target names
+// the function of its chaincode in Functions.
+type OpKind int
+
+const (
+	OP_INIT OpKind = iota
+	OP_TRANSFER
+	OP_READ
+	OP_PRUNE
+)
+
+func (k OpKind) String() string {
+	return [...]string{"init", "transfer", "read", "prune"}[k]
+}
+
+// Op is one step of a generated workload.
+type Op struct {
+	Kind     OpKind
+	Marble   string
+	Owner    string
+	Receiver string
+	Amount   int
+}
+
+// Args is the invocation of the op on a chaincode with functions.
+func (o Op) Args(functions Functions) [][]byte {
+	function := []byte(functions.name(o.Kind))
+	switch o.Kind {
+	case OP_INIT:
+		return [][]byte{function, []byte(o.Marble), []byte("red"), []byte("30"),
+			[]byte(strconv.Itoa(o.Amount)), []byte(o.Owner)}
+	case OP_TRANSFER:
+		return [][]byte{function, []byte(o.Marble), []byte(o.Owner),
+			[]byte(o.Receiver), []byte(strconv.Itoa(o.Amount))}
+	case OP_READ:
+		if o.Owner != "" {
+			return [][]byte{function, []byte(o.Marble), []byte(o.Owner)}
+		}
+	}
+	return [][]byte{function, []byte(o.Marble)}
+}
+
+// String shows the op with its kind in place of a function name.
+func (o Op) String() string {
+	return convertArgToString(o.Args(Functions{"init", "transfer", "read", "prune"}))
+}
+
+// Functions names the marble functions of a chaincode. Prune is empty for
+// chaincodes without pruneMarbles; prune steps are skipped on them.
+type Functions struct {
+	Init     string
+	Transfer string
+	Read     string
+	Prune    string
+}
+
+func (f Functions) name(kind OpKind) string {
+	return [...]string{f.Init, f.Transfer, f.Read, f.Prune}[kind]
+}
+
+// Target is a marble chaincode a workload runs against.
+type Target struct {
+	Name      string
+	Chaincode func() shim.Chaincode
+	Functions Functions
+	// SenderCheck is true when transferMarbles rejects amounts above the
+	// sender balance, so no balance may become negative.
+	SenderCheck bool
+}
+
+// runs is false for the prune steps of a target without pruneMarbles.
+func (t Target) runs(op Op) bool {
+	return op.Kind != OP_PRUNE || t.Functions.Prune != ""
+}
+
+type WorkloadConfig struct {
+	Marbles   []string
+	Owners    []string
+	Steps     int
+	MaxSupply int
+}
+
+var DefaultWorkload = WorkloadConfig{
+	Marbles:   []string{"RedMarble", "BlueMarble"},
+	Owners:    []string{"alice", "bob", "carol", "dave"},
+	Steps:     60,
+	MaxSupply: 1000,
+}
+
+// GenerateWorkload returns the same init, transfer and prune sequence for
+// the same seed and config. Transfers may exceed the sender balance, move
+// marbles to the sender itself or name a marble that was never created.
+func GenerateWorkload(seed int64, config WorkloadConfig) []Op {
+	random := rand.New(rand.NewSource(seed))
+	owner := func() string {
+		return config.Owners[random.Intn(len(config.Owners))]
+	}
+	marble := func() string {
+		return config.Marbles[random.Intn(len(config.Marbles))]
+	}
+
+	var ops []Op
+	for _, name := range config.Marbles[:len(config.Marbles)-1] {
+		ops = append(ops, Op{Kind: OP_INIT, Marble: name, Owner: owner(), Amount: 1 + random.Intn(config.MaxSupply)})
+	}
+	for len(ops) < config.Steps {
+		switch n := random.Intn(20); {
+		case n < 2:
+			ops = append(ops, Op{Kind: OP_INIT, Marble: marble(), Owner: owner(), Amount: 1 + random.Intn(config.MaxSupply)})
+		case n < 4:
+			ops = append(ops, Op{Kind: OP_PRUNE, Marble: marble()})
+		default:
+			ops = append(ops, Op{Kind: OP_TRANSFER, Marble: marble(), Owner: owner(), Receiver: owner(),
+				Amount: 1 + random.Intn(config.MaxSupply/4)})
+		}
+	}
+	return ops
+}
+
+// WorkloadError is the first invariant violated by a workload.
+type WorkloadError struct {
+	Step    int
+	Op      Op
+	Message string
+}
+
+func (e *WorkloadError) Error() string {
+	return fmt.Sprintf("step %d (%s): %s", e.Step, e.Op, e.Message)
+}
+
+// Balances reads the balance of every owner of marble through the read
+// function of functions.
+func Balances(stub *shim.MockStub, functions Functions, marble string, owners []string) (map[string]int, error) {
+	balances := make(map[string]int)
+	for _, owner := range owners {
+		res := stub.MockInvoke("read", Op{Kind: OP_READ, Marble: marble, Owner: owner}.Args(functions))
+		if res.Status != shim.OK {
+			return nil, fmt.Errorf("read %s of %s failed: %s", marble, owner, res.Message)
+		}
+		result := struct {
+			Amount int `json:"amount"`
+		}{}
+		if err := json.Unmarshal(res.Payload, &result); err != nil {
+			return nil, err
+		}
+		balances[owner] = result.Amount
+	}
+	return balances, nil
+}
+
+/**
+ * RunWorkload - run ops on a fresh stub of target and check after every step
+ *	- the balances of a marble sum up to the amount it was created with
+ *	- no balance is negative, if the target checks the sender balance
+ *	- pruneMarbles leaves every balance unchanged
+ *
+ * @return the first violation as a *WorkloadError, or nil
+ */
+func RunWorkload(target Target, ops []Op) error {
+	stub := shim.NewMockStub(target.Name, target.Chaincode())
+	stub.MockInit("init", [][]byte{[]byte("init")})
+
+	owners := make(map[string]bool)
+	supply := make(map[string]int)
+	for step, op := range ops {
+		if !target.runs(op) {
+			continue
+		}
+		fail := func(format string, args ...interface{}) error {
+			return &WorkloadError{step, op, fmt.Sprintf(format, args...)}
+		}
+		owners[op.Owner] = true
+		if op.Receiver != "" {
+			owners[op.Receiver] = true
+		}
+		delete(owners, "")
+		var ownerList []string
+		for owner := range owners {
+			ownerList = append(ownerList, owner)
+		}
+
+		var before map[string]int
+		if _, created := supply[op.Marble]; created && op.Kind == OP_PRUNE {
+			var err error
+			if before, err = Balances(stub, target.Functions, op.Marble, ownerList); err != nil {
+				return fail("%s", err.Error())
+			}
+		}
+
+		res := stub.MockInvoke("tx"+strconv.Itoa(step), op.Args(target.Functions))
+		DrainEvents(stub)
+		if res.Status == shim.OK && op.Kind == OP_INIT {
+			supply[op.Marble] = op.Amount
+		}
+
+		for marble, total := range supply {
+			balances, err := Balances(stub, target.Functions, marble, ownerList)
+			if err != nil {
+				return fail("%s", err.Error())
+			}
+			sum := 0
+			for owner, balance := range balances {
+				sum += balance
+				if target.SenderCheck && balance < 0 {
+					return fail("%s holds %d %s", owner, balance, marble)
+				}
+				if before != nil && marble == op.Marble && before[owner] != balance {
+					return fail("prune changed %s of %s from %d to %d", marble, owner, before[owner], balance)
+				}
+			}
+			if sum != total {
+				return fail("%s balances sum up to %d, created with %d", marble, sum, total)
+			}
+		}
+	}
+	return nil
+}
+
+// ShrinkWorkload removes steps and lowers amounts of a failing workload
+// for as long as it keeps failing, and returns the smallest one found.
+func ShrinkWorkload(target Target, ops []Op) []Op {
+	fails := func(candidate []Op) bool {
+		return RunWorkload(target, candidate) != nil
+	}
+
+	for size := len(ops) / 2; size >= 1; {
+		removed := false
+		for start := 0; start+size <= len(ops); {
+			candidate := append(append([]Op(nil), ops[:start]...), ops[start+size:]...)
+			if fails(candidate) {
+				ops = candidate
+				removed = true
+			} else {
+				start += size
+			}
+		}
+		if !removed {
+			size /= 2
+		}
+	}
+
+	for i := range ops {
+		for ops[i].Amount > 1 {
+			candidate := append([]Op(nil), ops...)
+			candidate[i].Amount /= 2
+			if !fails(candidate) {
+				break
+			}
+			ops = candidate
+		}
+	}
+	return ops
+}
+
+// CheckWorkloads runs a generated workload per seed against target and
+// prints a shrunk reproducer for the first failing seed.
+func CheckWorkloads(t *testing.T, target Target, config WorkloadConfig, seeds ...int64) {
+	t.Helper()
+	for _, seed := range seeds {
+		ops := GenerateWorkload(seed, config)
+		if err := RunWorkload(target, ops); err == nil {
+			continue
+		}
+
+		ops = ShrinkWorkload(target, ops)
+		err := RunWorkload(target, ops)
+		var steps []string
+		for step, op := range ops {
+			steps = append(steps, fmt.Sprintf("  %d: %s", step, op))
+		}
+		t.Fatalf("Workload seed %d on %s failed at %s\nMinimal workload:\n%s", seed, target.Name, err.Error(), strings.Join(steps, "\n"))
+	}
+}